@@ -2,41 +2,44 @@
 package agentfakes
 
 import (
+	"context"
 	"sync"
 
 	"github.com/acrmp/minimalprompt/agent"
 )
 
 type FakeCommandExecutor struct {
-	ExecuteStub        func(string) (string, error)
+	ExecuteStub        func(context.Context, string) (agent.CommandResult, error)
 	executeMutex       sync.RWMutex
 	executeArgsForCall []struct {
-		arg1 string
+		arg1 context.Context
+		arg2 string
 	}
 	executeReturns struct {
-		result1 string
+		result1 agent.CommandResult
 		result2 error
 	}
 	executeReturnsOnCall map[int]struct {
-		result1 string
+		result1 agent.CommandResult
 		result2 error
 	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeCommandExecutor) Execute(arg1 string) (string, error) {
+func (fake *FakeCommandExecutor) Execute(arg1 context.Context, arg2 string) (agent.CommandResult, error) {
 	fake.executeMutex.Lock()
 	ret, specificReturn := fake.executeReturnsOnCall[len(fake.executeArgsForCall)]
 	fake.executeArgsForCall = append(fake.executeArgsForCall, struct {
-		arg1 string
-	}{arg1})
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
 	stub := fake.ExecuteStub
 	fakeReturns := fake.executeReturns
-	fake.recordInvocation("Execute", []interface{}{arg1})
+	fake.recordInvocation("Execute", []interface{}{arg1, arg2})
 	fake.executeMutex.Unlock()
 	if stub != nil {
-		return stub(arg1)
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -50,41 +53,41 @@ func (fake *FakeCommandExecutor) ExecuteCallCount() int {
 	return len(fake.executeArgsForCall)
 }
 
-func (fake *FakeCommandExecutor) ExecuteCalls(stub func(string) (string, error)) {
+func (fake *FakeCommandExecutor) ExecuteCalls(stub func(context.Context, string) (agent.CommandResult, error)) {
 	fake.executeMutex.Lock()
 	defer fake.executeMutex.Unlock()
 	fake.ExecuteStub = stub
 }
 
-func (fake *FakeCommandExecutor) ExecuteArgsForCall(i int) string {
+func (fake *FakeCommandExecutor) ExecuteArgsForCall(i int) (context.Context, string) {
 	fake.executeMutex.RLock()
 	defer fake.executeMutex.RUnlock()
 	argsForCall := fake.executeArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
-func (fake *FakeCommandExecutor) ExecuteReturns(result1 string, result2 error) {
+func (fake *FakeCommandExecutor) ExecuteReturns(result1 agent.CommandResult, result2 error) {
 	fake.executeMutex.Lock()
 	defer fake.executeMutex.Unlock()
 	fake.ExecuteStub = nil
 	fake.executeReturns = struct {
-		result1 string
+		result1 agent.CommandResult
 		result2 error
 	}{result1, result2}
 }
 
-func (fake *FakeCommandExecutor) ExecuteReturnsOnCall(i int, result1 string, result2 error) {
+func (fake *FakeCommandExecutor) ExecuteReturnsOnCall(i int, result1 agent.CommandResult, result2 error) {
 	fake.executeMutex.Lock()
 	defer fake.executeMutex.Unlock()
 	fake.ExecuteStub = nil
 	if fake.executeReturnsOnCall == nil {
 		fake.executeReturnsOnCall = make(map[int]struct {
-			result1 string
+			result1 agent.CommandResult
 			result2 error
 		})
 	}
 	fake.executeReturnsOnCall[i] = struct {
-		result1 string
+		result1 agent.CommandResult
 		result2 error
 	}{result1, result2}
 }
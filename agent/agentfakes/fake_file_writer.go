@@ -2,17 +2,20 @@
 package agentfakes
 
 import (
+	"context"
 	"sync"
 
 	"github.com/acrmp/minimalprompt/agent"
 )
 
 type FakeFileWriter struct {
-	WriteFileStub        func(string, string) error
+	WriteFileStub        func(context.Context, string, string, bool) error
 	writeFileMutex       sync.RWMutex
 	writeFileArgsForCall []struct {
-		arg1 string
+		arg1 context.Context
 		arg2 string
+		arg3 string
+		arg4 bool
 	}
 	writeFileReturns struct {
 		result1 error
@@ -24,19 +27,21 @@ type FakeFileWriter struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeFileWriter) WriteFile(arg1 string, arg2 string) error {
+func (fake *FakeFileWriter) WriteFile(arg1 context.Context, arg2 string, arg3 string, arg4 bool) error {
 	fake.writeFileMutex.Lock()
 	ret, specificReturn := fake.writeFileReturnsOnCall[len(fake.writeFileArgsForCall)]
 	fake.writeFileArgsForCall = append(fake.writeFileArgsForCall, struct {
-		arg1 string
+		arg1 context.Context
 		arg2 string
-	}{arg1, arg2})
+		arg3 string
+		arg4 bool
+	}{arg1, arg2, arg3, arg4})
 	stub := fake.WriteFileStub
 	fakeReturns := fake.writeFileReturns
-	fake.recordInvocation("WriteFile", []interface{}{arg1, arg2})
+	fake.recordInvocation("WriteFile", []interface{}{arg1, arg2, arg3, arg4})
 	fake.writeFileMutex.Unlock()
 	if stub != nil {
-		return stub(arg1, arg2)
+		return stub(arg1, arg2, arg3, arg4)
 	}
 	if specificReturn {
 		return ret.result1
@@ -50,17 +55,17 @@ func (fake *FakeFileWriter) WriteFileCallCount() int {
 	return len(fake.writeFileArgsForCall)
 }
 
-func (fake *FakeFileWriter) WriteFileCalls(stub func(string, string) error) {
+func (fake *FakeFileWriter) WriteFileCalls(stub func(context.Context, string, string, bool) error) {
 	fake.writeFileMutex.Lock()
 	defer fake.writeFileMutex.Unlock()
 	fake.WriteFileStub = stub
 }
 
-func (fake *FakeFileWriter) WriteFileArgsForCall(i int) (string, string) {
+func (fake *FakeFileWriter) WriteFileArgsForCall(i int) (context.Context, string, string, bool) {
 	fake.writeFileMutex.RLock()
 	defer fake.writeFileMutex.RUnlock()
 	argsForCall := fake.writeFileArgsForCall[i]
-	return argsForCall.arg1, argsForCall.arg2
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4
 }
 
 func (fake *FakeFileWriter) WriteFileReturns(result1 error) {
@@ -2,16 +2,18 @@
 package agentfakes
 
 import (
+	"context"
 	"sync"
 
 	"github.com/acrmp/minimalprompt/agent"
 )
 
 type FakePrompter struct {
-	PromptStub        func(string) (string, error)
+	PromptStub        func(context.Context, string) (string, error)
 	promptMutex       sync.RWMutex
 	promptArgsForCall []struct {
-		arg1 string
+		arg1 context.Context
+		arg2 string
 	}
 	promptReturns struct {
 		result1 string
@@ -25,18 +27,19 @@ type FakePrompter struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakePrompter) Prompt(arg1 string) (string, error) {
+func (fake *FakePrompter) Prompt(arg1 context.Context, arg2 string) (string, error) {
 	fake.promptMutex.Lock()
 	ret, specificReturn := fake.promptReturnsOnCall[len(fake.promptArgsForCall)]
 	fake.promptArgsForCall = append(fake.promptArgsForCall, struct {
-		arg1 string
-	}{arg1})
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
 	stub := fake.PromptStub
 	fakeReturns := fake.promptReturns
-	fake.recordInvocation("Prompt", []interface{}{arg1})
+	fake.recordInvocation("Prompt", []interface{}{arg1, arg2})
 	fake.promptMutex.Unlock()
 	if stub != nil {
-		return stub(arg1)
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -50,17 +53,17 @@ func (fake *FakePrompter) PromptCallCount() int {
 	return len(fake.promptArgsForCall)
 }
 
-func (fake *FakePrompter) PromptCalls(stub func(string) (string, error)) {
+func (fake *FakePrompter) PromptCalls(stub func(context.Context, string) (string, error)) {
 	fake.promptMutex.Lock()
 	defer fake.promptMutex.Unlock()
 	fake.PromptStub = stub
 }
 
-func (fake *FakePrompter) PromptArgsForCall(i int) string {
+func (fake *FakePrompter) PromptArgsForCall(i int) (context.Context, string) {
 	fake.promptMutex.RLock()
 	defer fake.promptMutex.RUnlock()
 	argsForCall := fake.promptArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakePrompter) PromptReturns(result1 string, result2 error) {
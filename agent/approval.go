@@ -0,0 +1,292 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// An ApprovalRequest describes a command or file change that needs the
+// approval of the user.
+type ApprovalRequest struct {
+	// Command is the command to run, if the request is for a command.
+	Command string
+	// Path is the file to change, if the request is for a file change.
+	Path string
+	// Diff shows the change to the file as a unified diff.
+	Diff string
+}
+
+// An Approval is the answer of the user to an ApprovalRequest.
+type Approval struct {
+	// Approved is true if the command or file change may go ahead.
+	Approved bool
+	// Feedback explains a denial to the model.
+	Feedback string
+}
+
+// An ApprovalDeniedError is returned for commands and file changes that the
+// user did not approve.
+type ApprovalDeniedError struct {
+	// Feedback is the explanation of the user for the model, if any.
+	Feedback string
+}
+
+func (e *ApprovalDeniedError) Error() string {
+	if e.Feedback == "" {
+		return "denied by the user"
+	}
+	return fmt.Sprintf("denied by the user with the feedback: %s", e.Feedback)
+}
+
+// An ApprovingExecutor asks an Approver before executing each command with
+// another CommandExecutor.
+type ApprovingExecutor struct {
+	logger   *slog.Logger
+	next     CommandExecutor
+	approver Approver
+}
+
+// NewApprovingExecutor creates an ApprovingExecutor that executes the
+// commands approved by a with next.
+func NewApprovingExecutor(logger *slog.Logger, next CommandExecutor, a Approver) *ApprovingExecutor {
+	return &ApprovingExecutor{logger: logger, next: next, approver: a}
+}
+
+// Execute executes the command if it is approved.
+// It returns an ApprovalDeniedError without executing the command otherwise.
+func (ae *ApprovingExecutor) Execute(ctx context.Context, command string) (CommandResult, error) {
+	if err := approve(ctx, ae.logger, ae.approver, ApprovalRequest{Command: command}); err != nil {
+		return CommandResult{}, err
+	}
+	return ae.next.Execute(ctx, command)
+}
+
+// An ApprovingProcessManager asks an Approver before starting each process
+// with another ProcessManager.
+type ApprovingProcessManager struct {
+	ProcessManager
+	logger   *slog.Logger
+	approver Approver
+}
+
+// NewApprovingProcessManager creates an ApprovingProcessManager that starts
+// the processes approved by a with next.
+func NewApprovingProcessManager(logger *slog.Logger, next ProcessManager, a Approver) *ApprovingProcessManager {
+	return &ApprovingProcessManager{ProcessManager: next, logger: logger, approver: a}
+}
+
+// Start starts the process if its command is approved.
+// It returns an ApprovalDeniedError without starting the process otherwise.
+func (pm *ApprovingProcessManager) Start(ctx context.Context, command string) (string, error) {
+	if err := approve(ctx, pm.logger, pm.approver, ApprovalRequest{Command: command}); err != nil {
+		return "", err
+	}
+	return pm.ProcessManager.Start(ctx, command)
+}
+
+// An ApprovingProgramRunner asks an Approver before running each program
+// with another ProgramRunner. The program is shown to the user as a quoted
+// shell command.
+type ApprovingProgramRunner struct {
+	logger   *slog.Logger
+	next     ProgramRunner
+	approver Approver
+}
+
+// NewApprovingProgramRunner creates an ApprovingProgramRunner that runs the
+// programs approved by a with next.
+func NewApprovingProgramRunner(logger *slog.Logger, next ProgramRunner, a Approver) *ApprovingProgramRunner {
+	return &ApprovingProgramRunner{logger: logger, next: next, approver: a}
+}
+
+// RunProgram runs the program if it is approved.
+// It returns an ApprovalDeniedError without running the program otherwise.
+func (ar *ApprovingProgramRunner) RunProgram(ctx context.Context, argv []string) (CommandResult, error) {
+	if err := approve(ctx, ar.logger, ar.approver, ApprovalRequest{Command: quoteArgv(argv)}); err != nil {
+		return CommandResult{}, err
+	}
+	return ar.next.RunProgram(ctx, argv)
+}
+
+// An ApprovingFileWriter asks an Approver before each file change, showing
+// the change as a diff.
+type ApprovingFileWriter struct {
+	logger   *slog.Logger
+	dir      string
+	fw       FileWriter
+	fe       FileEditor
+	approver Approver
+}
+
+// NewApprovingFileWriter creates an ApprovingFileWriter that makes the
+// changes approved by a with fw and fe. The current content of files is
+// read from dir to show the changes.
+func NewApprovingFileWriter(logger *slog.Logger, dir string, fw FileWriter, fe FileEditor, a Approver) *ApprovingFileWriter {
+	return &ApprovingFileWriter{logger: logger, dir: dir, fw: fw, fe: fe, approver: a}
+}
+
+// WriteFile writes the file if the change is approved.
+// It returns an ApprovalDeniedError without writing the file otherwise.
+func (aw *ApprovingFileWriter) WriteFile(ctx context.Context, path, content string, executable bool) error {
+	old, err := aw.read(path)
+	if err != nil {
+		return err
+	}
+	if err := approve(ctx, aw.logger, aw.approver, ApprovalRequest{Path: path, Diff: lineDiff(path, old, content)}); err != nil {
+		return err
+	}
+	return aw.fw.WriteFile(ctx, path, content, executable)
+}
+
+// EditFile edits the file if the change is approved.
+// It returns an ApprovalDeniedError without editing the file otherwise.
+func (aw *ApprovingFileWriter) EditFile(ctx context.Context, path, oldString, newString string) error {
+	old, err := aw.read(path)
+	if err != nil || oldString == "" || strings.Count(old, oldString) != 1 {
+		// The edit cannot be made, so the error is left to the editor.
+		return aw.fe.EditFile(ctx, path, oldString, newString)
+	}
+	content := strings.Replace(old, oldString, newString, 1)
+	if err := approve(ctx, aw.logger, aw.approver, ApprovalRequest{Path: path, Diff: lineDiff(path, old, content)}); err != nil {
+		return err
+	}
+	return aw.fe.EditFile(ctx, path, oldString, newString)
+}
+
+// ApplyPatch applies the patch if it is approved.
+// It returns an ApprovalDeniedError without changing any files otherwise.
+func (aw *ApprovingFileWriter) ApplyPatch(ctx context.Context, patch string) error {
+	var paths []string
+	fps, err := parsePatch(patch)
+	if err != nil {
+		return aw.fe.ApplyPatch(ctx, patch)
+	}
+	for _, fp := range fps {
+		p := fp.newPath
+		if p == "" {
+			p = fp.oldPath
+		}
+		paths = append(paths, p)
+	}
+	if err := approve(ctx, aw.logger, aw.approver, ApprovalRequest{Path: strings.Join(paths, ", "), Diff: patch}); err != nil {
+		return err
+	}
+	return aw.fe.ApplyPatch(ctx, patch)
+}
+
+// read returns the content of the file at path, which is empty if the file
+// does not exist.
+func (aw *ApprovingFileWriter) read(path string) (string, error) {
+	b, _, err := readFileInRoot(aw.dir, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	return string(b), err
+}
+
+func approve(ctx context.Context, logger *slog.Logger, a Approver, r ApprovalRequest) error {
+	approval, err := a.Approve(ctx, r)
+	if err != nil {
+		return fmt.Errorf("asking for approval: %w", err)
+	}
+	if !approval.Approved {
+		logger.Warn("denied by user", "command", r.Command, "path", r.Path, "feedback", approval.Feedback)
+		return &ApprovalDeniedError{Feedback: approval.Feedback}
+	}
+	logger.Info("approved by user", "command", r.Command, "path", r.Path)
+	return nil
+}
+
+// A PrompterApprover asks the user to approve commands and file changes
+// with a Prompter. The user can approve all commands starting with a prefix
+// so that they are not asked again.
+type PrompterApprover struct {
+	prompter Prompter
+
+	mu       sync.Mutex
+	prefixes []string
+	allowed  *Policy
+}
+
+// NewPrompterApprover creates a PrompterApprover.
+func NewPrompterApprover(p Prompter) *PrompterApprover {
+	return &PrompterApprover{prompter: p, allowed: &Policy{}}
+}
+
+// Approve asks the user whether to approve r, unless r is a command in
+// which every command starts with an approved prefix.
+// The user replies y to approve, n to deny or anything else to deny with
+// that feedback. Commands can also be approved with a, which approves
+// commands starting with the same name from then on, or with a followed by
+// the prefix to approve.
+func (pa *PrompterApprover) Approve(ctx context.Context, r ApprovalRequest) (Approval, error) {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+
+	if r.Command != "" && len(pa.prefixes) > 0 && pa.allowed.Check(r.Command) == nil {
+		return Approval{Approved: true}, nil
+	}
+
+	question := pa.question(r)
+	for {
+		reply, err := pa.prompter.Prompt(ctx, question)
+		if err != nil {
+			return Approval{}, err
+		}
+		reply = strings.TrimSpace(reply)
+		word, rest, _ := strings.Cut(reply, " ")
+		switch strings.ToLower(word) {
+		case "y", "yes":
+			return Approval{Approved: true}, nil
+		case "n", "no", "":
+			return Approval{}, nil
+		case "a", "always":
+			if r.Command == "" {
+				question = fmt.Sprintf("Only commands can always be allowed.\n\n%s", pa.question(r))
+				continue
+			}
+			prefix := strings.TrimSpace(rest)
+			if prefix == "" {
+				prefix = commandName(r.Command)
+			}
+			if err := pa.allow(prefix); err != nil {
+				question = fmt.Sprintf("The prefix %q cannot be approved: %s\n\n%s", prefix, err, pa.question(r))
+				continue
+			}
+			return Approval{Approved: true}, nil
+		}
+		return Approval{Feedback: reply}, nil
+	}
+}
+
+func (pa *PrompterApprover) question(r ApprovalRequest) string {
+	if r.Command != "" {
+		return fmt.Sprintf("Run this command?\n\n%s\n\nReply y to run it, n to deny it, a to always allow commands starting with %q, a followed by another prefix to always allow it, or feedback for the model to deny it", r.Command, commandName(r.Command))
+	}
+	return fmt.Sprintf("Change %s?\n\n%s\nReply y to make the change, n to deny it, or feedback for the model to deny it", r.Path, r.Diff)
+}
+
+// allow approves commands starting with prefix. Prefixes are checked as the
+// allow rules of a Policy so that every command in a pipeline, list or
+// script must start with an approved prefix.
+func (pa *PrompterApprover) allow(prefix string) error {
+	allowed, err := NewPolicy(PolicyConfig{Allow: append(slices.Clone(pa.prefixes), prefix)})
+	if err != nil {
+		return err
+	}
+	pa.prefixes = append(pa.prefixes, prefix)
+	pa.allowed = allowed
+	return nil
+}
+
+// commandName returns the first word of command.
+func commandName(command string) string {
+	name, _, _ := strings.Cut(strings.TrimSpace(command), " ")
+	return name
+}
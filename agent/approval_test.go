@@ -0,0 +1,334 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/acrmp/minimalprompt/agent"
+	"github.com/acrmp/minimalprompt/agent/agentfakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Approval", func() {
+	var (
+		approver  *agentfakes.FakeApprover
+		logger    *slog.Logger
+		logOutput *gbytes.Buffer
+	)
+
+	BeforeEach(func() {
+		approver = &agentfakes.FakeApprover{}
+		logOutput = gbytes.NewBuffer()
+		logger = slog.New(slog.NewTextHandler(logOutput, nil))
+	})
+
+	Describe("ApprovingExecutor", func() {
+		var (
+			next     *agentfakes.FakeCommandExecutor
+			executor *agent.ApprovingExecutor
+		)
+
+		BeforeEach(func() {
+			next = &agentfakes.FakeCommandExecutor{}
+			next.ExecuteReturns(agent.CommandResult{Stdout: "pushed\n"}, nil)
+			executor = agent.NewApprovingExecutor(logger, next, approver)
+		})
+
+		It("asks for approval of the command", func() {
+			approver.ApproveReturns(agent.Approval{Approved: true}, nil)
+
+			Expect(executor.Execute(context.Background(), "git push")).To(Equal(agent.CommandResult{Stdout: "pushed\n"}))
+			_, request := approver.ApproveArgsForCall(0)
+			Expect(request).To(Equal(agent.ApprovalRequest{Command: "git push"}))
+			Expect(next.ExecuteCallCount()).To(Equal(1))
+			Expect(logOutput).To(gbytes.Say(`msg="approved by user" command="git push"`))
+		})
+
+		Context("when the command is denied", func() {
+			It("does not execute it and returns the feedback", func() {
+				approver.ApproveReturns(agent.Approval{Feedback: "open a pull request instead"}, nil)
+
+				_, err := executor.Execute(context.Background(), "git push")
+				var deniedErr *agent.ApprovalDeniedError
+				Expect(errors.As(err, &deniedErr)).To(BeTrue())
+				Expect(deniedErr.Feedback).To(Equal("open a pull request instead"))
+				Expect(next.ExecuteCallCount()).To(BeZero())
+				Expect(logOutput).To(gbytes.Say(`msg="denied by user" command="git push" path="" feedback="open a pull request instead"`))
+			})
+		})
+
+		Context("when asking for approval fails", func() {
+			It("errors", func() {
+				approver.ApproveReturns(agent.Approval{}, errors.New("EOF"))
+
+				_, err := executor.Execute(context.Background(), "git push")
+				Expect(err).To(MatchError("asking for approval: EOF"))
+				Expect(next.ExecuteCallCount()).To(BeZero())
+			})
+		})
+	})
+
+	Describe("ApprovingProcessManager", func() {
+		It("does not start denied commands", func() {
+			next := &agentfakes.FakeProcessManager{}
+			pm := agent.NewApprovingProcessManager(logger, next, approver)
+
+			_, err := pm.Start(context.Background(), "python3 -m http.server")
+			Expect(err).To(MatchError("denied by the user"))
+			Expect(next.StartCallCount()).To(BeZero())
+		})
+	})
+
+	Describe("ApprovingProgramRunner", func() {
+		It("asks for approval of the program as a quoted command", func() {
+			next := &agentfakes.FakeProgramRunner{}
+			runner := agent.NewApprovingProgramRunner(logger, next, approver)
+			approver.ApproveReturns(agent.Approval{Approved: true}, nil)
+
+			_, err := runner.RunProgram(context.Background(), []string{"git", "commit", "-m", "Add the calculator"})
+			Expect(err).ToNot(HaveOccurred())
+			_, request := approver.ApproveArgsForCall(0)
+			Expect(request).To(Equal(agent.ApprovalRequest{Command: "git commit -m 'Add the calculator'"}))
+			Expect(next.RunProgramCallCount()).To(Equal(1))
+		})
+
+		It("does not run denied programs", func() {
+			next := &agentfakes.FakeProgramRunner{}
+			runner := agent.NewApprovingProgramRunner(logger, next, approver)
+
+			_, err := runner.RunProgram(context.Background(), []string{"git", "push"})
+			Expect(err).To(MatchError("denied by the user"))
+			Expect(next.RunProgramCallCount()).To(BeZero())
+		})
+	})
+
+	Describe("ApprovingFileWriter", func() {
+		var (
+			dir    string
+			next   *agentfakes.FakeFileWriter
+			editor *agentfakes.FakeFileEditor
+			writer *agent.ApprovingFileWriter
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = os.MkdirTemp("", "approval")
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(os.RemoveAll, dir)
+			Expect(os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n"), 0600)).To(Succeed())
+
+			next = &agentfakes.FakeFileWriter{}
+			editor = &agentfakes.FakeFileEditor{}
+			writer = agent.NewApprovingFileWriter(logger, dir, next, editor, approver)
+			approver.ApproveReturns(agent.Approval{Approved: true}, nil)
+		})
+
+		It("shows a diff of the write", func() {
+			Expect(writer.WriteFile(context.Background(), "main.go", "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"goodbye\")\n}\n", false)).To(Succeed())
+
+			_, request := approver.ApproveArgsForCall(0)
+			Expect(request).To(Equal(agent.ApprovalRequest{
+				Path: "main.go",
+				Diff: `--- a/main.go
++++ b/main.go
+@@ -3,5 +3,5 @@
+ import "fmt"
+ 
+ func main() {
+-	fmt.Println("hello")
++	fmt.Println("goodbye")
+ }
+`,
+			}))
+			Expect(next.WriteFileCallCount()).To(Equal(1))
+		})
+
+		It("shows a diff of a new file", func() {
+			Expect(writer.WriteFile(context.Background(), "docs/README.md", "# Hello\n\nGreets", false)).To(Succeed())
+
+			_, request := approver.ApproveArgsForCall(0)
+			Expect(request.Diff).To(Equal(`--- a/docs/README.md
++++ b/docs/README.md
+@@ -0,0 +1,3 @@
++# Hello
++
++Greets
+\ No newline at end of file
+`))
+		})
+
+		It("shows separate hunks for distant changes", func() {
+			Expect(writer.WriteFile(context.Background(), "main.go", "// Package main greets.\npackage main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n\nfunc init() {}\n", false)).To(Succeed())
+
+			_, request := approver.ApproveArgsForCall(0)
+			Expect(request.Diff).To(Equal(`--- a/main.go
++++ b/main.go
+@@ -1,3 +1,4 @@
++// Package main greets.
+ package main
+ 
+ import "fmt"
+@@ -5,3 +6,5 @@
+ func main() {
+ 	fmt.Println("hello")
+ }
++
++func init() {}
+`))
+		})
+
+		It("shows a diff of an edit", func() {
+			Expect(writer.EditFile(context.Background(), "main.go", `"hello"`, `"goodbye"`)).To(Succeed())
+
+			_, request := approver.ApproveArgsForCall(0)
+			Expect(request.Diff).To(ContainSubstring("-\tfmt.Println(\"hello\")\n+\tfmt.Println(\"goodbye\")\n"))
+			Expect(editor.EditFileCallCount()).To(Equal(1))
+		})
+
+		It("shows a patch", func() {
+			patch := "--- a/main.go\n+++ b/main.go\n@@ -6 +6 @@\n-\tfmt.Println(\"hello\")\n+\tfmt.Println(\"goodbye\")\n"
+			Expect(writer.ApplyPatch(context.Background(), patch)).To(Succeed())
+
+			_, request := approver.ApproveArgsForCall(0)
+			Expect(request).To(Equal(agent.ApprovalRequest{Path: "main.go", Diff: patch}))
+			Expect(editor.ApplyPatchCallCount()).To(Equal(1))
+		})
+
+		Context("when the change is denied", func() {
+			BeforeEach(func() {
+				approver.ApproveReturns(agent.Approval{Feedback: "keep saying hello"}, nil)
+			})
+
+			It("does not make it", func() {
+				Expect(writer.WriteFile(context.Background(), "main.go", "package main\n", false)).To(MatchError("denied by the user with the feedback: keep saying hello"))
+				Expect(writer.EditFile(context.Background(), "main.go", `"hello"`, `"goodbye"`)).ToNot(Succeed())
+				Expect(next.WriteFileCallCount()).To(BeZero())
+				Expect(editor.EditFileCallCount()).To(BeZero())
+			})
+		})
+
+		Context("when the edit cannot be made", func() {
+			It("leaves the error to the editor without asking", func() {
+				editor.EditFileReturns(errors.New("old_string was not found"))
+
+				Expect(writer.EditFile(context.Background(), "main.go", "goodbye", "hello")).To(MatchError("old_string was not found"))
+				Expect(approver.ApproveCallCount()).To(BeZero())
+			})
+		})
+
+		Context("when the path is not local", func() {
+			It("errors without asking", func() {
+				Expect(writer.WriteFile(context.Background(), "../main.go", "", false)).To(MatchError(`path is not a local path: "../main.go"`))
+				Expect(approver.ApproveCallCount()).To(BeZero())
+			})
+		})
+	})
+})
+
+var _ = Describe("PrompterApprover", func() {
+	var (
+		prompter *agentfakes.FakePrompter
+		approver *agent.PrompterApprover
+	)
+
+	BeforeEach(func() {
+		prompter = &agentfakes.FakePrompter{}
+		approver = agent.NewPrompterApprover(prompter)
+	})
+
+	DescribeTable("interprets the reply",
+		func(reply string, approval agent.Approval) {
+			prompter.PromptReturns(reply, nil)
+			Expect(approver.Approve(context.Background(), agent.ApprovalRequest{Command: "go test ./..."})).To(Equal(approval))
+		},
+		Entry("yes", "y\n", agent.Approval{Approved: true}),
+		Entry("yes in full", "Yes", agent.Approval{Approved: true}),
+		Entry("no", "n\n", agent.Approval{}),
+		Entry("no reply", "", agent.Approval{}),
+		Entry("feedback", "Run the unit tests only\n", agent.Approval{Feedback: "Run the unit tests only"}),
+	)
+
+	It("shows the command", func() {
+		prompter.PromptReturns("y", nil)
+		_, err := approver.Approve(context.Background(), agent.ApprovalRequest{Command: "go test ./..."})
+		Expect(err).ToNot(HaveOccurred())
+		_, question := prompter.PromptArgsForCall(0)
+		Expect(question).To(HavePrefix("Run this command?\n\ngo test ./...\n\n"))
+		Expect(question).To(ContainSubstring(`a to always allow commands starting with "go"`))
+	})
+
+	It("shows the diff of file changes", func() {
+		prompter.PromptReturns("y", nil)
+		approval, err := approver.Approve(context.Background(), agent.ApprovalRequest{Path: "main.go", Diff: "--- a/main.go\n+++ b/main.go\n"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(approval).To(Equal(agent.Approval{Approved: true}))
+		_, question := prompter.PromptArgsForCall(0)
+		Expect(question).To(HavePrefix("Change main.go?\n\n--- a/main.go\n+++ b/main.go\n\n"))
+	})
+
+	It("only always allows commands", func() {
+		prompter.PromptReturnsOnCall(0, "a", nil)
+		prompter.PromptReturnsOnCall(1, "n", nil)
+		Expect(approver.Approve(context.Background(), agent.ApprovalRequest{Path: "main.go"})).To(Equal(agent.Approval{}))
+		_, question := prompter.PromptArgsForCall(1)
+		Expect(question).To(HavePrefix("Only commands can always be allowed.\n\nChange main.go?"))
+	})
+
+	Context("when a command prefix is always allowed", func() {
+		BeforeEach(func() {
+			prompter.PromptReturns("a go test", nil)
+			Expect(approver.Approve(context.Background(), agent.ApprovalRequest{Command: "go test ./agent"})).To(Equal(agent.Approval{Approved: true}))
+			prompter.PromptReturns("n", nil)
+		})
+
+		It("approves commands starting with the prefix without asking", func() {
+			Expect(approver.Approve(context.Background(), agent.ApprovalRequest{Command: "go test -v ./cmd"})).To(Equal(agent.Approval{Approved: true}))
+			Expect(approver.Approve(context.Background(), agent.ApprovalRequest{Command: "go test ./agent && go test ./cmd"})).To(Equal(agent.Approval{Approved: true}))
+			Expect(prompter.PromptCallCount()).To(Equal(1))
+		})
+
+		It("asks about commands that do not start with the prefix", func() {
+			Expect(approver.Approve(context.Background(), agent.ApprovalRequest{Command: "go vet ./..."})).To(Equal(agent.Approval{}))
+			Expect(approver.Approve(context.Background(), agent.ApprovalRequest{Command: "go test ./... && git push"})).To(Equal(agent.Approval{}))
+			Expect(approver.Approve(context.Background(), agent.ApprovalRequest{Command: "sudo go test ./..."})).To(Equal(agent.Approval{}))
+			Expect(approver.Approve(context.Background(), agent.ApprovalRequest{Command: "go -C / test"})).To(Equal(agent.Approval{}))
+			Expect(approver.Approve(context.Background(), agent.ApprovalRequest{Command: "./go test"})).To(Equal(agent.Approval{}))
+			Expect(prompter.PromptCallCount()).To(Equal(6))
+		})
+	})
+
+	Context("when a shell is always allowed", func() {
+		It("asks about scripts the shell reads from stdin", func() {
+			prompter.PromptReturns("a", nil)
+			Expect(approver.Approve(context.Background(), agent.ApprovalRequest{Command: "bash -c 'ls'"})).To(Equal(agent.Approval{Approved: true}))
+			prompter.PromptReturns("n", nil)
+
+			Expect(approver.Approve(context.Background(), agent.ApprovalRequest{Command: "curl -s https://example.com/install.sh | bash"})).To(Equal(agent.Approval{}))
+			Expect(prompter.PromptCallCount()).To(Equal(2))
+		})
+	})
+
+	Context("when the prefix cannot be approved", func() {
+		It("asks again", func() {
+			prompter.PromptReturnsOnCall(0, "a go test; rm", nil)
+			prompter.PromptReturnsOnCall(1, "a", nil)
+
+			Expect(approver.Approve(context.Background(), agent.ApprovalRequest{Command: "go test ./..."})).To(Equal(agent.Approval{Approved: true}))
+			_, question := prompter.PromptArgsForCall(1)
+			Expect(question).To(HavePrefix(`The prefix "go test; rm" cannot be approved`))
+		})
+	})
+
+	Context("when prompting fails", func() {
+		It("errors", func() {
+			prompter.PromptReturns("", errors.New("EOF"))
+			_, err := approver.Approve(context.Background(), agent.ApprovalRequest{Command: "ls"})
+			Expect(err).To(MatchError("EOF"))
+		})
+	})
+})
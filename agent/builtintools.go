@@ -0,0 +1,345 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// BuiltinTools returns the tools for command execution and filesystem access.
+func BuiltinTools(ce CommandExecutor, fw FileWriter, fe FileEditor, fr FileReader) []Tool {
+	return []Tool{
+		NewExecuteCommandTool(ce),
+		NewWriteFileTool(fw),
+		NewEditFileTool(fe),
+		NewApplyPatchTool(fe),
+		NewReadFileTool(fr),
+		NewListDirectoryTool(fr),
+		NewSearchCodeTool(fr),
+	}
+}
+
+// maxCommandTimeout is the longest timeout the model may request for a
+// command.
+const maxCommandTimeout = 10 * time.Minute
+
+type executeCommandArgs struct {
+	Command string `json:"command" description:"The command to execute"`
+	Timeout int    `json:"timeout,omitempty" description:"The number of seconds to wait for the command to finish before killing it, at most 600. Defaults to 120"`
+}
+
+// NewExecuteCommandTool creates a tool that executes commands with ce.
+func NewExecuteCommandTool(ce CommandExecutor) Tool {
+	return NewFunctionTool(
+		"executeCommand",
+		"Execute an operating system shell command",
+		func(ctx context.Context, args executeCommandArgs) (string, error) {
+			if args.Timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, min(time.Duration(args.Timeout)*time.Second, maxCommandTimeout))
+				defer cancel()
+			}
+			r, err := ce.Execute(ctx, args.Command)
+			var limitErr *LimitExceededError
+			var deniedErr *PolicyDeniedError
+			status := "succeeded"
+			switch {
+			case errors.As(err, &deniedErr):
+				return fmt.Sprintf("The command was denied by the command policy and was not run: %s", deniedErr), nil
+			case isUserDenial(err):
+				return userDenial("The user denied the command and it was not run", err), nil
+			case errors.As(err, &limitErr):
+				status = "exceeded its " + limitErr.Limit
+			case errors.Is(err, ErrCommandTimedOut):
+				status = "timed out"
+			case err != nil:
+				status = "failed"
+			}
+			return formatCommandResult(status, r), nil
+		},
+	)
+}
+
+type runProgramArgs struct {
+	Argv    []string `json:"argv" description:"The program followed by its arguments, for example [\"go\", \"test\", \"./...\"]. Each argument is passed to the program as it is"`
+	Timeout int      `json:"timeout,omitempty" description:"The number of seconds to wait for the program to finish before killing it, at most 600. Defaults to 120"`
+}
+
+// NewRunProgramTool creates a tool that runs programs with pr.
+func NewRunProgramTool(pr ProgramRunner) Tool {
+	return NewFunctionTool(
+		"runProgram",
+		"Run a program with a list of arguments without a shell, so that no quoting is needed. Use executeCommand for pipes, redirections or other shell features",
+		func(ctx context.Context, args runProgramArgs) (string, error) {
+			if len(args.Argv) == 0 {
+				return "", errEmptyArgv
+			}
+			if args.Timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, min(time.Duration(args.Timeout)*time.Second, maxCommandTimeout))
+				defer cancel()
+			}
+			r, err := pr.RunProgram(ctx, args.Argv)
+			var limitErr *LimitExceededError
+			var deniedErr *PolicyDeniedError
+			var execErr *exec.Error
+			var pathErr *fs.PathError
+			status := "succeeded"
+			switch {
+			case errors.As(err, &deniedErr):
+				return fmt.Sprintf("The program was denied by the command policy and was not run: %s", deniedErr), nil
+			case isUserDenial(err):
+				return userDenial("The user denied the program and it was not run", err), nil
+			case errors.As(err, &execErr), errors.As(err, &pathErr):
+				// The program could not be started.
+				return "", err
+			case errors.As(err, &limitErr):
+				status = "exceeded its " + limitErr.Limit
+			case errors.Is(err, ErrCommandTimedOut):
+				status = "timed out"
+			case err != nil:
+				status = "failed"
+			}
+			return formatCommandResult(status, r), nil
+		},
+	)
+}
+
+// formatCommandResult renders the result of a command for the model, with a
+// line for each of its status, exit code, signal and duration followed by
+// its stdout and stderr in tags.
+func formatCommandResult(status string, r CommandResult) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "status: %s\nexit_code: %d\n", status, r.ExitCode)
+	if r.Signal != "" {
+		fmt.Fprintf(&b, "signal: %s\n", r.Signal)
+	}
+	fmt.Fprintf(&b, "duration: %s\n", r.Duration.Round(time.Millisecond))
+	for _, stream := range []struct{ name, output string }{{"stdout", r.Stdout}, {"stderr", r.Stderr}} {
+		fmt.Fprintf(&b, "<%s>\n%s", stream.name, stream.output)
+		if stream.output != "" && !strings.HasSuffix(stream.output, "\n") {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "</%s>\n", stream.name)
+	}
+	return b.String()
+}
+
+type writeFileArgs struct {
+	Path       string `json:"path" description:"The relative path of the file within the project"`
+	Content    string `json:"content" description:"The content of the file as a string"`
+	Executable bool   `json:"executable,omitempty" description:"Whether to make the file executable, for example a script. Existing files otherwise keep their permissions"`
+}
+
+// NewWriteFileTool creates a tool that writes files with fw.
+func NewWriteFileTool(fw FileWriter) Tool {
+	return NewFunctionTool(
+		"writeFile",
+		"Write a file to the filesystem",
+		func(ctx context.Context, args writeFileArgs) (string, error) {
+			err := fw.WriteFile(ctx, args.Path, args.Content, args.Executable)
+			if isUserDenial(err) {
+				return userDenial("The user denied writing the file and it was not written", err), nil
+			}
+			if err != nil {
+				return "", err
+			}
+			return "ok", nil
+		},
+	)
+}
+
+type editFileArgs struct {
+	Path      string `json:"path" description:"The relative path of the file within the project"`
+	OldString string `json:"old_string" description:"The exact text to replace, including enough surrounding lines to be unique"`
+	NewString string `json:"new_string" description:"The text to replace it with"`
+}
+
+// NewEditFileTool creates a tool that edits files with fe.
+func NewEditFileTool(fe FileEditor) Tool {
+	return NewFunctionTool(
+		"editFile",
+		"Edit a file by replacing an exact string. The old string must occur exactly once in the file",
+		func(ctx context.Context, args editFileArgs) (string, error) {
+			err := fe.EditFile(ctx, args.Path, args.OldString, args.NewString)
+			if isUserDenial(err) {
+				return userDenial("The user denied the edit and the file was not changed", err), nil
+			}
+			if err != nil {
+				return "", err
+			}
+			return "ok", nil
+		},
+	)
+}
+
+type applyPatchArgs struct {
+	Patch string `json:"patch" description:"The unified diff with --- and +++ file headers using relative paths within the project and @@ hunks with context lines"`
+}
+
+// NewApplyPatchTool creates a tool that applies unified diffs with fe.
+func NewApplyPatchTool(fe FileEditor) Tool {
+	return NewFunctionTool(
+		"applyPatch",
+		"Apply a unified diff to one or more files. Either every hunk applies or no files are changed",
+		func(ctx context.Context, args applyPatchArgs) (string, error) {
+			err := fe.ApplyPatch(ctx, args.Patch)
+			if isUserDenial(err) {
+				return userDenial("The user denied the patch and no files were changed", err), nil
+			}
+			if err != nil {
+				return "", err
+			}
+			return "ok", nil
+		},
+	)
+}
+
+type readFileArgs struct {
+	Path  string `json:"path" description:"The relative path of the file within the project"`
+	Start int    `json:"start,omitempty" description:"The first line to read, numbered from 1. Defaults to the start of the file"`
+	End   int    `json:"end,omitempty" description:"The last line to read. Defaults to the end of the file"`
+}
+
+// NewReadFileTool creates a tool that reads files with fr.
+func NewReadFileTool(fr FileReader) Tool {
+	return NewFunctionTool(
+		"readFile",
+		"Read a file from the filesystem. Lines are returned prefixed with their line number",
+		func(ctx context.Context, args readFileArgs) (string, error) {
+			return fr.ReadFile(args.Path, args.Start, args.End)
+		},
+	)
+}
+
+type listDirectoryArgs struct {
+	Path  string `json:"path,omitempty" description:"The relative path of the directory within the project. Defaults to the project root"`
+	Depth int    `json:"depth,omitempty" description:"The number of directory levels to descend. Defaults to 3"`
+}
+
+// NewListDirectoryTool creates a tool that lists directories with fr.
+func NewListDirectoryTool(fr FileReader) Tool {
+	return NewFunctionTool(
+		"listDirectory",
+		"List the files in a directory as an indented tree with file sizes. Ignored files and directories such as .git and vendor are excluded",
+		func(ctx context.Context, args listDirectoryArgs) (string, error) {
+			return fr.ListDirectory(args.Path, args.Depth)
+		},
+	)
+}
+
+type searchCodeArgs struct {
+	Pattern    string `json:"pattern" description:"The regular expression to search for, in Go RE2 syntax"`
+	Glob       string `json:"glob,omitempty" description:"Only search files matching this glob, for example *.go or cmd/**/*.go"`
+	Context    int    `json:"context,omitempty" description:"The number of lines of context to show before and after each match. Defaults to 0"`
+	MaxResults int    `json:"max_results,omitempty" description:"The maximum number of matches to return. Defaults to 50"`
+}
+
+// NewSearchCodeTool creates a tool that searches files with fr.
+func NewSearchCodeTool(fr FileReader) Tool {
+	return NewFunctionTool(
+		"searchCode",
+		"Search the project files for lines matching a regular expression. Matches are returned as path:line:text",
+		func(ctx context.Context, args searchCodeArgs) (string, error) {
+			return fr.SearchCode(args.Pattern, args.Glob, args.Context, args.MaxResults)
+		},
+	)
+}
+
+// isUserDenial reports whether err is an ApprovalDeniedError. Denials are
+// returned to the model as tool responses rather than errors so that they do
+// not count as tool failures.
+func isUserDenial(err error) bool {
+	var deniedErr *ApprovalDeniedError
+	return errors.As(err, &deniedErr)
+}
+
+// userDenial returns the response telling the model that the user denied an
+// action, including any feedback from the user.
+func userDenial(response string, err error) string {
+	var deniedErr *ApprovalDeniedError
+	if errors.As(err, &deniedErr) && deniedErr.Feedback != "" {
+		return fmt.Sprintf("%s, the feedback from the user was:\n%s", response, deniedErr.Feedback)
+	}
+	return response
+}
+
+// ProcessTools returns the tools for running processes in the background
+// with pm. The tools close pm when the registry holding them is closed.
+func ProcessTools(pm ProcessManager) []Tool {
+	return []Tool{
+		closingTool{Tool: NewStartProcessTool(pm), Closer: pm},
+		NewReadProcessOutputTool(pm),
+		NewStopProcessTool(pm),
+	}
+}
+
+// A closingTool closes a resource shared by a group of tools.
+type closingTool struct {
+	Tool
+	io.Closer
+}
+
+type startProcessArgs struct {
+	Command string `json:"command" description:"The command to run in the background, for example a server"`
+}
+
+// NewStartProcessTool creates a tool that starts background processes with
+// pm.
+func NewStartProcessTool(pm ProcessManager) Tool {
+	return NewFunctionTool(
+		"startProcess",
+		"Start a long-running bash command, such as a server, in the background and return its process id. Use executeCommand for commands that finish",
+		func(ctx context.Context, args startProcessArgs) (string, error) {
+			id, err := pm.Start(ctx, args.Command)
+			var deniedErr *PolicyDeniedError
+			if errors.As(err, &deniedErr) {
+				return fmt.Sprintf("The command was denied by the command policy and was not started: %s", deniedErr), nil
+			}
+			if isUserDenial(err) {
+				return userDenial("The user denied the command and it was not started", err), nil
+			}
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Started process %s, read its output with readProcessOutput and stop it with stopProcess", id), nil
+		},
+	)
+}
+
+type readProcessOutputArgs struct {
+	ID    string `json:"id" description:"The id of the process returned by startProcess"`
+	Lines int    `json:"lines,omitempty" description:"The number of the most recent lines of output to return. Defaults to 50"`
+}
+
+// NewReadProcessOutputTool creates a tool that reads the output of
+// background processes with pm.
+func NewReadProcessOutputTool(pm ProcessManager) Tool {
+	return NewFunctionTool(
+		"readProcessOutput",
+		"Read the status and most recent output of a background process",
+		func(ctx context.Context, args readProcessOutputArgs) (string, error) {
+			return pm.ReadOutput(args.ID, args.Lines)
+		},
+	)
+}
+
+type stopProcessArgs struct {
+	ID string `json:"id" description:"The id of the process returned by startProcess"`
+}
+
+// NewStopProcessTool creates a tool that stops background processes with pm.
+func NewStopProcessTool(pm ProcessManager) Tool {
+	return NewFunctionTool(
+		"stopProcess",
+		"Stop a background process and return its final output",
+		func(ctx context.Context, args stopProcessArgs) (string, error) {
+			return pm.Stop(args.ID)
+		},
+	)
+}
@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultEnvironment lists the environment variables that are passed to
+// commands unless configured otherwise. Names may be glob patterns.
+var DefaultEnvironment = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "COLORTERM", "NO_COLOR",
+	"LANG", "LANGUAGE", "LC_*", "TZ", "TMPDIR",
+	"SSL_CERT_FILE", "SSL_CERT_DIR",
+	"GOPATH", "GOROOT", "GOFLAGS", "GOCACHE", "GOMODCACHE", "GOPROXY", "GOPRIVATE",
+	"GONOSUMDB", "GONOPROXY", "GOTOOLCHAIN", "GOOS", "GOARCH", "CGO_ENABLED",
+}
+
+// secretVariables match the names of variables, such as model provider API
+// keys, that are never passed to commands.
+var secretVariables = []string{"*_API_KEY", "*_API_KEYS", "ANTHROPIC_*", "OPENAI_*", "GEMINI_*"}
+
+// An EnvironmentConfig configures the environment of commands.
+type EnvironmentConfig struct {
+	// Pass lists variables to pass to commands from the environment in
+	// addition to DefaultEnvironment. Names may be glob patterns.
+	Pass []string `yaml:"pass"`
+	// Set lists variables to set for commands, overriding the environment.
+	Set map[string]string `yaml:"set"`
+}
+
+// An Environment decides the environment variables that commands run with.
+// Variables are only passed from the environment if they are allowed, and
+// model provider API keys such as ANTHROPIC_API_KEY are never passed.
+//
+// A nil Environment passes the variables in DefaultEnvironment.
+type Environment struct {
+	pass []string
+	set  []string
+}
+
+// LoadEnvironment reads an Environment from the YAML file at path.
+func LoadEnvironment(path string) (*Environment, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c EnvironmentConfig
+	d := yaml.NewDecoder(bytes.NewReader(b))
+	d.KnownFields(true)
+	if err := d.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid environment %q: %w", path, err)
+	}
+	e, err := NewEnvironment(c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid environment %q: %w", path, err)
+	}
+	return e, nil
+}
+
+// NewEnvironment creates an Environment from c.
+// It errors if c passes or sets a variable that is never passed to commands,
+// or has an invalid pattern.
+func NewEnvironment(c EnvironmentConfig) (*Environment, error) {
+	e := &Environment{pass: slices.Concat(DefaultEnvironment, c.Pass)}
+	for _, p := range c.Pass {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid variable pattern %q: %w", p, err)
+		}
+		if IsSecretVariable(p) {
+			return nil, fmt.Errorf("variable %q must not be passed to commands", p)
+		}
+	}
+	for name, value := range c.Set {
+		if name == "" || strings.ContainsRune(name, '=') {
+			return nil, fmt.Errorf("invalid variable name %q", name)
+		}
+		if IsSecretVariable(name) {
+			return nil, fmt.Errorf("variable %q must not be set for commands", name)
+		}
+		e.set = append(e.set, name+"="+value)
+	}
+	sort.Strings(e.set)
+	return e, nil
+}
+
+// Environ returns the environment for commands from the variables in
+// environ, which are in the form of os.Environ.
+func (e *Environment) Environ(environ []string) []string {
+	pass := DefaultEnvironment
+	var set []string
+	if e != nil {
+		pass, set = e.pass, e.set
+	}
+
+	env := []string{}
+	for _, kv := range environ {
+		name, _, ok := strings.Cut(kv, "=")
+		if !ok || IsSecretVariable(name) || !matchesAny(pass, name) {
+			continue
+		}
+		if slices.ContainsFunc(set, func(s string) bool { return strings.HasPrefix(s, name+"=") }) {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return append(env, set...)
+}
+
+// environ returns the environment for commands from the environment of this
+// process.
+func (e *Environment) environ() []string {
+	return e.Environ(os.Environ())
+}
+
+// IsSecretVariable reports whether the variable named name, such as a model
+// provider API key, is never passed to commands.
+func IsSecretVariable(name string) bool {
+	return matchesAny(secretVariables, strings.ToUpper(name))
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package agent_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/acrmp/minimalprompt/agent"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Environment", func() {
+	parent := []string{
+		"PATH=/usr/bin:/bin",
+		"HOME=/home/gopher",
+		"LC_ALL=C.UTF-8",
+		"GOFLAGS=-mod=mod",
+		"ANTHROPIC_API_KEY=sk-ant-secret",
+		"OPENAI_API_KEY=sk-secret",
+		"AWS_SECRET_ACCESS_KEY=aws-secret",
+		"DATABASE_URL=postgres://localhost/app",
+		"MALFORMED",
+	}
+
+	Context("when nil", func() {
+		It("passes the default variables", func() {
+			var e *agent.Environment
+			Expect(e.Environ(parent)).To(Equal([]string{
+				"PATH=/usr/bin:/bin",
+				"HOME=/home/gopher",
+				"LC_ALL=C.UTF-8",
+				"GOFLAGS=-mod=mod",
+			}))
+		})
+	})
+
+	It("passes and sets the configured variables", func() {
+		e, err := agent.NewEnvironment(agent.EnvironmentConfig{
+			Pass: []string{"DATABASE_*"},
+			Set:  map[string]string{"GOFLAGS": "-mod=vendor", "CI": "true"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(e.Environ(parent)).To(Equal([]string{
+			"PATH=/usr/bin:/bin",
+			"HOME=/home/gopher",
+			"LC_ALL=C.UTF-8",
+			"DATABASE_URL=postgres://localhost/app",
+			"CI=true",
+			"GOFLAGS=-mod=vendor",
+		}))
+	})
+
+	It("never passes provider API keys", func() {
+		e, err := agent.NewEnvironment(agent.EnvironmentConfig{Pass: []string{"*"}})
+		Expect(err).ToNot(HaveOccurred())
+		env := e.Environ(parent)
+		Expect(env).To(ContainElement("AWS_SECRET_ACCESS_KEY=aws-secret"))
+		Expect(env).ToNot(ContainElement(ContainSubstring("API_KEY")))
+	})
+
+	DescribeTable("errors for invalid configuration",
+		func(c agent.EnvironmentConfig, msg string) {
+			_, err := agent.NewEnvironment(c)
+			Expect(err).To(MatchError(msg))
+		},
+		Entry("passing an API key", agent.EnvironmentConfig{Pass: []string{"ANTHROPIC_API_KEY"}}, `variable "ANTHROPIC_API_KEY" must not be passed to commands`),
+		Entry("setting an API key", agent.EnvironmentConfig{Set: map[string]string{"openai_api_key": "sk"}}, `variable "openai_api_key" must not be set for commands`),
+		Entry("an invalid pattern", agent.EnvironmentConfig{Pass: []string{"GO["}}, `invalid variable pattern "GO[": syntax error in pattern`),
+		Entry("an invalid name", agent.EnvironmentConfig{Set: map[string]string{"A=B": "C"}}, `invalid variable name "A=B"`),
+	)
+
+	Describe("loading", func() {
+		var path string
+
+		BeforeEach(func() {
+			dir, err := os.MkdirTemp("", "environment")
+			Expect(err).ToNot(HaveOccurred())
+			DeferCleanup(os.RemoveAll, dir)
+			path = filepath.Join(dir, "environment.yaml")
+		})
+
+		It("reads the configuration from YAML", func() {
+			Expect(os.WriteFile(path, []byte("pass: [DATABASE_URL]\nset:\n  CI: \"true\"\n"), 0600)).To(Succeed())
+			e, err := agent.LoadEnvironment(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(e.Environ(parent)).To(ContainElements("DATABASE_URL=postgres://localhost/app", "CI=true"))
+		})
+
+		Context("when the configuration passes an API key", func() {
+			It("errors", func() {
+				Expect(os.WriteFile(path, []byte("pass: [ANTHROPIC_API_KEY]\n"), 0600)).To(Succeed())
+				_, err := agent.LoadEnvironment(path)
+				Expect(err).To(MatchError(ContainSubstring("must not be passed to commands")))
+			})
+		})
+	})
+})
@@ -1,29 +1,289 @@
 package agent
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"mvdan.cc/sh/v3/syntax"
 )
 
+// DefaultCommandTimeout is the time a command may run for when the context
+// has no deadline.
+const DefaultCommandTimeout = 2 * time.Minute
+
+// DefaultShell is the shell that commands run with unless another is
+// configured. It is found in the directories named by PATH.
+const DefaultShell = "bash"
+
+// ErrCommandTimedOut is returned when a command is killed because it did not
+// finish before its deadline.
+var ErrCommandTimedOut = errors.New("command timed out")
+
+// A CommandResult is the outcome of executing a command.
+type CommandResult struct {
+	// ExitCode is the exit status of the command, or -1 if it was killed by a
+	// signal or did not exit.
+	ExitCode int
+	// Signal is the name of the signal that killed the command, such as
+	// SIGKILL, if any. An exit status above 128 is not taken as a signal,
+	// as the command may have exited with it.
+	Signal string
+	// Duration is how long the command ran for.
+	Duration time.Duration
+	// Stdout is the output of the command on stdout.
+	Stdout string
+	// Stderr is the output of the command on stderr.
+	Stderr string
+}
+
+// Output returns stdout followed by stderr.
+func (r CommandResult) Output() string {
+	return r.Stdout + r.Stderr
+}
+
+// newCommandResult creates a CommandResult for a command that started at
+// start and ended with err.
+func newCommandResult(stdout, stderr string, start time.Time, err error) CommandResult {
+	r := CommandResult{Duration: time.Since(start), Stdout: stdout, Stderr: stderr}
+	if err == nil {
+		return r
+	}
+	r.ExitCode = -1
+	var exitErr *exec.ExitError
+	var status exitStatusError
+	switch {
+	case errors.As(err, &exitErr):
+		r.ExitCode = exitErr.ExitCode()
+	case errors.As(err, &status):
+		r.ExitCode = int(status)
+	}
+	if sig, ok := exitSignal(err); ok {
+		r.Signal = unix.SignalName(sig)
+	} else if errors.Is(err, errShellKilled) {
+		r.Signal = unix.SignalName(syscall.SIGKILL)
+	}
+	return r
+}
+
+// logResult logs how a command ended.
+func logResult(logger *slog.Logger, r CommandResult) {
+	attrs := []any{"exit_code", r.ExitCode, "duration", r.Duration}
+	if r.Signal != "" {
+		attrs = append(attrs, "signal", r.Signal)
+	}
+	logger.Info("command finished", attrs...)
+}
+
+// An execConfig holds the settings shared by the types that run commands.
+type execConfig struct {
+	timeout time.Duration
+	limits  *Limits
+	stream  io.Writer
+	env     *Environment
+	shell   string
+	network bool
+	grace   time.Duration
+}
+
+// An ExecOption configures how a BashExecutor, SandboxExecutor, ShellSession,
+// BashProcessManager or PluginTool runs commands. Options that do not apply
+// to a type, such as WithNetwork for anything other than a SandboxExecutor,
+// are ignored.
+type ExecOption func(*execConfig)
+
+// newExecConfig returns the defaults with opts applied.
+func newExecConfig(opts []ExecOption) execConfig {
+	c := execConfig{timeout: DefaultCommandTimeout, shell: DefaultShell, grace: defaultStopGracePeriod}
+	for _, o := range opts {
+		o(&c)
+	}
+	return c
+}
+
+// WithTimeout sets the time a command, program or plugin may run for when
+// its context has no deadline. It defaults to DefaultCommandTimeout.
+// Background processes run until they are stopped.
+func WithTimeout(d time.Duration) ExecOption {
+	return func(c *execConfig) {
+		c.timeout = d
+	}
+}
+
+// WithLimits sets the resources that commands may use. For a ShellSession
+// they also apply to the shell itself.
+func WithLimits(l Limits) ExecOption {
+	return func(c *execConfig) {
+		c.limits = &l
+	}
+}
+
+// WithOutputStream sets a writer, such as a terminal, that command output is
+// copied to as it arrives. Each line is prefixed and dimmed.
+func WithOutputStream(w io.Writer) ExecOption {
+	return func(c *execConfig) {
+		c.stream = w
+	}
+}
+
+// WithEnvironment sets the environment variables that commands run with. It
+// defaults to the variables in DefaultEnvironment.
+func WithEnvironment(e *Environment) ExecOption {
+	return func(c *execConfig) {
+		c.env = e
+	}
+}
+
+// WithShell sets the path or name of the shell that runs commands. It
+// defaults to DefaultShell.
+func WithShell(shell string) ExecOption {
+	return func(c *execConfig) {
+		c.shell = shell
+	}
+}
+
 // A BashExecutor executes bash commands
 type BashExecutor struct {
 	logger *slog.Logger
 	dir    string
+	config execConfig
 }
 
 // NewBashExecutor creates a BashExecutor.
 // The command executes in the working directory specified with dir.
-func NewBashExecutor(logger *slog.Logger, dir string) *BashExecutor {
-	return &BashExecutor{logger: logger, dir: dir}
+func NewBashExecutor(logger *slog.Logger, dir string, opts ...ExecOption) *BashExecutor {
+	return &BashExecutor{logger: logger, dir: dir, config: newExecConfig(opts)}
 }
 
-// Execute runs the bash command represented by cmd and returns the combined
-// output of stdout and sterr as a string as well as any error.
-func (b *BashExecutor) Execute(cmd string) (string, error) {
+// Execute runs the bash command represented by cmd and returns its result
+// as well as any error. A non-zero exit code is returned as an error.
+//
+// The command runs in its own process group. If ctx is done before the
+// command exits the whole group is killed and the output captured so far is
+// returned. The error wraps ErrCommandTimedOut if the deadline was exceeded,
+// or is a LimitExceededError if the command exceeded one of its limits.
+func (b *BashExecutor) Execute(ctx context.Context, cmd string) (CommandResult, error) {
 	b.logger.Info("executing command", "command", cmd)
-	c := exec.Command("/usr/bin/bash", "-c", cmd)
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.config.timeout)
+		defer cancel()
+	}
+
+	return b.run(ctx, exec.CommandContext(ctx, b.config.shell, "-c", b.config.limits.ulimits()+cmd), true)
+}
+
+// RunProgram runs the program named by argv[0] with the arguments in the
+// rest of argv and returns its result as well as any error. The arguments
+// are passed to the program as they are, without being parsed by a shell.
+// A non-zero exit code is returned as an error.
+//
+// The program runs in its own process group with the same timeout and
+// limits as commands.
+func (b *BashExecutor) RunProgram(ctx context.Context, argv []string) (CommandResult, error) {
+	if len(argv) == 0 {
+		return CommandResult{}, errEmptyArgv
+	}
+	b.logger.Info("running program", "argv", argv)
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.config.timeout)
+		defer cancel()
+	}
+	argv = programArgv(b.config.shell, b.config.limits, argv)
+	return b.run(ctx, exec.CommandContext(ctx, argv[0], argv[1:]...), false)
+}
+
+// run runs c. shell is whether c runs a shell command rather than a program.
+func (b *BashExecutor) run(ctx context.Context, c *exec.Cmd, shell bool) (CommandResult, error) {
 	c.Dir = b.dir
+	c.Env = b.config.env.environ()
+	killProcessGroup(c)
+	applyLimits(c, b.config.limits)
+
+	before := b.config.limits.snapshot()
+	r, err := runCommand(c, b.config.stream)
+	logResult(b.logger, r)
+	return r, b.config.limits.check(before, contextError(ctx, err), shell)
+}
+
+// errEmptyArgv is returned when a program is run without a name.
+var errEmptyArgv = errors.New("argv must contain at least the program to run")
+
+// programArgv returns the argv that runs the program in argv with limits.
+// The rlimits can only be set by a shell, which then replaces itself with
+// the program so that its arguments are passed on without being parsed.
+func programArgv(shell string, limits *Limits, argv []string) []string {
+	ulimits := limits.ulimits()
+	if ulimits == "" {
+		return argv
+	}
+	return append([]string{shell, "-c", ulimits + `exec "$@"`, argv[0]}, argv...)
+}
+
+// quoteArgv returns argv as a shell command, for showing to the user and
+// checking against a Policy.
+func quoteArgv(argv []string) string {
+	words := make([]string, len(argv))
+	for i, a := range argv {
+		q, err := syntax.Quote(a, syntax.LangBash)
+		if err != nil {
+			q = strconv.Quote(a)
+		}
+		words[i] = q
+	}
+	return strings.Join(words, " ")
+}
+
+// runCommand runs c and returns its result, copying its output to stream as
+// it arrives if stream is not nil.
+func runCommand(c *exec.Cmd, stream io.Writer) (CommandResult, error) {
+	var stdout, stderr bytes.Buffer
+	sw := newStreamWriter(stream)
+	c.Stdout, c.Stderr = io.Writer(&stdout), io.Writer(&stderr)
+	if sw != nil {
+		c.Stdout, c.Stderr = io.MultiWriter(&stdout, sw), io.MultiWriter(&stderr, sw)
+	}
+	start := time.Now()
+	err := c.Run()
+	sw.finish()
+	return newCommandResult(stdout.String(), stderr.String(), start, err), err
+}
+
+// killProcessGroup starts c in a new process group and kills the whole group
+// when the context of c is done, so that background jobs and children
+// started by the command do not outlive it.
+func killProcessGroup(c *exec.Cmd) {
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.Setpgid = true
+	c.Cancel = func() error {
+		return syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+	}
+	// Grandchildren may hold the output pipes open after being killed.
+	c.WaitDelay = time.Second
+}
 
-	o, err := c.CombinedOutput()
-	return string(o), err
+// contextError explains why a command ended if its context is done.
+func contextError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%w: %w", ErrCommandTimedOut, err)
+	case ctx.Err() != nil:
+		return fmt.Errorf("command cancelled: %w", ctx.Err())
+	}
+	return err
 }
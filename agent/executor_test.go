@@ -1,9 +1,13 @@
 package agent_test
 
 import (
+	"context"
+	"errors"
 	"log/slog"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"time"
 
 	"github.com/acrmp/minimalprompt/agent"
 	. "github.com/onsi/ginkgo/v2"
@@ -37,26 +41,97 @@ var _ = Describe("Executor", func() {
 	})
 
 	It("executes the provided command", func() {
-		output, err := bash.Execute("printf 'hello world'")
+		r, err := bash.Execute(context.Background(), "printf 'hello world'")
 		Expect(err).ToNot(HaveOccurred())
-		Expect(output).To(Equal("hello world"))
+		Expect(r.Stdout).To(Equal("hello world"))
+		Expect(r.ExitCode).To(BeZero())
+		Expect(r.Duration).To(BeNumerically(">", 0))
 	})
 
 	It("logs that it is executing the command", func() {
-		_, err := bash.Execute("printf 'hello world'")
+		_, err := bash.Execute(context.Background(), "printf 'hello world'")
 		Expect(err).ToNot(HaveOccurred())
 		Expect(logOutput).To(gbytes.Say(`executing command.*printf 'hello world'`))
+		Expect(logOutput).To(gbytes.Say(`command finished.*exit_code=0 duration=`))
 	})
 
-	It("includes both stdout and stderr in the captured output", func() {
-		output, err := bash.Execute("printf 'hello'\nprintf 'world' >&2\n")
+	It("captures stdout and stderr separately", func() {
+		r, err := bash.Execute(context.Background(), "printf 'hello'\nprintf 'world' >&2\n")
 		Expect(err).ToNot(HaveOccurred())
-		Expect(output).To(ContainSubstring("hello"))
-		Expect(output).To(ContainSubstring("world"))
+		Expect(r.Stdout).To(Equal("hello"))
+		Expect(r.Stderr).To(Equal("world"))
+		Expect(r.Output()).To(Equal("helloworld"))
+	})
+
+	Context("when the environment has secrets", func() {
+		BeforeEach(func() {
+			GinkgoT().Setenv("ANTHROPIC_API_KEY", "sk-ant-secret")
+			GinkgoT().Setenv("DATABASE_URL", "postgres://localhost/app")
+		})
+
+		It("does not pass them to the command", func() {
+			r, err := bash.Execute(context.Background(), "env")
+			output := r.Stdout
+			Expect(err).ToNot(HaveOccurred())
+			Expect(output).To(ContainSubstring("PATH="))
+			Expect(output).ToNot(ContainSubstring("ANTHROPIC_API_KEY"))
+			Expect(output).ToNot(ContainSubstring("DATABASE_URL"))
+		})
+
+		It("passes the configured variables", func() {
+			env, err := agent.NewEnvironment(agent.EnvironmentConfig{Pass: []string{"DATABASE_URL"}, Set: map[string]string{"CI": "true"}})
+			Expect(err).ToNot(HaveOccurred())
+			bash = agent.NewBashExecutor(logger, dir, agent.WithEnvironment(env))
+
+			r, err := bash.Execute(context.Background(), "env")
+			output := r.Stdout
+			Expect(err).ToNot(HaveOccurred())
+			Expect(output).To(ContainSubstring("DATABASE_URL=postgres://localhost/app\n"))
+			Expect(output).To(ContainSubstring("CI=true\n"))
+			Expect(output).ToNot(ContainSubstring("ANTHROPIC_API_KEY"))
+		})
+	})
+
+	Describe("IsTerminal", func() {
+		It("is false for files and other character devices", func() {
+			devNull, err := os.Open(os.DevNull)
+			Expect(err).ToNot(HaveOccurred())
+			defer devNull.Close()
+			Expect(agent.IsTerminal(devNull)).To(BeFalse())
+
+			f, err := os.CreateTemp(dir, "output")
+			Expect(err).ToNot(HaveOccurred())
+			defer f.Close()
+			Expect(agent.IsTerminal(f)).To(BeFalse())
+		})
+	})
+
+	Context("when the output is streamed", func() {
+		var stream *gbytes.Buffer
+
+		BeforeEach(func() {
+			stream = gbytes.NewBuffer()
+			bash = agent.NewBashExecutor(logger, dir, agent.WithOutputStream(stream))
+		})
+
+		It("writes each line prefixed and dimmed as it arrives", func() {
+			done := make(chan string)
+			go func() {
+				defer GinkgoRecover()
+				r, err := bash.Execute(context.Background(), "echo compiling; sleep 1; printf 'ok' >&2")
+				Expect(err).ToNot(HaveOccurred())
+				done <- r.Output()
+			}()
+
+			Eventually(stream).Should(gbytes.Say(`│ \x1b\[2mcompiling\x1b\[0m\n`))
+			Expect(done).ToNot(Receive())
+			Eventually(done, 5*time.Second).Should(Receive(Equal("compiling\nok")))
+			Expect(string(stream.Contents())).To(Equal("│ \x1b[2mcompiling\x1b[0m\n│ \x1b[2mok\x1b[0m\n"))
+		})
 	})
 
 	It("uses the directory as the working directory", func() {
-		_, err := bash.Execute("printf 'hello world' > some-file")
+		_, err := bash.Execute(context.Background(), "printf 'hello world' > some-file")
 		Expect(err).ToNot(HaveOccurred())
 
 		b, err := os.ReadFile(filepath.Join(dir, "some-file"))
@@ -66,10 +141,145 @@ var _ = Describe("Executor", func() {
 	})
 
 	Context("when the command exits with a non-zero exit code", func() {
-		It("errors", func() {
-			o, err := bash.Execute("printf 'still captured'; false")
+		It("errors with the exit code", func() {
+			r, err := bash.Execute(context.Background(), "printf 'still captured'; exit 3")
+			Expect(err).To(HaveOccurred())
+			Expect(r.Stdout).To(Equal("still captured"))
+			Expect(r.ExitCode).To(Equal(3))
+			Expect(r.Signal).To(BeEmpty())
+			Expect(logOutput).To(gbytes.Say(`command finished.*exit_code=3`))
+		})
+
+		It("does not mistake a status above 128 for a signal", func() {
+			r, err := bash.Execute(context.Background(), "exit 137")
+			Expect(err).To(MatchError("exit status 137"))
+			Expect(r.ExitCode).To(Equal(137))
+			Expect(r.Signal).To(BeEmpty())
+
+			r, err = bash.RunProgram(context.Background(), []string{"sh", "-c", "exit 137"})
+			Expect(err).To(MatchError("exit status 137"))
+			Expect(r.ExitCode).To(Equal(137))
+			Expect(r.Signal).To(BeEmpty())
+		})
+	})
+
+	Context("when the command is killed by a signal", func() {
+		It("errors with the signal", func() {
+			r, err := bash.Execute(context.Background(), "kill -TERM $$")
+			Expect(err).To(HaveOccurred())
+			Expect(r.ExitCode).To(Equal(-1))
+			Expect(r.Signal).To(Equal("SIGTERM"))
+			Expect(logOutput).To(gbytes.Say(`command finished.*exit_code=-1 duration=\S+ signal=SIGTERM`))
+		})
+	})
+
+	Context("when the command does not finish before the deadline", func() {
+		It("kills the command and returns the output so far", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+			r, err := bash.Execute(ctx, "printf 'started'; sleep 30")
+			Expect(time.Since(start)).To(BeNumerically("<", 10*time.Second))
+			Expect(err).To(MatchError(agent.ErrCommandTimedOut))
+			Expect(r.Stdout).To(Equal("started"))
+			Expect(r.Signal).To(Equal("SIGKILL"))
+		})
+
+		It("kills the processes started by the command", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			_, err := bash.Execute(ctx, "(sleep 1; touch late-file) & sleep 30")
+			Expect(err).To(MatchError(agent.ErrCommandTimedOut))
+
+			Consistently(filepath.Join(dir, "late-file"), 2*time.Second).ShouldNot(BeAnExistingFile())
+		})
+	})
+
+	Context("when the context has no deadline", func() {
+		BeforeEach(func() {
+			bash = agent.NewBashExecutor(logger, dir, agent.WithTimeout(200*time.Millisecond))
+		})
+
+		It("applies the default timeout", func() {
+			_, err := bash.Execute(context.Background(), "sleep 30")
+			Expect(err).To(MatchError(agent.ErrCommandTimedOut))
+		})
+	})
+
+	Context("when the context is cancelled", func() {
+		It("kills the command", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(200*time.Millisecond, cancel)
+
+			_, err := bash.Execute(ctx, "sleep 30")
+			Expect(err).To(MatchError(context.Canceled))
+			Expect(err).ToNot(MatchError(agent.ErrCommandTimedOut))
+		})
+	})
+
+	Context("when a shell is configured", func() {
+		It("executes the command with the shell", func() {
+			bash = agent.NewBashExecutor(logger, dir, agent.WithShell("sh"))
+			r, err := bash.Execute(context.Background(), "printf 'hello world'")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Stdout).To(Equal("hello world"))
+		})
+
+		It("errors if the shell cannot be found", func() {
+			bash = agent.NewBashExecutor(logger, dir, agent.WithShell("no-such-shell"))
+			_, err := bash.Execute(context.Background(), "true")
+			Expect(err).To(MatchError(exec.ErrNotFound))
+		})
+	})
+
+	Describe("running programs", func() {
+		It("passes the arguments to the program without a shell", func() {
+			r, err := bash.RunProgram(context.Background(), []string{"printf", "%s|", "$HOME", "a b", "*", "'"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Stdout).To(Equal("$HOME|a b|*|'|"))
+			Expect(logOutput).To(gbytes.Say(`running program.*argv="\[printf`))
+		})
+
+		It("runs the program in the directory", func() {
+			_, err := bash.RunProgram(context.Background(), []string{"touch", "some-file"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(filepath.Join(dir, "some-file")).To(BeAnExistingFile())
+		})
+
+		It("returns the exit code", func() {
+			r, err := bash.RunProgram(context.Background(), []string{"sh", "-c", "exit 3"})
 			Expect(err).To(HaveOccurred())
-			Expect(o).To(Equal("still captured"))
+			Expect(r.ExitCode).To(Equal(3))
+		})
+
+		It("errors if the program cannot be found", func() {
+			_, err := bash.RunProgram(context.Background(), []string{"no-such-program"})
+			Expect(err).To(MatchError(exec.ErrNotFound))
+		})
+
+		It("errors if there is no program", func() {
+			_, err := bash.RunProgram(context.Background(), nil)
+			Expect(err).To(MatchError("argv must contain at least the program to run"))
+		})
+
+		Context("with limits", func() {
+			BeforeEach(func() {
+				bash = agent.NewBashExecutor(logger, dir, agent.WithLimits(agent.Limits{FileSize: 1024}))
+			})
+
+			It("passes the arguments to the program unchanged", func() {
+				r, err := bash.RunProgram(context.Background(), []string{"printf", "%s|", "$HOME", "a b"})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(r.Stdout).To(Equal("$HOME|a b|"))
+			})
+
+			It("applies the limits to the program", func() {
+				_, err := bash.RunProgram(context.Background(), []string{"dd", "if=/dev/zero", "of=big-file", "bs=4096", "count=1"})
+				var limitErr *agent.LimitExceededError
+				Expect(errors.As(err, &limitErr)).To(BeTrue())
+			})
 		})
 	})
 })
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// maxReadLines is the maximum number of lines returned by a single read.
+	maxReadLines = 2000
+	// maxReadLineLength is the length at which lines are cut short.
+	maxReadLineLength = 2000
+)
+
+// A SimpleFileReader reads the content of files.
+type SimpleFileReader struct {
+	logger    *slog.Logger
+	dir       string
+	readables []string
+}
+
+// A SimpleFileReaderOption configures a SimpleFileReader.
+type SimpleFileReaderOption func(*SimpleFileReader)
+
+// WithReadableDir allows ReadFile to read files within dir by their absolute
+// path, such as the command output saved by a TruncatingExecutor.
+func WithReadableDir(dir string) SimpleFileReaderOption {
+	return func(fr *SimpleFileReader) {
+		fr.readables = append(fr.readables, filepath.Clean(dir))
+	}
+}
+
+// NewSimpleFileReader creates a SimpleFileReader.
+// Paths are read relative to the directory specified with dir.
+func NewSimpleFileReader(logger *slog.Logger, dir string, opts ...SimpleFileReaderOption) *SimpleFileReader {
+	fr := &SimpleFileReader{logger: logger, dir: dir}
+	for _, o := range opts {
+		o(fr)
+	}
+	return fr
+}
+
+// ReadFile returns the lines of the file at path from start to end
+// inclusive, each prefixed with its line number. Lines are numbered from 1.
+// A start or end of 0 reads from the beginning or to the end of the file.
+// At most maxReadLines lines are returned, followed by a marker when lines
+// are omitted, and lines longer than maxReadLineLength are cut short.
+// It errors if path is not local or within a readable directory, if the
+// range is invalid or if there is an IO error.
+func (fr *SimpleFileReader) ReadFile(path string, start, end int) (string, error) {
+	fr.logger.Info("reading file", "path", path, "start", start, "end", end)
+	if start < 0 || end < 0 {
+		return "", fmt.Errorf("line numbers must not be negative: %d-%d", start, end)
+	}
+	if start == 0 {
+		start = 1
+	}
+	if end != 0 && end < start {
+		return "", fmt.Errorf("end line %d is before start line %d", end, start)
+	}
+
+	root, rel := fr.readablePath(path)
+	f, err := openInRoot(root, rel, os.O_RDONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var b strings.Builder
+	n, shown, last := 0, 0, 0
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for s.Scan() {
+		n++
+		if n < start || (end != 0 && n > end) {
+			continue
+		}
+		if shown == maxReadLines {
+			continue
+		}
+		line := s.Text()
+		if len(line) > maxReadLineLength {
+			line = fmt.Sprintf("%s... [line truncated: %d of %d bytes shown]", line[:maxReadLineLength], maxReadLineLength, len(line))
+		}
+		fmt.Fprintf(&b, "%6d\t%s\n", n, line)
+		shown++
+		last = n
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+
+	if start > n && !(n == 0 && start == 1) {
+		return "", fmt.Errorf("start line %d is beyond the end of the file (%d lines)", start, n)
+	}
+	if end == 0 || end > n {
+		end = n
+	}
+	if last < end {
+		fmt.Fprintf(&b, "[truncated: showing lines %d-%d of %d, read from line %d to see more]\n", start, last, n, last+1)
+	}
+	return b.String(), nil
+}
+
+// readablePath returns the directory that path is read from and the path
+// relative to it. Absolute paths within the readable directories are read
+// from them and other paths from the directory of the reader.
+func (fr *SimpleFileReader) readablePath(path string) (string, string) {
+	if filepath.IsAbs(path) {
+		for _, d := range fr.readables {
+			if rel, err := filepath.Rel(d, path); err == nil && filepath.IsLocal(rel) {
+				return d, rel
+			}
+		}
+	}
+	return fr.dir, path
+}
@@ -0,0 +1,172 @@
+package agent_test
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/acrmp/minimalprompt/agent"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("FileReader", func() {
+	var (
+		dir       string
+		fr        agent.FileReader
+		logger    *slog.Logger
+		logOutput *gbytes.Buffer
+	)
+
+	BeforeEach(func() {
+		var err error
+
+		logOutput = gbytes.NewBuffer()
+		logger = slog.New(slog.NewTextHandler(logOutput, nil))
+
+		dir, err = os.MkdirTemp("", "fr")
+		Expect(err).ToNot(HaveOccurred())
+		fr = agent.NewSimpleFileReader(logger, dir)
+
+		err = os.WriteFile(filepath.Join(dir, "filename"), []byte("one\ntwo\nthree\nfour\n"), 0600)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := os.RemoveAll(dir)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("reads the whole file with numbered lines", func() {
+		content, err := fr.ReadFile("filename", 0, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(content).To(Equal("     1\tone\n     2\ttwo\n     3\tthree\n     4\tfour\n"))
+	})
+
+	It("logs that it is performing the read", func() {
+		_, err := fr.ReadFile("filename", 0, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(logOutput).To(gbytes.Say(`reading file.*filename`))
+	})
+
+	It("reads the requested range of lines", func() {
+		content, err := fr.ReadFile("filename", 2, 3)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(content).To(Equal("     2\ttwo\n     3\tthree\n"))
+	})
+
+	It("reads to the end of the file when only the start is provided", func() {
+		content, err := fr.ReadFile("filename", 3, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(content).To(Equal("     3\tthree\n     4\tfour\n"))
+	})
+
+	It("reads to the end of the file when the end is past the last line", func() {
+		content, err := fr.ReadFile("filename", 4, 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(content).To(Equal("     4\tfour\n"))
+	})
+
+	Context("when the file is very large", func() {
+		BeforeEach(func() {
+			var b strings.Builder
+			for i := 1; i <= 2500; i++ {
+				fmt.Fprintf(&b, "line %d\n", i)
+			}
+			err := os.WriteFile(filepath.Join(dir, "large"), []byte(b.String()), 0600)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("truncates the content and says how to read more", func() {
+			content, err := fr.ReadFile("large", 0, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(content).To(ContainSubstring("  2000\tline 2000\n"))
+			Expect(content).ToNot(ContainSubstring("\tline 2001\n"))
+			Expect(content).To(HaveSuffix("[truncated: showing lines 1-2000 of 2500, read from line 2001 to see more]\n"))
+		})
+	})
+
+	Context("when a line is very long", func() {
+		BeforeEach(func() {
+			err := os.WriteFile(filepath.Join(dir, "minified.json"), []byte(strings.Repeat("x", 5000)+"\nend\n"), 0600)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("cuts the line short", func() {
+			content, err := fr.ReadFile("minified.json", 0, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(content).To(Equal("     1\t" + strings.Repeat("x", 2000) + "... [line truncated: 2000 of 5000 bytes shown]\n     2\tend\n"))
+		})
+	})
+
+	Context("when the start is beyond the end of the file", func() {
+		It("errors", func() {
+			_, err := fr.ReadFile("filename", 5, 0)
+			Expect(err).To(MatchError("start line 5 is beyond the end of the file (4 lines)"))
+		})
+	})
+
+	Context("when the end is before the start", func() {
+		It("errors", func() {
+			_, err := fr.ReadFile("filename", 3, 2)
+			Expect(err).To(MatchError("end line 2 is before start line 3"))
+		})
+	})
+
+	Context("when the file does not exist", func() {
+		It("errors", func() {
+			_, err := fr.ReadFile("missing", 0, 0)
+			Expect(err).To(MatchError(os.ErrNotExist))
+		})
+	})
+
+	Context("when the path is not a local path", func() {
+		It("errors", func() {
+			_, err := fr.ReadFile("../../traversal", 0, 0)
+			Expect(err).To(MatchError(`path is not a local path: "../../traversal"`))
+		})
+	})
+	Context("when the path leads through a symlink that leaves the directory", func() {
+		It("errors", func() {
+			outside := GinkgoT().TempDir()
+			Expect(os.WriteFile(filepath.Join(outside, "id_rsa"), []byte("secret\n"), 0600)).To(Succeed())
+			Expect(os.Symlink(outside, filepath.Join(dir, "keys"))).To(Succeed())
+
+			_, err := fr.ReadFile("keys/id_rsa", 0, 0)
+			Expect(err).To(MatchError(`path leads outside of the directory through a symlink: "keys/id_rsa"`))
+		})
+	})
+
+	Context("when the path is within a readable directory", func() {
+		var readable string
+
+		BeforeEach(func() {
+			var err error
+			readable, err = os.MkdirTemp("", "artifacts")
+			Expect(err).ToNot(HaveOccurred())
+			err = os.WriteFile(filepath.Join(readable, "output.txt"), []byte("saved\n"), 0600)
+			Expect(err).ToNot(HaveOccurred())
+
+			fr = agent.NewSimpleFileReader(logger, dir, agent.WithReadableDir(readable))
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(readable)).To(Succeed())
+		})
+
+		It("reads the file by its absolute path", func() {
+			content, err := fr.ReadFile(filepath.Join(readable, "output.txt"), 0, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(content).To(Equal("     1\tsaved\n"))
+		})
+
+		It("does not read outside the readable directory", func() {
+			path := filepath.Join(readable, "..", "traversal")
+			_, err := fr.ReadFile(path, 0, 0)
+			Expect(err).To(MatchError(fmt.Sprintf("path is not a local path: %q", path)))
+		})
+	})
+})
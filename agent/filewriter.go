@@ -1,10 +1,10 @@
 package agent
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
-	"os"
-	"path/filepath"
+	"strings"
 )
 
 // A SimpleFileWriter writes content to a file.
@@ -21,20 +21,38 @@ func NewSimpleFileWriter(logger *slog.Logger, dir string) *SimpleFileWriter {
 
 // WriteFile writes the specified content to the path specified.
 // Intermediate directories for path are created if they don't already exist.
-// It errors if path is not local or if there is an IO error.
-func (fw *SimpleFileWriter) WriteFile(path, content string) error {
-	fw.logger.Info("writing file", "path", path)
-	if !filepath.IsLocal(path) {
-		return fmt.Errorf("path is not a local path: %q", path)
+// Existing files keep their permissions and new files are only readable by
+// the user. The file is also made executable when executable is true.
+// It errors if path is not local, if it leads outside of the directory
+// through a symlink or if there is an IO error.
+func (fw *SimpleFileWriter) WriteFile(ctx context.Context, path, content string, executable bool) error {
+	fw.logger.Info("writing file", "path", path, "executable", executable)
+	return writeFileInRoot(fw.dir, path, []byte(content), 0600, executable)
+}
+
+// EditFile replaces oldString with newString in the file at path.
+// It errors if path is not local, if it leads outside of the directory
+// through a symlink, if oldString does not occur exactly once in the file or
+// if there is an IO error.
+func (fw *SimpleFileWriter) EditFile(ctx context.Context, path, oldString, newString string) error {
+	fw.logger.Info("editing file", "path", path)
+	if oldString == "" {
+		return fmt.Errorf("old_string must not be empty")
 	}
-	f := filepath.Join(fw.dir, path)
-	if err := os.MkdirAll(filepath.Dir(f), 0700); err != nil {
+	b, _, err := readFileInRoot(fw.dir, path)
+	if err != nil {
 		return err
 	}
 
-	if err := os.WriteFile(f, []byte(content), 0600); err != nil {
-		return err
+	content := string(b)
+	switch n := strings.Count(content, oldString); n {
+	case 0:
+		return fmt.Errorf("old_string was not found in %q", path)
+	case 1:
+	default:
+		return fmt.Errorf("old_string matches %d times in %q, include more surrounding text to make it unique", n, path)
 	}
 
-	return nil
+	content = strings.Replace(content, oldString, newString, 1)
+	return writeFileInRoot(fw.dir, path, []byte(content), 0600, false)
 }
@@ -1,8 +1,10 @@
 package agent_test
 
 import (
+	"context"
 	"log/slog"
 	"os"
+	"os/exec"
 	"path/filepath"
 
 	"github.com/acrmp/minimalprompt/agent"
@@ -36,7 +38,7 @@ var _ = Describe("FileWriter", func() {
 	})
 
 	It("writes the provided content to the specified path", func() {
-		err := fw.WriteFile("filename", "some content")
+		err := fw.WriteFile(context.Background(), "filename", "some content", false)
 		Expect(err).ToNot(HaveOccurred())
 
 		b, err := os.ReadFile(filepath.Join(dir, "filename"))
@@ -45,14 +47,70 @@ var _ = Describe("FileWriter", func() {
 	})
 
 	It("logs that it is performing the write", func() {
-		err := fw.WriteFile("filename", "some content")
+		err := fw.WriteFile(context.Background(), "filename", "some content", false)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(logOutput).To(gbytes.Say(`writing file.*filename`))
 	})
 
+	It("creates new files only readable by the user", func() {
+		Expect(fw.WriteFile(context.Background(), "filename", "some content", false)).To(Succeed())
+
+		fi, err := os.Stat(filepath.Join(dir, "filename"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fi.Mode().Perm()).To(Equal(os.FileMode(0600)))
+	})
+
+	It("preserves the mode of existing files", func() {
+		Expect(os.WriteFile(filepath.Join(dir, "run.sh"), []byte("#!/bin/sh\n"), 0755)).To(Succeed())
+		Expect(os.Chmod(filepath.Join(dir, "run.sh"), 0755)).To(Succeed())
+
+		Expect(fw.WriteFile(context.Background(), "run.sh", "#!/bin/sh\necho hello\n", false)).To(Succeed())
+
+		fi, err := os.Stat(filepath.Join(dir, "run.sh"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fi.Mode().Perm()).To(Equal(os.FileMode(0755)))
+	})
+
+	It("replaces the file rather than writing over it", func() {
+		Expect(os.WriteFile(filepath.Join(dir, "filename"), []byte("old content"), 0600)).To(Succeed())
+		Expect(os.Link(filepath.Join(dir, "filename"), filepath.Join(dir, "hardlink"))).To(Succeed())
+
+		Expect(fw.WriteFile(context.Background(), "filename", "new content", false)).To(Succeed())
+
+		Expect(os.ReadFile(filepath.Join(dir, "filename"))).To(Equal([]byte("new content")))
+		Expect(os.ReadFile(filepath.Join(dir, "hardlink"))).To(Equal([]byte("old content")))
+		entries, err := os.ReadDir(dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(HaveLen(2))
+	})
+
+	Context("when the file is executable", func() {
+		It("creates new files executable by the user", func() {
+			Expect(fw.WriteFile(context.Background(), "build.sh", "#!/bin/sh\n", true)).To(Succeed())
+
+			fi, err := os.Stat(filepath.Join(dir, "build.sh"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fi.Mode().Perm()).To(Equal(os.FileMode(0700)))
+
+			out, err := exec.Command(filepath.Join(dir, "build.sh")).CombinedOutput()
+			Expect(err).ToNot(HaveOccurred(), string(out))
+		})
+
+		It("makes existing files executable by everyone who can read them", func() {
+			Expect(os.WriteFile(filepath.Join(dir, "build.sh"), nil, 0644)).To(Succeed())
+			Expect(os.Chmod(filepath.Join(dir, "build.sh"), 0644)).To(Succeed())
+
+			Expect(fw.WriteFile(context.Background(), "build.sh", "#!/bin/sh\n", true)).To(Succeed())
+
+			fi, err := os.Stat(filepath.Join(dir, "build.sh"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fi.Mode().Perm()).To(Equal(os.FileMode(0755)))
+		})
+	})
+
 	Context("when the provided path includes a directory", func() {
 		It("makes any directories necessary", func() {
-			err := fw.WriteFile("file/name/in/deeply/nested/path", "some content")
+			err := fw.WriteFile(context.Background(), "file/name/in/deeply/nested/path", "some content", false)
 			Expect(err).ToNot(HaveOccurred())
 
 			b, err := os.ReadFile(filepath.Join(dir, "file/name/in/deeply/nested/path"))
@@ -68,7 +126,7 @@ var _ = Describe("FileWriter", func() {
 				Expect(err).ToNot(HaveOccurred())
 			})
 			It("errors", func() {
-				err := fw.WriteFile("file/name/in/deeply/nested/path", "some content")
+				err := fw.WriteFile(context.Background(), "file/name/in/deeply/nested/path", "some content", false)
 				Expect(err).To(HaveOccurred())
 			})
 		})
@@ -78,14 +136,169 @@ var _ = Describe("FileWriter", func() {
 				Expect(err).ToNot(HaveOccurred())
 			})
 			It("errors", func() {
-				err := fw.WriteFile("file/name/in/deeply/nested/path", "some content")
+				err := fw.WriteFile(context.Background(), "file/name/in/deeply/nested/path", "some content", false)
 				Expect(err).To(HaveOccurred())
 			})
 		})
 
 		Context("when the path is not a local path", func() {
 			It("errors", func() {
-				err := fw.WriteFile("../../traversal", "some content")
+				err := fw.WriteFile(context.Background(), "../../traversal", "some content", false)
+				Expect(err).To(MatchError(`path is not a local path: "../../traversal"`))
+			})
+		})
+	})
+	Context("when the path leads through a symlink", func() {
+		var outside string
+
+		BeforeEach(func() {
+			outside = GinkgoT().TempDir()
+			Expect(os.WriteFile(filepath.Join(outside, "authorized_keys"), []byte("original"), 0600)).To(Succeed())
+		})
+
+		It("writes through symlinks within the directory", func() {
+			Expect(os.Mkdir(filepath.Join(dir, "src"), 0700)).To(Succeed())
+			Expect(os.Symlink("src", filepath.Join(dir, "relative"))).To(Succeed())
+			Expect(os.Symlink(filepath.Join(dir, "src"), filepath.Join(dir, "absolute"))).To(Succeed())
+			Expect(os.Symlink("../src/main.go", filepath.Join(dir, "src", "link.go"))).To(Succeed())
+
+			Expect(fw.WriteFile(context.Background(), "relative/a.go", "a", false)).To(Succeed())
+			Expect(fw.WriteFile(context.Background(), "absolute/b.go", "b", false)).To(Succeed())
+			Expect(fw.WriteFile(context.Background(), "src/link.go", "main", false)).To(Succeed())
+
+			Expect(os.ReadFile(filepath.Join(dir, "src", "a.go"))).To(Equal([]byte("a")))
+			Expect(os.ReadFile(filepath.Join(dir, "src", "b.go"))).To(Equal([]byte("b")))
+			Expect(os.ReadFile(filepath.Join(dir, "src", "main.go"))).To(Equal([]byte("main")))
+		})
+
+		It("does not write through a directory symlink that leaves the directory", func() {
+			Expect(os.Symlink(outside, filepath.Join(dir, "keys"))).To(Succeed())
+
+			err := fw.WriteFile(context.Background(), "keys/authorized_keys", "attacker", false)
+			Expect(err).To(MatchError(`path leads outside of the directory through a symlink: "keys/authorized_keys"`))
+			Expect(os.ReadFile(filepath.Join(outside, "authorized_keys"))).To(Equal([]byte("original")))
+		})
+
+		It("does not write through a relative symlink that leaves the directory", func() {
+			Expect(os.Mkdir(filepath.Join(dir, "src"), 0700)).To(Succeed())
+			rel, err := filepath.Rel(filepath.Join(dir, "src"), filepath.Join(outside, "authorized_keys"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.Symlink(rel, filepath.Join(dir, "src", "keys"))).To(Succeed())
+
+			Expect(fw.WriteFile(context.Background(), "src/keys", "attacker", false)).To(MatchError(ContainSubstring("through a symlink")))
+			Expect(os.ReadFile(filepath.Join(outside, "authorized_keys"))).To(Equal([]byte("original")))
+		})
+
+		It("does not create files through a dangling symlink that leaves the directory", func() {
+			Expect(os.Symlink(filepath.Join(outside, "new"), filepath.Join(dir, "dangling"))).To(Succeed())
+
+			Expect(fw.WriteFile(context.Background(), "dangling", "attacker", false)).To(MatchError(ContainSubstring("through a symlink")))
+			Expect(filepath.Join(outside, "new")).ToNot(BeAnExistingFile())
+		})
+
+		It("does not edit files through a symlink that leaves the directory", func() {
+			Expect(os.Symlink(filepath.Join(outside, "authorized_keys"), filepath.Join(dir, "keys"))).To(Succeed())
+
+			err := fw.(agent.FileEditor).EditFile(context.Background(), "keys", "original", "attacker")
+			Expect(err).To(MatchError(ContainSubstring("through a symlink")))
+			Expect(os.ReadFile(filepath.Join(outside, "authorized_keys"))).To(Equal([]byte("original")))
+		})
+
+		It("does not write outside the directory when a directory is swapped for a symlink", func() {
+			sub := filepath.Join(dir, "sub")
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 500; i++ {
+					os.RemoveAll(sub)
+					if i%2 == 0 {
+						os.Mkdir(sub, 0700)
+					} else {
+						os.Symlink(outside, sub)
+					}
+				}
+			}()
+			for i := 0; i < 500; i++ {
+				_ = fw.WriteFile(context.Background(), "sub/planted", "attacker", false)
+			}
+			<-done
+
+			Expect(filepath.Join(outside, "planted")).ToNot(BeAnExistingFile())
+		})
+	})
+
+	Describe("editing files", func() {
+		var fe agent.FileEditor
+
+		BeforeEach(func() {
+			fe = agent.NewSimpleFileWriter(logger, dir)
+			err := os.WriteFile(filepath.Join(dir, "filename"), []byte("the red car\nthe blue car\n"), 0750)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("replaces the old string with the new string", func() {
+			err := fe.EditFile(context.Background(), "filename", "red", "yellow")
+			Expect(err).ToNot(HaveOccurred())
+
+			b, err := os.ReadFile(filepath.Join(dir, "filename"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(b)).To(Equal("the yellow car\nthe blue car\n"))
+		})
+
+		It("preserves the file mode", func() {
+			err := fe.EditFile(context.Background(), "filename", "red", "yellow")
+			Expect(err).ToNot(HaveOccurred())
+
+			fi, err := os.Stat(filepath.Join(dir, "filename"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fi.Mode().Perm()).To(Equal(os.FileMode(0750)))
+		})
+
+		It("logs that it is performing the edit", func() {
+			err := fe.EditFile(context.Background(), "filename", "red", "yellow")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(logOutput).To(gbytes.Say(`editing file.*filename`))
+		})
+
+		Context("when the old string is not found", func() {
+			It("errors without changing the file", func() {
+				err := fe.EditFile(context.Background(), "filename", "green", "yellow")
+				Expect(err).To(MatchError(`old_string was not found in "filename"`))
+
+				b, err := os.ReadFile(filepath.Join(dir, "filename"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(b)).To(Equal("the red car\nthe blue car\n"))
+			})
+		})
+
+		Context("when the old string matches more than once", func() {
+			It("errors without changing the file", func() {
+				err := fe.EditFile(context.Background(), "filename", "car", "bus")
+				Expect(err).To(MatchError(`old_string matches 2 times in "filename", include more surrounding text to make it unique`))
+
+				b, err := os.ReadFile(filepath.Join(dir, "filename"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(b)).To(Equal("the red car\nthe blue car\n"))
+			})
+		})
+
+		Context("when the old string is empty", func() {
+			It("errors", func() {
+				err := fe.EditFile(context.Background(), "filename", "", "yellow")
+				Expect(err).To(MatchError("old_string must not be empty"))
+			})
+		})
+
+		Context("when the file does not exist", func() {
+			It("errors", func() {
+				err := fe.EditFile(context.Background(), "missing", "red", "yellow")
+				Expect(err).To(MatchError(os.ErrNotExist))
+			})
+		})
+
+		Context("when the path is not a local path", func() {
+			It("errors", func() {
+				err := fe.EditFile(context.Background(), "../../traversal", "red", "yellow")
 				Expect(err).To(MatchError(`path is not a local path: "../../traversal"`))
 			})
 		})
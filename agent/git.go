@@ -0,0 +1,268 @@
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// gitSafeConfig overrides the configuration that makes git run other
+// programs, as the configuration of the repository may have been written by
+// the commands of the LLM.
+var gitSafeConfig = []string{
+	"-c", "core.fsmonitor=",
+	"-c", "core.hooksPath=/dev/null",
+	"-c", "commit.gpgSign=false",
+	"-c", "diff.external=",
+}
+
+// gitProgramConfig matches the configuration of filters and diff drivers,
+// which run programs named in the configuration for the files that
+// .gitattributes assigns to them.
+const gitProgramConfig = `^(filter|diff)\..+\.(clean|smudge|process|required|command|textconv)$`
+
+// A GitDriver runs git in a directory directly, rather than through a
+// CommandExecutor, so that it is not subject to the sandbox, policy or
+// approval of the commands of the LLM.
+//
+// As the repository may have been changed by the LLM, git runs with the
+// environment of commands and ignores the system configuration, hooks,
+// fsmonitor, commit signing, filters and diff drivers so that it does not
+// run any other program.
+type GitDriver struct {
+	logger *slog.Logger
+	dir    string
+	env    *Environment
+}
+
+// A GitDriverOption configures a GitDriver.
+type GitDriverOption func(*GitDriver)
+
+// WithGitEnvironment sets the environment variables that git runs with. It
+// defaults to the variables in DefaultEnvironment.
+func WithGitEnvironment(e *Environment) GitDriverOption {
+	return func(g *GitDriver) {
+		g.env = e
+	}
+}
+
+// NewGitDriver creates a GitDriver for the repository containing dir.
+func NewGitDriver(logger *slog.Logger, dir string, opts ...GitDriverOption) *GitDriver {
+	g := &GitDriver{logger: logger, dir: dir}
+	for _, o := range opts {
+		o(g)
+	}
+	return g
+}
+
+// IsRepo returns true if the directory is within a git work tree.
+func (g *GitDriver) IsRepo() bool {
+	out, err := g.run("", "rev-parse", "--is-inside-work-tree")
+	return err == nil && out == "true"
+}
+
+// Head returns the commit hash of HEAD, which is empty if the current branch
+// has no commits yet.
+func (g *GitDriver) Head() (string, error) {
+	out, err := g.run("", "rev-parse", "--verify", "--quiet", "HEAD")
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return "", nil
+	}
+	return out, err
+}
+
+// Branch returns the name of the current branch, which is empty if HEAD is
+// detached.
+func (g *GitDriver) Branch() (string, error) {
+	out, err := g.run("", "symbolic-ref", "--quiet", "--short", "HEAD")
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return "", nil
+	}
+	return out, err
+}
+
+// CreateBranch creates the branch at HEAD and switches to it.
+func (g *GitDriver) CreateBranch(name string) error {
+	_, err := g.run("", "switch", "--quiet", "--create", name)
+	return err
+}
+
+// Status returns the short status of the files within the directory, which
+// is empty if there are no changes.
+func (g *GitDriver) Status() (string, error) {
+	return g.run("", "status", "--porcelain", "--", ".")
+}
+
+// CommitAll commits every change to the files within the directory, including
+// new and deleted files, with message. It returns the hash of the commit, or
+// an empty string without committing if there are no changes.
+// Commit hooks are not run.
+func (g *GitDriver) CommitAll(message string) (string, error) {
+	if _, err := g.run("", "add", "--all", "--", "."); err != nil {
+		return "", err
+	}
+	_, err := g.run("", "diff", "--cached", "--quiet", "--no-ext-diff", "--no-textconv", "--", ".")
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return "", nil
+	case !errors.As(err, &exitErr) || exitErr.ExitCode() != 1:
+		return "", err
+	}
+	if _, err := g.run(message, "commit", "--quiet", "--no-verify", "--cleanup=whitespace", "--file=-", "--", "."); err != nil {
+		return "", err
+	}
+	return g.Head()
+}
+
+// run runs git with args, passing stdin, and returns its trimmed stdout.
+func (g *GitDriver) run(stdin string, args ...string) (string, error) {
+	config, err := g.programConfig()
+	if err != nil {
+		return "", err
+	}
+	return g.runWithConfig(stdin, slices.Concat(gitSafeConfig, config), args...)
+}
+
+// programConfig returns the options that turn off the filters and diff
+// drivers configured for the repository.
+func (g *GitDriver) programConfig() ([]string, error) {
+	out, err := g.runWithConfig("", gitSafeConfig, "config", "--name-only", "--get-regexp", gitProgramConfig)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var config []string
+	for _, key := range strings.Fields(out) {
+		value := ""
+		if strings.HasSuffix(key, ".required") {
+			value = "false"
+		}
+		config = append(config, "-c", key+"="+value)
+	}
+	return config, nil
+}
+
+// runWithConfig runs git with the config options followed by args.
+func (g *GitDriver) runWithConfig(stdin string, config []string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", slices.Concat(config, args)...)
+	cmd.Dir = g.dir
+	cmd.Env = append(g.env.environ(), "GIT_CONFIG_NOSYSTEM=1")
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	g.logger.Debug("running git", "args", args)
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %w: %s", args[0], err, msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// A GitCheckpointer commits the changes made in each turn of the LLM to a
+// branch of its own, using the text of the LLM as the commit message.
+type GitCheckpointer struct {
+	logger  *slog.Logger
+	git     *GitDriver
+	branch  string
+	from    string
+	base    string
+	head    string
+	commits int
+}
+
+// NewGitCheckpointer creates a GitCheckpointer that switches the repository
+// of git to a new branch at HEAD. It errors if the files within the directory
+// of git have uncommitted changes, which would otherwise be committed with
+// the first turn.
+func NewGitCheckpointer(logger *slog.Logger, git *GitDriver, branch string) (*GitCheckpointer, error) {
+	status, err := git.Status()
+	if err != nil {
+		return nil, err
+	}
+	if status != "" {
+		return nil, fmt.Errorf("the directory has uncommitted changes, commit or stash them first:\n%s", status)
+	}
+	from, err := git.Branch()
+	if err != nil {
+		return nil, err
+	}
+	base, err := git.Head()
+	if err != nil {
+		return nil, err
+	}
+	if err := git.CreateBranch(branch); err != nil {
+		return nil, err
+	}
+	logger.Info("checkpointing to branch", "branch", branch, "from", from, "base", base)
+	return &GitCheckpointer{logger: logger, git: git, branch: branch, from: from, base: base, head: base}, nil
+}
+
+// StartTurn does nothing, as changes are committed at the end of each turn.
+func (c *GitCheckpointer) StartTurn(turn int) {}
+
+// StartToolCall does nothing, as changes are committed at the end of each
+// turn.
+func (c *GitCheckpointer) StartToolCall(id, tool string) {}
+
+// EndTurn commits the changes made in the turn, if any, with the text of the
+// LLM as the commit message. Failures are logged rather than ending the
+// session.
+func (c *GitCheckpointer) EndTurn(turn int, text string) {
+	message := strings.TrimSpace(text)
+	if message == "" {
+		message = fmt.Sprintf("Turn %d", turn)
+	}
+	hash, err := c.git.CommitAll(message)
+	if err != nil {
+		c.logger.Warn("checkpointing turn", "turn", turn, "err", err)
+		return
+	}
+	if hash == "" {
+		return
+	}
+	c.head = hash
+	c.commits++
+	c.logger.Info("checkpointed turn", "turn", turn, "commit", hash)
+}
+
+// A CheckpointSummary describes the commits made by a GitCheckpointer.
+type CheckpointSummary struct {
+	// Branch is the branch that the commits were made to.
+	Branch string
+	// From is the branch that Branch was created from, which is empty if
+	// HEAD was detached.
+	From string
+	// Base is the commit that Branch was created at, which is empty if there
+	// were no commits, and Head is the last commit made to Branch.
+	Base    string
+	Head    string
+	Commits int
+}
+
+// Range returns the range of the commits for git commands such as git log or
+// git cherry-pick. It is just the head when the branch had no commits to
+// begin with.
+func (s CheckpointSummary) Range() string {
+	if s.Base == "" {
+		return s.Head
+	}
+	return s.Base + ".." + s.Head
+}
+
+// Summary returns the branch and the commits made to it.
+func (c *GitCheckpointer) Summary() CheckpointSummary {
+	return CheckpointSummary{Branch: c.branch, From: c.from, Base: c.base, Head: c.head, Commits: c.commits}
+}
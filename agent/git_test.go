@@ -0,0 +1,201 @@
+package agent_test
+
+import (
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/acrmp/minimalprompt/agent"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("GitCheckpointer", func() {
+	var (
+		dir       string
+		logger    *slog.Logger
+		logOutput *gbytes.Buffer
+		driver    *agent.GitDriver
+	)
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		Expect(err).ToNot(HaveOccurred(), string(out))
+		return strings.TrimSpace(string(out))
+	}
+
+	write := func(path, content string) {
+		Expect(os.WriteFile(filepath.Join(dir, path), []byte(content), 0600)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		if _, err := exec.LookPath("git"); err != nil {
+			Skip("git is not installed")
+		}
+		dir = GinkgoT().TempDir()
+		logOutput = gbytes.NewBuffer()
+		logger = slog.New(slog.NewTextHandler(logOutput, nil))
+		driver = agent.NewGitDriver(logger, dir)
+
+		git("init", "--quiet", "--initial-branch=main")
+		git("config", "user.name", "Test")
+		git("config", "user.email", "test@example.com")
+		write("README.md", "# Calculator\n")
+		git("add", "README.md")
+		git("commit", "--quiet", "-m", "Initial commit")
+	})
+
+	It("starts a new branch", func() {
+		base := git("rev-parse", "HEAD")
+		c, err := agent.NewGitCheckpointer(logger, driver, "minimalprompt/session")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(git("branch", "--show-current")).To(Equal("minimalprompt/session"))
+		s := c.Summary()
+		Expect(s.Branch).To(Equal("minimalprompt/session"))
+		Expect(s.From).To(Equal("main"))
+		Expect(s.Base).To(Equal(base))
+		Expect(s.Commits).To(BeZero())
+	})
+
+	It("commits the changes of each turn with the text of the model", func() {
+		base := git("rev-parse", "HEAD")
+		c, err := agent.NewGitCheckpointer(logger, driver, "minimalprompt/session")
+		Expect(err).ToNot(HaveOccurred())
+
+		write("calc.go", "package calc\n")
+		c.EndTurn(1, "# Plan\n\nAdd the calc package.\n")
+		Expect(os.Remove(filepath.Join(dir, "README.md"))).To(Succeed())
+		c.EndTurn(2, "")
+
+		Expect(git("log", "--format=%B", "-1", "HEAD~1")).To(Equal("# Plan\n\nAdd the calc package."))
+		Expect(git("log", "--format=%s", "-1")).To(Equal("Turn 2"))
+		Expect(git("status", "--porcelain")).To(BeEmpty())
+		Expect(git("rev-parse", "main")).To(Equal(base))
+
+		s := c.Summary()
+		Expect(s.Commits).To(Equal(2))
+		Expect(s.Head).To(Equal(git("rev-parse", "HEAD")))
+		Expect(s.Range()).To(Equal(base + ".." + s.Head))
+		Expect(git("rev-list", "--count", s.Range())).To(Equal("2"))
+		Expect(logOutput).To(gbytes.Say(`checkpointed turn" turn=1 commit=`))
+	})
+
+	It("does not commit turns without changes", func() {
+		c, err := agent.NewGitCheckpointer(logger, driver, "minimalprompt/session")
+		Expect(err).ToNot(HaveOccurred())
+
+		c.EndTurn(1, "Nothing to do")
+		Expect(git("rev-list", "--count", "HEAD")).To(Equal("1"))
+		Expect(c.Summary().Commits).To(BeZero())
+	})
+
+	It("only commits changes within the directory", func() {
+		Expect(os.Mkdir(filepath.Join(dir, "project"), 0700)).To(Succeed())
+		write("other.txt", "outside\n")
+		git("add", "other.txt")
+		git("commit", "--quiet", "-m", "Add other")
+		write("other.txt", "changed outside\n")
+
+		c, err := agent.NewGitCheckpointer(logger, agent.NewGitDriver(logger, filepath.Join(dir, "project")), "minimalprompt/session")
+		Expect(err).ToNot(HaveOccurred())
+		write("project/main.go", "package main\n")
+		c.EndTurn(1, "Add main")
+
+		Expect(git("show", "--name-only", "--format=", "HEAD")).To(Equal("project/main.go"))
+		Expect(git("status", "--porcelain")).To(Equal("M other.txt"))
+	})
+
+	Context("when the repository has no commits", func() {
+		BeforeEach(func() {
+			dir = GinkgoT().TempDir()
+			driver = agent.NewGitDriver(logger, dir)
+			git("init", "--quiet", "--initial-branch=main")
+			git("config", "user.name", "Test")
+			git("config", "user.email", "test@example.com")
+		})
+
+		It("commits to the new branch from its root", func() {
+			c, err := agent.NewGitCheckpointer(logger, driver, "minimalprompt/session")
+			Expect(err).ToNot(HaveOccurred())
+			write("main.go", "package main\n")
+			c.EndTurn(1, "Add main")
+
+			s := c.Summary()
+			Expect(s.Base).To(BeEmpty())
+			Expect(s.Range()).To(Equal(git("rev-parse", "HEAD")))
+			Expect(git("branch", "--show-current")).To(Equal("minimalprompt/session"))
+		})
+	})
+
+	Context("when there are uncommitted changes", func() {
+		It("errors without starting a branch", func() {
+			write("README.md", "# Changed\n")
+			_, err := agent.NewGitCheckpointer(logger, driver, "minimalprompt/session")
+			Expect(err).To(MatchError(ContainSubstring("uncommitted changes")))
+			Expect(git("branch", "--show-current")).To(Equal("main"))
+		})
+	})
+
+	Context("when a commit fails", func() {
+		It("logs the failure", func() {
+			c, err := agent.NewGitCheckpointer(logger, driver, "minimalprompt/session")
+			Expect(err).ToNot(HaveOccurred())
+			write("main.go", "package main\n")
+			write(".git/index.lock", "")
+
+			c.EndTurn(1, "Add main")
+			Expect(c.Summary().Commits).To(BeZero())
+			Expect(logOutput).To(gbytes.Say(`checkpointing turn" turn=1 err="git add: exit status 128: .*index.lock`))
+		})
+	})
+
+	Context("when the repository configures programs for git to run", func() {
+		It("commits without running them", func() {
+			ran := filepath.Join(GinkgoT().TempDir(), "ran")
+			program := "touch " + ran
+			Expect(os.Mkdir(filepath.Join(dir, ".githooks"), 0700)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, ".githooks", "pre-commit"), []byte("#!/bin/sh\n"+program+"\n"), 0700)).To(Succeed())
+			write(".gitattributes", "*.go filter=evil diff=evil\n")
+			git("add", ".")
+			git("commit", "--quiet", "-m", "Add attributes")
+			git("config", "core.fsmonitor", program)
+			git("config", "core.hooksPath", ".githooks")
+			git("config", "filter.evil.clean", program)
+			git("config", "filter.evil.required", "true")
+			git("config", "diff.evil.textconv", program)
+			git("config", "diff.external", program)
+
+			c, err := agent.NewGitCheckpointer(logger, driver, "minimalprompt/session")
+			Expect(err).ToNot(HaveOccurred())
+			write("main.go", "package main\n")
+			c.EndTurn(1, "Add main")
+
+			Expect(ran).ToNot(BeAnExistingFile())
+			Expect(c.Summary().Commits).To(Equal(1))
+			Expect(git("cat-file", "blob", "HEAD:main.go")).To(Equal("package main"))
+		})
+	})
+
+	Describe("GitDriver", func() {
+		It("knows whether the directory is a git repository", func() {
+			Expect(driver.IsRepo()).To(BeTrue())
+			Expect(agent.NewGitDriver(logger, GinkgoT().TempDir()).IsRepo()).To(BeFalse())
+		})
+
+		It("runs git with the environment of commands", func() {
+			GinkgoT().Setenv("GIT_DIR", filepath.Join(GinkgoT().TempDir(), "missing"))
+			Expect(driver.Status()).To(BeEmpty())
+
+			env, err := agent.NewEnvironment(agent.EnvironmentConfig{Pass: []string{"GIT_DIR"}})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = agent.NewGitDriver(logger, dir, agent.WithGitEnvironment(env)).Status()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,145 @@
+package agent
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// defaultIgnored are directory names that are never listed or searched.
+var defaultIgnored = map[string]bool{
+	".git":         true,
+	"vendor":       true,
+	"node_modules": true,
+}
+
+// An ignoreRule is a single pattern from a .gitignore file.
+type ignoreRule struct {
+	base     string
+	re       *regexp.Regexp
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// A gitignore matches slash separated paths, relative to the root directory,
+// against the rules of the .gitignore files that have been loaded.
+type gitignore struct {
+	rules []ignoreRule
+}
+
+// load adds the rules of the .gitignore file in the directory rel, if any.
+func (g *gitignore) load(root, rel string) {
+	f, err := openInRoot(root, filepath.FromSlash(path.Join(rel, ".gitignore")), os.O_RDONLY, 0)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), " ")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r := ignoreRule{base: rel}
+		if strings.HasPrefix(line, "!") {
+			r.negate, line = true, line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`)
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly, line = true, strings.TrimSuffix(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			r.anchored, line = true, strings.TrimPrefix(line, "/")
+		}
+		re, err := regexp.Compile("^" + globToRegexp(line) + "$")
+		if err != nil {
+			continue
+		}
+		r.re = re
+		g.rules = append(g.rules, r)
+	}
+}
+
+// loadParents loads the .gitignore files from the root down to the directory
+// rel, including rel itself.
+func (g *gitignore) loadParents(root, rel string) {
+	g.load(root, ".")
+	if rel == "." {
+		return
+	}
+	dir := ""
+	for _, p := range strings.Split(rel, "/") {
+		dir = path.Join(dir, p)
+		g.load(root, dir)
+	}
+}
+
+// ignored reports whether rel should be excluded. The last matching rule
+// wins, so later negated rules re-include paths.
+func (g *gitignore) ignored(rel string, isDir bool) bool {
+	name := path.Base(rel)
+	if isDir && defaultIgnored[name] {
+		return true
+	}
+	ignored := false
+	for _, r := range g.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		sub := rel
+		if r.base != "." {
+			if !strings.HasPrefix(rel, r.base+"/") {
+				continue
+			}
+			sub = strings.TrimPrefix(rel, r.base+"/")
+		}
+		target := name
+		if r.anchored {
+			target = sub
+		}
+		if r.re.MatchString(target) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// globToRegexp converts a gitignore glob into a regular expression.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			j := strings.IndexByte(glob[i:], ']')
+			if j < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += j
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,468 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// A JournalChange records a change to a file made by a tool call.
+type JournalChange struct {
+	// ID numbers the changes in the journal from 1.
+	ID   int       `json:"id"`
+	Time time.Time `json:"time"`
+	// Turn is the turn of the LLM in which the change was made.
+	Turn int `json:"turn"`
+	// ToolCallID and Tool identify the tool call that made the change.
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	Tool       string `json:"tool,omitempty"`
+	// Path is the local path of the file within the directory.
+	Path string `json:"path"`
+	// Before is the content of the file before the change, or nil if it did
+	// not exist.
+	Before *JournalContent `json:"before"`
+	// After is the content of the file after the change, or nil if it was
+	// deleted.
+	After *JournalContent `json:"after"`
+	// Undoes is the ID of the change that this change undid, if any.
+	Undoes int `json:"undoes,omitempty"`
+}
+
+// A JournalContent identifies the content of a file saved in a journal.
+type JournalContent struct {
+	// Hash is the hex SHA-256 hash of the content.
+	Hash string      `json:"hash"`
+	Mode os.FileMode `json:"mode"`
+}
+
+func (c *JournalContent) String() string {
+	if c == nil {
+		return "did not exist"
+	}
+	return c.Hash
+}
+
+// sameContent returns true if a and b both identify the same content or if
+// neither file exists.
+func sameContent(a, b *JournalContent) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Hash == b.Hash
+}
+
+// A Journal records the changes made to files within a directory so that
+// they can be undone. File content is saved by its hash, so content that is
+// written more than once is only saved once.
+//
+// The journal is kept in a directory of its own, which should be outside of
+// the directory whose files it records:
+//
+//	root           the directory whose files are recorded
+//	changes.jsonl  one JournalChange per line
+//	objects/       the content of each file before and after each change
+type Journal struct {
+	logger *slog.Logger
+	dir    string
+	root   string
+
+	mu         sync.Mutex
+	changes    []JournalChange
+	turn       int
+	toolCallID string
+	tool       string
+}
+
+// NewJournal creates a Journal in dir, which is created if it does not
+// exist, recording the changes to files within root. Changes already in the
+// journal are kept.
+func NewJournal(logger *slog.Logger, dir, root string) (*Journal, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "objects"), 0700); err != nil {
+		return nil, fmt.Errorf("creating journal: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "root"), []byte(root+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("creating journal: %w", err)
+	}
+	return loadJournal(logger, dir, root)
+}
+
+// LoadJournal loads an existing Journal from dir.
+func LoadJournal(logger *slog.Logger, dir string) (*Journal, error) {
+	b, err := os.ReadFile(filepath.Join(dir, "root"))
+	if err != nil {
+		return nil, fmt.Errorf("loading journal: %w", err)
+	}
+	return loadJournal(logger, dir, string(bytes.TrimSuffix(b, []byte("\n"))))
+}
+
+func loadJournal(logger *slog.Logger, dir, root string) (*Journal, error) {
+	j := &Journal{logger: logger, dir: dir, root: root}
+	f, err := os.Open(filepath.Join(dir, "changes.jsonl"))
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading journal: %w", err)
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		var c JournalChange
+		if err := json.Unmarshal(s.Bytes(), &c); err != nil {
+			return nil, fmt.Errorf("loading journal: change %d: %w", len(j.changes)+1, err)
+		}
+		j.changes = append(j.changes, c)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("loading journal: %w", err)
+	}
+	return j, nil
+}
+
+// Root returns the directory whose files are recorded.
+func (j *Journal) Root() string {
+	return j.root
+}
+
+// StartTurn attributes the changes that follow to turn.
+func (j *Journal) StartTurn(turn int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.turn, j.toolCallID, j.tool = turn, "", ""
+}
+
+// StartToolCall attributes the changes that follow to the tool call.
+func (j *Journal) StartToolCall(id, tool string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.toolCallID, j.tool = id, tool
+}
+
+// EndTurn does nothing, as changes are recorded as they are made.
+func (j *Journal) EndTurn(turn int, text string) {}
+
+// Changes returns the changes in the journal, oldest first.
+func (j *Journal) Changes() []JournalChange {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return slices.Clone(j.changes)
+}
+
+// Undo undoes the last n changes that have not already been undone, newest
+// first, and returns the changes that undid them.
+// It errors without changing any files if a file has been changed since, for
+// example by a command.
+func (j *Journal) Undo(n int) ([]JournalChange, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var undo []JournalChange
+	for _, c := range j.undoable() {
+		if len(undo) == n {
+			break
+		}
+		undo = append(undo, c)
+	}
+	return j.undo(undo)
+}
+
+// UndoSince undoes every change made in turn or a later turn that has not
+// already been undone, newest first, and returns the changes that undid them.
+// It errors without changing any files if a file has been changed since, for
+// example by a command.
+func (j *Journal) UndoSince(turn int) ([]JournalChange, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var undo []JournalChange
+	for _, c := range j.undoable() {
+		if c.Turn >= turn {
+			undo = append(undo, c)
+		}
+	}
+	return j.undo(undo)
+}
+
+// undoable returns the changes that have not been undone and are not undos
+// themselves, newest first.
+func (j *Journal) undoable() []JournalChange {
+	undone := map[int]bool{}
+	var changes []JournalChange
+	for i := len(j.changes) - 1; i >= 0; i-- {
+		c := j.changes[i]
+		switch {
+		case c.Undoes != 0:
+			undone[c.Undoes] = true
+		case !undone[c.ID]:
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+func (j *Journal) undo(changes []JournalChange) ([]JournalChange, error) {
+	// Check that undoing each change in turn starts from the content that the
+	// change left, so that no change made outside of the journal is lost.
+	current := map[string]*JournalContent{}
+	for _, c := range changes {
+		cur, ok := current[c.Path]
+		if !ok {
+			var err error
+			if cur, err = j.snapshot(c.Path, false); err != nil {
+				return nil, err
+			}
+		}
+		if !sameContent(cur, c.After) {
+			return nil, fmt.Errorf("%q has changed since change %d so it cannot be undone", c.Path, c.ID)
+		}
+		current[c.Path] = c.Before
+	}
+
+	var undos []JournalChange
+	for _, c := range changes {
+		u, err := j.restore(c)
+		if err != nil {
+			return undos, fmt.Errorf("undoing change %d to %q: %w", c.ID, c.Path, err)
+		}
+		undos = append(undos, u)
+	}
+	return undos, nil
+}
+
+// restore returns the file changed by c to its content before c.
+func (j *Journal) restore(c JournalChange) (JournalChange, error) {
+	var err error
+	if c.Before == nil {
+		err = removeInRoot(j.root, c.Path)
+	} else {
+		var b []byte
+		if b, err = os.ReadFile(j.objectPath(c.Before.Hash)); err == nil {
+			err = restoreFileInRoot(j.root, c.Path, b, c.Before.Mode)
+		}
+	}
+	if err != nil {
+		return JournalChange{}, err
+	}
+	u, err := j.record(JournalChange{Tool: "undo", Path: c.Path, Before: c.After, After: c.Before, Undoes: c.ID})
+	if err != nil {
+		return JournalChange{}, err
+	}
+	j.logger.Info("undid change", "id", c.ID, "turn", c.Turn, "path", c.Path)
+	return u, nil
+}
+
+// A journalSnapshot is the content of a file before a change.
+type journalSnapshot struct {
+	path   string
+	before *JournalContent
+}
+
+// begin saves the content of the files at paths before they are changed.
+func (j *Journal) begin(paths ...string) ([]journalSnapshot, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var snaps []journalSnapshot
+	for _, p := range paths {
+		before, err := j.snapshot(p, true)
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, journalSnapshot{path: p, before: before})
+	}
+	return snaps, nil
+}
+
+// commit records a change for each of the files saved by begin whose content
+// has since changed.
+func (j *Journal) commit(snaps []journalSnapshot) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var errs []error
+	for _, s := range snaps {
+		after, err := j.snapshot(s.path, true)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if sameContent(s.before, after) && (after == nil || s.before.Mode == after.Mode) {
+			continue
+		}
+		c, err := j.record(JournalChange{
+			Turn:       j.turn,
+			ToolCallID: j.toolCallID,
+			Tool:       j.tool,
+			Path:       s.path,
+			Before:     s.before,
+			After:      after,
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		j.logger.Info("recorded change", "id", c.ID, "turn", c.Turn, "tool", c.Tool, "path", c.Path, "before", c.Before, "after", c.After)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("recording change: %w", err)
+	}
+	return nil
+}
+
+// snapshot returns the content of the file at path within root, which is nil
+// if the file does not exist, saving the content to the journal if save is
+// true.
+func (j *Journal) snapshot(path string, save bool) (*JournalContent, error) {
+	b, mode, err := readFileInRoot(j.root, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	c := &JournalContent{Hash: hex.EncodeToString(sum[:]), Mode: mode}
+	if save {
+		if err := j.saveObject(c.Hash, b); err != nil {
+			return nil, fmt.Errorf("saving content of %q: %w", path, err)
+		}
+	}
+	return c, nil
+}
+
+func (j *Journal) objectPath(hash string) string {
+	return filepath.Join(j.dir, "objects", hash[:2], hash[2:])
+}
+
+// saveObject saves content under its hash unless it has already been saved.
+func (j *Journal) saveObject(hash string, b []byte) error {
+	path := j.objectPath(hash)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".object-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// record appends c to the journal, numbering it and setting its time.
+func (j *Journal) record(c JournalChange) (JournalChange, error) {
+	c.ID = len(j.changes) + 1
+	c.Time = time.Now().UTC()
+	if c.Undoes != 0 {
+		c.Turn = j.turn
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return JournalChange{}, err
+	}
+	f, err := os.OpenFile(filepath.Join(j.dir, "changes.jsonl"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return JournalChange{}, err
+	}
+	_, err = f.Write(append(b, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return JournalChange{}, err
+	}
+	j.changes = append(j.changes, c)
+	return c, nil
+}
+
+// A JournalingFileWriter records the changes made to files by another
+// FileWriter and FileEditor in a Journal.
+type JournalingFileWriter struct {
+	journal *Journal
+	fw      FileWriter
+	fe      FileEditor
+}
+
+// NewJournalingFileWriter creates a JournalingFileWriter that records the
+// changes made by fw and fe to the files within the root of j.
+func NewJournalingFileWriter(j *Journal, fw FileWriter, fe FileEditor) *JournalingFileWriter {
+	return &JournalingFileWriter{journal: j, fw: fw, fe: fe}
+}
+
+// WriteFile writes the file and records the change.
+func (jw *JournalingFileWriter) WriteFile(ctx context.Context, path, content string, executable bool) error {
+	return jw.change([]string{path}, func() error {
+		return jw.fw.WriteFile(ctx, path, content, executable)
+	})
+}
+
+// EditFile edits the file and records the change.
+func (jw *JournalingFileWriter) EditFile(ctx context.Context, path, oldString, newString string) error {
+	return jw.change([]string{path}, func() error {
+		return jw.fe.EditFile(ctx, path, oldString, newString)
+	})
+}
+
+// ApplyPatch applies the patch and records the change to each file.
+func (jw *JournalingFileWriter) ApplyPatch(ctx context.Context, patch string) error {
+	fps, err := parsePatch(patch)
+	if err != nil {
+		return jw.fe.ApplyPatch(ctx, patch)
+	}
+	var paths []string
+	for _, fp := range fps {
+		p := fp.newPath
+		if p == "" {
+			p = fp.oldPath
+		}
+		if p != "" && !slices.Contains(paths, p) {
+			paths = append(paths, p)
+		}
+	}
+	return jw.change(paths, func() error {
+		return jw.fe.ApplyPatch(ctx, patch)
+	})
+}
+
+// change saves the files at paths, makes the change and records it.
+// No change is made if the files cannot be saved, so that every change can be
+// undone.
+func (jw *JournalingFileWriter) change(paths []string, f func() error) error {
+	snaps, err := jw.journal.begin(paths...)
+	if err != nil {
+		return err
+	}
+	if err := f(); err != nil {
+		// A failed change may still have changed files.
+		_ = jw.journal.commit(snaps)
+		return err
+	}
+	return jw.journal.commit(snaps)
+}
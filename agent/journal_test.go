@@ -0,0 +1,246 @@
+package agent_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/acrmp/minimalprompt/agent"
+	"github.com/acrmp/minimalprompt/agent/agentfakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Journal", func() {
+	var (
+		dir        string
+		journalDir string
+		logger     *slog.Logger
+		logOutput  *gbytes.Buffer
+		journal    *agent.Journal
+		jw         *agent.JournalingFileWriter
+	)
+
+	read := func(path string) string {
+		b, err := os.ReadFile(filepath.Join(dir, path))
+		Expect(err).ToNot(HaveOccurred())
+		return string(b)
+	}
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		journalDir = filepath.Join(GinkgoT().TempDir(), "journal")
+		logOutput = gbytes.NewBuffer()
+		logger = slog.New(slog.NewTextHandler(logOutput, nil))
+
+		var err error
+		journal, err = agent.NewJournal(logger, journalDir, dir)
+		Expect(err).ToNot(HaveOccurred())
+		fw := agent.NewSimpleFileWriter(logger, dir)
+		jw = agent.NewJournalingFileWriter(journal, fw, fw)
+	})
+
+	It("records each change with the turn and tool call that made it", func() {
+		journal.StartTurn(1)
+		journal.StartToolCall("call-1", "writeFile")
+		Expect(jw.WriteFile(context.Background(), "main.go", "package main\n", false)).To(Succeed())
+		journal.StartTurn(2)
+		journal.StartToolCall("call-2", "editFile")
+		Expect(jw.EditFile(context.Background(), "main.go", "main", "app")).To(Succeed())
+
+		changes := journal.Changes()
+		Expect(changes).To(HaveLen(2))
+		Expect(changes[0].ID).To(Equal(1))
+		Expect(changes[0].Turn).To(Equal(1))
+		Expect(changes[0].ToolCallID).To(Equal("call-1"))
+		Expect(changes[0].Tool).To(Equal("writeFile"))
+		Expect(changes[0].Path).To(Equal("main.go"))
+		Expect(changes[0].Before).To(BeNil())
+		Expect(changes[0].After.Hash).To(HaveLen(64))
+		Expect(changes[0].After.Mode).To(Equal(os.FileMode(0600)))
+
+		Expect(changes[1].Turn).To(Equal(2))
+		Expect(changes[1].ToolCallID).To(Equal("call-2"))
+		Expect(changes[1].Before).To(Equal(changes[0].After))
+		Expect(changes[1].After.Hash).ToNot(Equal(changes[0].After.Hash))
+		Expect(logOutput).To(gbytes.Say(`recorded change" id=1 turn=1 tool=writeFile path=main.go before="did not exist"`))
+	})
+
+	It("saves the content of files by their hash outside of the directory", func() {
+		Expect(jw.WriteFile(context.Background(), "a.txt", "hello\n", false)).To(Succeed())
+		Expect(jw.WriteFile(context.Background(), "b.txt", "hello\n", false)).To(Succeed())
+
+		changes := journal.Changes()
+		Expect(changes[1].After).To(Equal(changes[0].After))
+		hash := changes[0].After.Hash
+		Expect(hash).To(Equal("5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"))
+		b, err := os.ReadFile(filepath.Join(journalDir, "objects", hash[:2], hash[2:]))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(b)).To(Equal("hello\n"))
+
+		entries, err := os.ReadDir(dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(HaveLen(2))
+	})
+
+	It("appends the changes to a JSON lines file", func() {
+		Expect(jw.WriteFile(context.Background(), "a.txt", "hello\n", false)).To(Succeed())
+
+		f, err := os.Open(filepath.Join(journalDir, "changes.jsonl"))
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+		s := bufio.NewScanner(f)
+		Expect(s.Scan()).To(BeTrue())
+		var c map[string]any
+		Expect(json.Unmarshal(s.Bytes(), &c)).To(Succeed())
+		Expect(c).To(HaveKeyWithValue("path", "a.txt"))
+		Expect(c).To(HaveKeyWithValue("before", BeNil()))
+		Expect(s.Scan()).To(BeFalse())
+	})
+
+	It("does not record writes that leave the file unchanged", func() {
+		Expect(jw.WriteFile(context.Background(), "a.txt", "hello\n", false)).To(Succeed())
+		Expect(jw.WriteFile(context.Background(), "a.txt", "hello\n", false)).To(Succeed())
+		Expect(journal.Changes()).To(HaveLen(1))
+	})
+
+	It("records a change to the mode of a file", func() {
+		Expect(jw.WriteFile(context.Background(), "build.sh", "#!/bin/sh\n", false)).To(Succeed())
+		Expect(jw.WriteFile(context.Background(), "build.sh", "#!/bin/sh\n", true)).To(Succeed())
+
+		changes := journal.Changes()
+		Expect(changes).To(HaveLen(2))
+		Expect(changes[1].After.Mode).To(Equal(os.FileMode(0700)))
+	})
+
+	It("records each file changed by a patch", func() {
+		Expect(os.WriteFile(filepath.Join(dir, "old.txt"), []byte("bye\n"), 0600)).To(Succeed())
+		Expect(jw.ApplyPatch(context.Background(), "--- /dev/null\n+++ b/new.txt\n@@ -0,0 +1 @@\n+hi\n--- a/old.txt\n+++ /dev/null\n@@ -1 +0,0 @@\n-bye\n")).To(Succeed())
+
+		changes := journal.Changes()
+		Expect(changes).To(HaveLen(2))
+		Expect(changes[0].Path).To(Equal("new.txt"))
+		Expect(changes[0].Before).To(BeNil())
+		Expect(changes[1].Path).To(Equal("old.txt"))
+		Expect(changes[1].After).To(BeNil())
+	})
+
+	It("does not record failed changes", func() {
+		Expect(jw.EditFile(context.Background(), "missing.txt", "a", "b")).ToNot(Succeed())
+		Expect(jw.WriteFile(context.Background(), "../escape.txt", "a", false)).To(MatchError(ContainSubstring("not a local path")))
+		Expect(journal.Changes()).To(BeEmpty())
+	})
+
+	Context("when the content cannot be saved", func() {
+		It("does not change the file", func() {
+			Expect(os.RemoveAll(journalDir)).To(Succeed())
+			Expect(os.WriteFile(journalDir, nil, 0600)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "a.txt"), []byte("original\n"), 0600)).To(Succeed())
+
+			Expect(jw.WriteFile(context.Background(), "a.txt", "changed\n", false)).To(MatchError(ContainSubstring("saving content of \"a.txt\"")))
+			Expect(read("a.txt")).To(Equal("original\n"))
+		})
+	})
+
+	Describe("undoing changes", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(dir, "run.sh"), []byte("#!/bin/sh\n"), 0600)).To(Succeed())
+			Expect(os.Chmod(filepath.Join(dir, "run.sh"), 0644)).To(Succeed())
+
+			journal.StartTurn(1)
+			Expect(jw.WriteFile(context.Background(), "run.sh", "#!/bin/sh\necho one\n", true)).To(Succeed())
+			Expect(jw.WriteFile(context.Background(), "notes.txt", "one\n", false)).To(Succeed())
+			journal.StartTurn(2)
+			Expect(jw.WriteFile(context.Background(), "run.sh", "#!/bin/sh\necho two\n", false)).To(Succeed())
+			journal.StartTurn(3)
+			Expect(jw.WriteFile(context.Background(), "notes.txt", "three\n", false)).To(Succeed())
+		})
+
+		It("undoes the last N changes", func() {
+			undone, err := journal.Undo(2)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(undone).To(HaveLen(2))
+			Expect(undone[0].Undoes).To(Equal(4))
+			Expect(undone[1].Undoes).To(Equal(3))
+
+			Expect(read("notes.txt")).To(Equal("one\n"))
+			Expect(read("run.sh")).To(Equal("#!/bin/sh\necho one\n"))
+			Expect(logOutput).To(gbytes.Say(`undid change" id=4 turn=3 path=notes.txt`))
+		})
+
+		It("undoes the changes since a turn", func() {
+			_, err := journal.UndoSince(1)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(read("run.sh")).To(Equal("#!/bin/sh\n"))
+			Expect(filepath.Join(dir, "notes.txt")).ToNot(BeAnExistingFile())
+		})
+
+		It("restores the mode of files", func() {
+			_, err := journal.UndoSince(1)
+			Expect(err).ToNot(HaveOccurred())
+
+			fi, err := os.Stat(filepath.Join(dir, "run.sh"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fi.Mode().Perm()).To(Equal(os.FileMode(0644)))
+		})
+
+		It("records the undo so that it is not undone twice", func() {
+			_, err := journal.Undo(1)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = journal.Undo(1)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(read("notes.txt")).To(Equal("one\n"))
+			Expect(read("run.sh")).To(Equal("#!/bin/sh\necho one\n"))
+			changes := journal.Changes()
+			Expect(changes).To(HaveLen(6))
+			Expect(changes[4].Tool).To(Equal("undo"))
+			Expect(changes[4].Before.Hash).To(Equal(changes[3].After.Hash))
+		})
+
+		It("can be undone from a journal loaded from disk", func() {
+			loaded, err := agent.LoadJournal(logger, journalDir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(loaded.Root()).To(Equal(dir))
+			Expect(loaded.Changes()).To(Equal(journal.Changes()))
+
+			_, err = loaded.UndoSince(3)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(read("notes.txt")).To(Equal("one\n"))
+		})
+
+		Context("when a file has changed since", func() {
+			It("errors without undoing any change", func() {
+				Expect(os.WriteFile(filepath.Join(dir, "run.sh"), []byte("changed by a command\n"), 0600)).To(Succeed())
+
+				_, err := journal.UndoSince(2)
+				Expect(err).To(MatchError(`"run.sh" has changed since change 3 so it cannot be undone`))
+				Expect(read("notes.txt")).To(Equal("three\n"))
+				Expect(journal.Changes()).To(HaveLen(4))
+			})
+		})
+	})
+})
+
+var _ = Describe("JournalingFileWriter", func() {
+	It("passes errors through from the next writer", func() {
+		journal, err := agent.NewJournal(slog.New(slog.NewTextHandler(GinkgoWriter, nil)), GinkgoT().TempDir(), GinkgoT().TempDir())
+		Expect(err).ToNot(HaveOccurred())
+		fw := &agentfakes.FakeFileWriter{}
+		fw.WriteFileReturns(errors.New("disk full"))
+		jw := agent.NewJournalingFileWriter(journal, fw, &agentfakes.FakeFileEditor{})
+
+		Expect(jw.WriteFile(context.Background(), "a.txt", "a", true)).To(MatchError("disk full"))
+		_, path, content, executable := fw.WriteFileArgsForCall(0)
+		Expect(path).To(Equal("a.txt"))
+		Expect(content).To(Equal("a"))
+		Expect(executable).To(BeTrue())
+		Expect(journal.Changes()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrCgroupUnavailable is returned when a cgroup cannot be created because
+// cgroup v2 is not mounted or its memory and pids controllers have not been
+// delegated to the user.
+var ErrCgroupUnavailable = errors.New("cgroup v2 delegation is not available")
+
+// Limits are the resources that an executed command may use. A zero value
+// leaves the resource unlimited.
+//
+// The CPU time and file size limits are applied to each process with
+// rlimits. The memory and process limits are applied to the whole session
+// with the memory.max and pids.max of the Cgroup when one is provided, and
+// otherwise to each process with the address space and per-user process
+// rlimits.
+type Limits struct {
+	// CPUTime is the CPU time each process may use.
+	CPUTime time.Duration
+	// Memory is the number of bytes of memory that may be used.
+	Memory int64
+	// Processes is the number of processes that may run at once.
+	Processes int
+	// FileSize is the largest file in bytes that may be written.
+	FileSize int64
+	// Cgroup is the session cgroup that commands run in.
+	Cgroup *Cgroup
+}
+
+// A LimitExceededError is returned when a command is killed or fails
+// because it exceeded one of its limits.
+type LimitExceededError struct {
+	// Limit describes the limit that was exceeded.
+	Limit string
+	Err   error
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("%s exceeded: %s", e.Limit, e.Err)
+}
+
+func (e *LimitExceededError) Unwrap() error {
+	return e.Err
+}
+
+// ulimits returns the bash commands that set the rlimits, exiting if they
+// cannot be set. The soft CPU limit is a second below the hard limit so that
+// SIGXCPU is delivered before SIGKILL and the exceeded limit can be
+// recognised.
+func (l *Limits) ulimits() string {
+	if l == nil {
+		return ""
+	}
+	var b strings.Builder
+	if l.CPUTime > 0 {
+		s := max(int64(l.CPUTime/time.Second), 1)
+		fmt.Fprintf(&b, "ulimit -t %d && ulimit -S -t %d && ", s+1, s)
+	}
+	if l.FileSize > 0 {
+		fmt.Fprintf(&b, "ulimit -f %d && ", max(l.FileSize/1024, 1))
+	}
+	if l.Cgroup == nil && l.Memory > 0 {
+		fmt.Fprintf(&b, "ulimit -v %d && ", max(l.Memory/1024, 1))
+	}
+	if l.Cgroup == nil && l.Processes > 0 {
+		fmt.Fprintf(&b, "ulimit -u %d && ", l.Processes)
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(b.String(), " && ") + " || exit 126\n"
+}
+
+// A limitSnapshot records the cgroup event counters before a command runs.
+type limitSnapshot struct {
+	ooms, forks int
+}
+
+func (l *Limits) snapshot() limitSnapshot {
+	if l == nil || l.Cgroup == nil {
+		return limitSnapshot{}
+	}
+	return l.Cgroup.events()
+}
+
+// check returns a LimitExceededError if the command failed with err because
+// it exceeded a limit. The CPU time and file size limits are recognised by
+// the signal that ended the command, which a shell reports as an exit status
+// above 128 for commands run by a shell. The memory and process limits are
+// only recognised with a Cgroup, as the failures caused by their rlimits
+// cannot be told apart from others.
+func (l *Limits) check(before limitSnapshot, err error, shell bool) error {
+	if l == nil || err == nil {
+		return err
+	}
+	exceeded := func(limit string) error {
+		return &LimitExceededError{Limit: limit, Err: err}
+	}
+	sig, ok := exitSignal(err)
+	if !ok && shell {
+		sig, _ = shellSignal(err)
+	}
+	switch {
+	case l.CPUTime > 0 && sig == syscall.SIGXCPU:
+		return exceeded(fmt.Sprintf("CPU time limit of %s", l.CPUTime))
+	case l.FileSize > 0 && sig == syscall.SIGXFSZ:
+		return exceeded(fmt.Sprintf("file size limit of %s", humanSize(l.FileSize)))
+	}
+
+	if l.Cgroup != nil {
+		after := l.Cgroup.events()
+		switch {
+		case l.Memory > 0 && after.ooms > before.ooms:
+			return exceeded(fmt.Sprintf("memory limit of %s", humanSize(l.Memory)))
+		case l.Processes > 0 && after.forks > before.forks:
+			return exceeded(fmt.Sprintf("process limit of %d", l.Processes))
+		}
+	}
+	return err
+}
+
+// exitSignal returns the signal that killed a program.
+func exitSignal(err error) (syscall.Signal, bool) {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return ws.Signal(), true
+		}
+	}
+	return 0, false
+}
+
+// shellSignal returns the signal that killed the last command run by a
+// shell, as reported by the shell with an exit status above 128. A command
+// that exits with such a status itself cannot be told apart.
+func shellSignal(err error) (syscall.Signal, bool) {
+	var exitErr *exec.ExitError
+	var status exitStatusError
+	switch {
+	case errors.As(err, &exitErr):
+		if code := exitErr.ExitCode(); code > 128 {
+			return syscall.Signal(code - 128), true
+		}
+	case errors.As(err, &status):
+		if status > 128 {
+			return syscall.Signal(status - 128), true
+		}
+	}
+	return 0, false
+}
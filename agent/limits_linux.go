@@ -0,0 +1,227 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupControllers are the controllers used to limit a session cgroup.
+var cgroupControllers = []string{"memory", "pids"}
+
+// A Cgroup is a cgroup v2 group that the commands of a session run in, so
+// that memory and process limits apply to all of them together.
+type Cgroup struct {
+	dir string
+	fd  int
+	// base is the cgroup of the current process when it was moved into a
+	// leaf cgroup, so that Close can move it back.
+	base string
+	// enabled are the controllers that were enabled for the children of
+	// base, so that Close can disable them again.
+	enabled []string
+}
+
+// NewSessionCgroup creates a cgroup named name beneath the cgroup of the
+// current process with the memory.max and pids.max of limits.
+//
+// The cgroup of the current process must have been delegated to the user
+// with the memory and pids controllers. When they are already enabled for
+// its children neither the current process nor its cgroup are changed.
+// Otherwise, as a cgroup with processes cannot enable controllers for its
+// children, the current process is first moved into a leaf cgroup so that
+// the missing controllers can be enabled. Close moves it back and disables
+// only those controllers. It returns ErrCgroupUnavailable if cgroup v2
+// cannot be used.
+func NewSessionCgroup(name string, limits Limits) (*Cgroup, error) {
+	base, err := ownCgroup()
+	if err != nil {
+		return nil, err
+	}
+	controllers, err := os.ReadFile(filepath.Join(base, "cgroup.controllers"))
+	if err != nil || len(missingControllers(controllers)) > 0 {
+		return nil, ErrCgroupUnavailable
+	}
+
+	subtree, err := os.ReadFile(filepath.Join(base, "cgroup.subtree_control"))
+	if err != nil {
+		return nil, ErrCgroupUnavailable
+	}
+	cg := &Cgroup{dir: filepath.Join(base, name), fd: -1}
+	if missing := missingControllers(subtree); len(missing) > 0 {
+		// Processes may only live in leaf cgroups once controllers are
+		// enabled for the children.
+		cg.base = base
+		if err := os.MkdirAll(cg.leaf(), 0755); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCgroupUnavailable, err)
+		}
+		if err := os.WriteFile(filepath.Join(cg.leaf(), "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+			cg.restore()
+			return nil, fmt.Errorf("%w: %w", ErrCgroupUnavailable, err)
+		}
+		if err := writeControllers(base, "+", missing); err != nil {
+			cg.restore()
+			return nil, fmt.Errorf("%w: %w", ErrCgroupUnavailable, err)
+		}
+		cg.enabled = missing
+	}
+
+	if err := os.Mkdir(cg.dir, 0755); err != nil {
+		cg.restore()
+		return nil, fmt.Errorf("%w: %w", ErrCgroupUnavailable, err)
+	}
+	settings := map[string]string{}
+	if limits.Memory > 0 {
+		settings["memory.max"] = strconv.FormatInt(limits.Memory, 10)
+		settings["memory.swap.max"] = "0"
+	}
+	if limits.Processes > 0 {
+		settings["pids.max"] = strconv.Itoa(limits.Processes)
+	}
+	for f, v := range settings {
+		if err := os.WriteFile(filepath.Join(cg.dir, f), []byte(v), 0); err != nil && f != "memory.swap.max" {
+			cg.Close()
+			return nil, fmt.Errorf("setting %s: %w", f, err)
+		}
+	}
+	cg.fd, err = syscall.Open(cg.dir, syscall.O_DIRECTORY|syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		cg.Close()
+		return nil, err
+	}
+	return cg, nil
+}
+
+// Close kills every process in the cgroup and removes it. If the current
+// process was moved into a leaf cgroup it is moved back.
+func (cg *Cgroup) Close() error {
+	if cg.fd >= 0 {
+		syscall.Close(cg.fd)
+		cg.fd = -1
+	}
+	_ = os.WriteFile(filepath.Join(cg.dir, "cgroup.kill"), []byte("1"), 0)
+	var err error
+	for range 50 {
+		if err = os.Remove(cg.dir); err == nil || os.IsNotExist(err) {
+			return cg.restore()
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return err
+}
+
+// leaf returns the directory of the leaf cgroup that the current process is
+// moved into.
+func (cg *Cgroup) leaf() string {
+	return filepath.Join(cg.base, "minimalprompt")
+}
+
+// restore disables the controllers that were enabled for the children of
+// the original cgroup of the current process, moves the process back into
+// it and removes the leaf cgroup.
+func (cg *Cgroup) restore() error {
+	if cg.base == "" {
+		return nil
+	}
+	if len(cg.enabled) > 0 {
+		if err := writeControllers(cg.base, "-", cg.enabled); err != nil {
+			return err
+		}
+		cg.enabled = nil
+	}
+	if err := os.WriteFile(filepath.Join(cg.base, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), 0); err != nil {
+		return err
+	}
+	if err := os.Remove(cg.leaf()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	cg.base = ""
+	return nil
+}
+
+// events returns the number of times processes in the cgroup were killed by
+// the OOM killer or failed to fork because of pids.max.
+func (cg *Cgroup) events() limitSnapshot {
+	return limitSnapshot{
+		ooms:  cgroupEvent(filepath.Join(cg.dir, "memory.events"), "oom_kill"),
+		forks: cgroupEvent(filepath.Join(cg.dir, "pids.events"), "max"),
+	}
+}
+
+func cgroupEvent(path, key string) int {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		if k, v, ok := strings.Cut(s.Text(), " "); ok && k == key {
+			n, _ := strconv.Atoi(v)
+			return n
+		}
+	}
+	return 0
+}
+
+// ownCgroup returns the directory of the cgroup v2 group of the current
+// process.
+func ownCgroup() (string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return "", ErrCgroupUnavailable
+	}
+	b, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", ErrCgroupUnavailable
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if rel, ok := strings.CutPrefix(line, "0::"); ok {
+			return filepath.Join(cgroupRoot, rel), nil
+		}
+	}
+	return "", ErrCgroupUnavailable
+}
+
+// missingControllers returns the cgroupControllers that are not in the
+// space separated list.
+func missingControllers(list []byte) []string {
+	fields := strings.Fields(string(list))
+	var missing []string
+	for _, c := range cgroupControllers {
+		if !slices.Contains(fields, c) {
+			missing = append(missing, c)
+		}
+	}
+	return missing
+}
+
+// writeControllers enables, with op +, or disables, with op -, controllers
+// for the children of the cgroup dir.
+func writeControllers(dir, op string, controllers []string) error {
+	changes := make([]string, len(controllers))
+	for i, c := range controllers {
+		changes[i] = op + c
+	}
+	return os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte(strings.Join(changes, " ")), 0)
+}
+
+// applyLimits starts c in the session cgroup, if there is one.
+func applyLimits(c *exec.Cmd, l *Limits) {
+	if l == nil || l.Cgroup == nil || l.Cgroup.fd < 0 {
+		return
+	}
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.UseCgroupFD = true
+	c.SysProcAttr.CgroupFD = l.Cgroup.fd
+}
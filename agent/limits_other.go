@@ -0,0 +1,26 @@
+//go:build !linux
+
+package agent
+
+import "os/exec"
+
+// A Cgroup is a cgroup v2 group that the commands of a session run in. It is
+// only available on Linux.
+type Cgroup struct{}
+
+// NewSessionCgroup returns ErrCgroupUnavailable as cgroups are only
+// available on Linux.
+func NewSessionCgroup(name string, limits Limits) (*Cgroup, error) {
+	return nil, ErrCgroupUnavailable
+}
+
+// Close does nothing.
+func (cg *Cgroup) Close() error {
+	return nil
+}
+
+func (cg *Cgroup) events() limitSnapshot {
+	return limitSnapshot{}
+}
+
+func applyLimits(c *exec.Cmd, l *Limits) {}
@@ -0,0 +1,209 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/acrmp/minimalprompt/agent"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Limits", func() {
+	var (
+		dir    string
+		logger *slog.Logger
+		limits agent.Limits
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "limits")
+		Expect(err).ToNot(HaveOccurred())
+		logger = slog.New(slog.NewTextHandler(gbytes.NewBuffer(), nil))
+		limits = agent.Limits{}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	execute := func(command string) (string, error) {
+		r, err := agent.NewBashExecutor(logger, dir, agent.WithLimits(limits)).Execute(context.Background(), command)
+		return r.Output(), err
+	}
+
+	exceeded := func(limit string) OmegaMatcher {
+		return WithTransform(func(err error) string {
+			var limitErr *agent.LimitExceededError
+			if !errors.As(err, &limitErr) {
+				return ""
+			}
+			return limitErr.Limit
+		}, Equal(limit))
+	}
+
+	It("runs commands within the limits", func() {
+		limits = agent.Limits{CPUTime: time.Minute, FileSize: 1024 * 1024, Memory: 1024 * 1024 * 1024, Processes: 1024}
+		output, err := execute("echo within")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output).To(Equal("within\n"))
+	})
+
+	It("does not report a limit for commands that fail for other reasons", func() {
+		limits = agent.Limits{CPUTime: time.Minute, FileSize: 1024}
+		_, err := execute("exit 3")
+		Expect(err).To(MatchError("exit status 3"))
+		Expect(err).ToNot(exceeded("CPU time limit of 1m0s"))
+	})
+
+	Context("when a command exceeds its CPU time", func() {
+		BeforeEach(func() {
+			limits.CPUTime = time.Second
+		})
+
+		It("reports the CPU time limit", func() {
+			_, err := execute("while :; do :; done")
+			Expect(err).To(exceeded("CPU time limit of 1s"))
+			Expect(err).To(MatchError(ContainSubstring("CPU time limit of 1s exceeded")))
+		})
+
+		It("reports the limit when a child process exceeds it", func() {
+			_, err := execute("bash -c 'while :; do :; done'; echo after")
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = execute("bash -c 'while :; do :; done'")
+			Expect(err).To(exceeded("CPU time limit of 1s"))
+		})
+	})
+
+	Context("when a command writes a file larger than the limit", func() {
+		BeforeEach(func() {
+			limits.FileSize = 4096
+		})
+
+		It("reports the file size limit and stops the write", func() {
+			_, err := execute("head -c 10000 /dev/zero > large-file")
+			Expect(err).To(exceeded("file size limit of 4.0K"))
+
+			info, err := os.Stat(filepath.Join(dir, "large-file"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Size()).To(BeNumerically("<=", 4096))
+		})
+	})
+
+	Context("when a command allocates more memory than the limit", func() {
+		BeforeEach(func() {
+			limits.Memory = 64 * 1024 * 1024
+		})
+
+		It("fails without guessing the limit from the output", func() {
+			_, err := execute(`x=$(head -c 100000000 /dev/zero | tr '\0' a)`)
+			Expect(err).To(HaveOccurred())
+			Expect(err).ToNot(exceeded("memory limit of 64.0M"))
+		})
+	})
+
+	Context("when a program exits with a status above 128", func() {
+		It("does not report a limit", func() {
+			limits = agent.Limits{FileSize: 4096}
+			_, err := agent.NewBashExecutor(logger, dir, agent.WithLimits(limits)).RunProgram(context.Background(), []string{"sh", "-c", "exit 153"})
+			Expect(err).To(MatchError("exit status 153"))
+			Expect(err).ToNot(exceeded("file size limit of 4.0K"))
+		})
+	})
+
+	Context("when a program exceeds its file size", func() {
+		It("reports the file size limit", func() {
+			limits = agent.Limits{FileSize: 4096}
+			_, err := agent.NewBashExecutor(logger, dir, agent.WithLimits(limits)).RunProgram(context.Background(), []string{"dd", "if=/dev/zero", "of=large-file", "bs=10000", "count=1"})
+			Expect(err).To(exceeded("file size limit of 4.0K"))
+		})
+	})
+
+	Context("when the shell session has limits", func() {
+		It("reports the exceeded limit and keeps the session", func() {
+			session := agent.NewShellSession(logger, dir, agent.WithLimits(agent.Limits{CPUTime: time.Second}))
+			defer session.Close()
+
+			_, err := session.Execute(context.Background(), "cd /")
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = session.Execute(context.Background(), "bash -c 'while :; do :; done'")
+			Expect(err).To(exceeded("CPU time limit of 1s"))
+
+			r, err := session.Execute(context.Background(), "pwd")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Stdout).To(Equal("/\n"))
+		})
+	})
+
+	Context("when the sandbox has limits", func() {
+		It("applies them inside the sandbox", func() {
+			sandbox := agent.NewSandboxExecutor(logger, dir, agent.WithLimits(agent.Limits{FileSize: 4096}))
+			if err := sandbox.Check(); err != nil {
+				Skip(err.Error())
+			}
+
+			_, err := sandbox.Execute(context.Background(), "head -c 10000 /dev/zero > large-file")
+			Expect(err).To(exceeded("file size limit of 4.0K"))
+		})
+	})
+
+	Context("when there is a session cgroup", func() {
+		var (
+			cgroup *agent.Cgroup
+			before string
+		)
+
+		BeforeEach(func() {
+			limits = agent.Limits{Memory: 64 * 1024 * 1024, Processes: 20}
+			before = ownCgroupState()
+			var err error
+			cgroup, err = agent.NewSessionCgroup(filepath.Base(dir), limits)
+			if errors.Is(err, agent.ErrCgroupUnavailable) {
+				Skip(err.Error())
+			}
+			Expect(err).ToNot(HaveOccurred())
+			limits.Cgroup = cgroup
+		})
+
+		AfterEach(func() {
+			Expect(cgroup.Close()).To(Succeed())
+		})
+
+		It("reports the memory limit when the OOM killer is invoked", func() {
+			_, err := execute(`x=$(head -c 100000000 /dev/zero | tr '\0' a)`)
+			Expect(err).To(exceeded("memory limit of 64.0M"))
+		})
+
+		It("reports the process limit when forks fail", func() {
+			_, err := execute("for i in $(seq 1 40); do sleep 5 & done; wait")
+			Expect(err).To(exceeded("process limit of 20"))
+		})
+
+		It("leaves the cgroup of the current process as it was once closed", func() {
+			Expect(cgroup.Close()).To(Succeed())
+			Expect(ownCgroupState()).To(Equal(before))
+		})
+	})
+})
+
+// ownCgroupState returns the cgroup v2 group of the current process and the
+// controllers enabled for its children.
+func ownCgroupState() string {
+	b, _ := os.ReadFile("/proc/self/cgroup")
+	for _, line := range strings.Split(string(b), "\n") {
+		if rel, ok := strings.CutPrefix(line, "0::"); ok {
+			subtree, _ := os.ReadFile(filepath.Join("/sys/fs/cgroup", rel, "cgroup.subtree_control"))
+			return rel + ": " + string(subtree)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,139 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// defaultListDepth is the depth listed when no depth is requested.
+	defaultListDepth = 3
+	// maxListEntries is the maximum number of entries in a single listing.
+	maxListEntries = 500
+)
+
+// ListDirectory returns a tree of the directory at path, descending at most
+// depth levels. A depth of 0 uses defaultListDepth. Each line is indented by
+// its depth, directories end in a slash and files are followed by their
+// size. Paths excluded by .gitignore files and directories such as .git and
+// vendor are omitted.
+// It errors if path is not local or if there is an IO error.
+func (fr *SimpleFileReader) ListDirectory(path string, depth int) (string, error) {
+	fr.logger.Info("listing directory", "path", path, "depth", depth)
+	if path == "" {
+		path = "."
+	}
+	if depth <= 0 {
+		depth = defaultListDepth
+	}
+	d, resolved, err := openDirInRoot(fr.dir, path)
+	if errors.Is(err, unix.ENOTDIR) {
+		return "", fmt.Errorf("%q is not a directory", path)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer d.Close()
+
+	rel := filepath.ToSlash(resolved)
+	l := &lister{root: fr.dir}
+	l.ignore.loadParents(fr.dir, rel)
+	if err := l.list(d, rel, 0, depth); err != nil {
+		return "", err
+	}
+	if l.omitted > 0 {
+		fmt.Fprintf(&l.b, "[%d more entries omitted, list a subdirectory to see more]\n", l.omitted)
+	}
+	if l.b.Len() == 0 {
+		return "(empty directory)\n", nil
+	}
+	return l.b.String(), nil
+}
+
+type lister struct {
+	root    string
+	ignore  gitignore
+	b       strings.Builder
+	entries int
+	omitted int
+}
+
+// list lists the entries of the open directory d at rel.
+func (l *lister) list(d *os.File, rel string, level, depth int) error {
+	des, err := d.ReadDir(-1)
+	if err != nil {
+		return err
+	}
+	sort.Slice(des, func(i, j int) bool {
+		if des[i].IsDir() != des[j].IsDir() {
+			return des[i].IsDir()
+		}
+		return des[i].Name() < des[j].Name()
+	})
+
+	indent := strings.Repeat("  ", level)
+	for _, de := range des {
+		child := path.Join(rel, de.Name())
+		if l.ignore.ignored(child, de.IsDir()) {
+			continue
+		}
+		if l.entries == maxListEntries {
+			l.omitted++
+			continue
+		}
+		l.entries++
+
+		if !de.IsDir() {
+			size, _ := sizeAt(d, de.Name())
+			fmt.Fprintf(&l.b, "%s%s %s\n", indent, de.Name(), humanSize(size))
+			continue
+		}
+		sub, err := openDirAt(d, de.Name())
+		if err != nil {
+			return err
+		}
+		err = l.listChild(sub, de.Name(), child, level, depth)
+		sub.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listChild lists the open subdirectory sub named name at child, or only
+// counts its entries at the last level.
+func (l *lister) listChild(sub *os.File, name, child string, level, depth int) error {
+	indent := strings.Repeat("  ", level)
+	if level+1 == depth {
+		n, _ := sub.ReadDir(-1)
+		fmt.Fprintf(&l.b, "%s%s/ (%d entries)\n", indent, name, len(n))
+		return nil
+	}
+	fmt.Fprintf(&l.b, "%s%s/\n", indent, name)
+	l.ignore.load(l.root, child)
+	return l.list(sub, child, level+1, depth)
+}
+
+// humanSize formats a size in bytes compactly, for example 512B or 1.2K.
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	f := float64(n)
+	for _, u := range []string{"K", "M", "G"} {
+		f /= unit
+		if f < unit {
+			return fmt.Sprintf("%.1f%s", f, u)
+		}
+	}
+	return fmt.Sprintf("%.1fT", f/unit)
+}
@@ -0,0 +1,143 @@
+package agent_test
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/acrmp/minimalprompt/agent"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("ListDirectory", func() {
+	var (
+		dir       string
+		fr        agent.FileReader
+		logger    *slog.Logger
+		logOutput *gbytes.Buffer
+	)
+
+	writeFile := func(path, content string) {
+		p := filepath.Join(dir, path)
+		Expect(os.MkdirAll(filepath.Dir(p), 0700)).To(Succeed())
+		Expect(os.WriteFile(p, []byte(content), 0600)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+
+		logOutput = gbytes.NewBuffer()
+		logger = slog.New(slog.NewTextHandler(logOutput, nil))
+
+		dir, err = os.MkdirTemp("", "ls")
+		Expect(err).ToNot(HaveOccurred())
+		fr = agent.NewSimpleFileReader(logger, dir)
+
+		writeFile("go.mod", "module example\n")
+		writeFile("main.go", strings.Repeat("x", 2048))
+		writeFile("cmd/app/main.go", "package main\n")
+		writeFile("vendor/github.com/dep/dep.go", "package dep\n")
+		writeFile(".git/HEAD", "ref: refs/heads/main\n")
+	})
+
+	AfterEach(func() {
+		err := os.RemoveAll(dir)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("lists the directory as a tree with sizes", func() {
+		listing, err := fr.ListDirectory("", 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(listing).To(Equal("cmd/\n  app/\n    main.go 13B\ngo.mod 15B\nmain.go 2.0K\n"))
+	})
+
+	It("logs that it is listing the directory", func() {
+		_, err := fr.ListDirectory("", 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(logOutput).To(gbytes.Say(`listing directory`))
+	})
+
+	It("limits the listing to the requested depth", func() {
+		listing, err := fr.ListDirectory(".", 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(listing).To(Equal("cmd/ (1 entries)\ngo.mod 15B\nmain.go 2.0K\n"))
+	})
+
+	It("lists subdirectories", func() {
+		listing, err := fr.ListDirectory("cmd", 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(listing).To(Equal("app/\n  main.go 13B\n"))
+	})
+
+	Context("when there are .gitignore files", func() {
+		BeforeEach(func() {
+			writeFile(".gitignore", "*.log\n/build/\n!keep.log\n")
+			writeFile("debug.log", "")
+			writeFile("keep.log", "")
+			writeFile("build/out", "")
+			writeFile("cmd/build/out", "")
+			writeFile("cmd/.gitignore", "app/\n")
+		})
+
+		It("excludes the ignored paths", func() {
+			listing, err := fr.ListDirectory("", 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(listing).To(Equal("cmd/\n  build/\n    out 0B\n  .gitignore 5B\n.gitignore 24B\ngo.mod 15B\nkeep.log 0B\nmain.go 2.0K\n"))
+		})
+
+		It("applies the .gitignore files of parent directories", func() {
+			writeFile("cmd/other/debug.log", "")
+			listing, err := fr.ListDirectory("cmd", 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(listing).ToNot(ContainSubstring("debug.log"))
+			Expect(listing).ToNot(ContainSubstring("app/"))
+		})
+	})
+
+	Context("when the directory is empty", func() {
+		It("says so", func() {
+			Expect(os.Mkdir(filepath.Join(dir, "empty"), 0700)).To(Succeed())
+			listing, err := fr.ListDirectory("empty", 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(listing).To(Equal("(empty directory)\n"))
+		})
+	})
+
+	Context("when the path is a file", func() {
+		It("errors", func() {
+			_, err := fr.ListDirectory("go.mod", 0)
+			Expect(err).To(MatchError(`"go.mod" is not a directory`))
+		})
+	})
+
+	Context("when the path leads through a symlink that leaves the directory", func() {
+		It("errors", func() {
+			Expect(os.Symlink(GinkgoT().TempDir(), filepath.Join(dir, "home"))).To(Succeed())
+
+			_, err := fr.ListDirectory("home", 0)
+			Expect(err).To(MatchError(`path leads outside of the directory through a symlink: "home"`))
+		})
+	})
+
+	Context("when a .gitignore file is a symlink that leaves the directory", func() {
+		It("does not read it", func() {
+			outside := filepath.Join(GinkgoT().TempDir(), "gitignore")
+			Expect(os.WriteFile(outside, []byte("go.mod\n"), 0600)).To(Succeed())
+			Expect(os.Symlink(outside, filepath.Join(dir, ".gitignore"))).To(Succeed())
+
+			listing, err := fr.ListDirectory(".", 1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(listing).To(ContainSubstring("go.mod"))
+		})
+	})
+
+	Context("when the path is not a local path", func() {
+		It("errors", func() {
+			_, err := fr.ListDirectory("../../traversal", 0)
+			Expect(err).To(MatchError(`path is not a local path: "../../traversal"`))
+		})
+	})
+})
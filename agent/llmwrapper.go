@@ -4,9 +4,10 @@ package agent
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
 	"github.com/tmc/langchaingo/llms"
@@ -16,12 +17,38 @@ import (
 
 //counterfeiter:generate . CommandExecutor
 type CommandExecutor interface {
-	Execute(command string) (string, error)
+	Execute(ctx context.Context, command string) (CommandResult, error)
+}
+
+//counterfeiter:generate . ProgramRunner
+type ProgramRunner interface {
+	RunProgram(ctx context.Context, argv []string) (CommandResult, error)
+}
+
+//counterfeiter:generate . ProcessManager
+type ProcessManager interface {
+	Start(ctx context.Context, command string) (string, error)
+	ReadOutput(id string, lines int) (string, error)
+	Stop(id string) (string, error)
+	Close() error
 }
 
 //counterfeiter:generate . FileWriter
 type FileWriter interface {
-	WriteFile(path, content string) error
+	WriteFile(ctx context.Context, path, content string, executable bool) error
+}
+
+//counterfeiter:generate . FileEditor
+type FileEditor interface {
+	EditFile(ctx context.Context, path, oldString, newString string) error
+	ApplyPatch(ctx context.Context, patch string) error
+}
+
+//counterfeiter:generate . FileReader
+type FileReader interface {
+	ReadFile(path string, start, end int) (string, error)
+	ListDirectory(path string, depth int) (string, error)
+	SearchCode(pattern, glob string, context, maxResults int) (string, error)
 }
 
 //counterfeiter:generate . Model
@@ -31,29 +58,84 @@ type Model interface {
 
 //counterfeiter:generate . Prompter
 type Prompter interface {
-	Prompt(input string) (string, error)
+	Prompt(ctx context.Context, input string) (string, error)
+}
+
+//counterfeiter:generate . Approver
+type Approver interface {
+	Approve(ctx context.Context, r ApprovalRequest) (Approval, error)
+}
+
+//counterfeiter:generate . TurnObserver
+type TurnObserver interface {
+	StartTurn(turn int)
+	StartToolCall(id, tool string)
+	EndTurn(turn int, text string)
 }
 
 // A LLMWrapper implements a wrapper around a LLM.
 type LLMWrapper struct {
-	logger          *slog.Logger
-	persona         string
-	prompt          string
-	model           Model
-	commandExecutor CommandExecutor
-	fileWriter      FileWriter
-	prompter        Prompter
-	history         []llms.MessageContent
+	logger   *slog.Logger
+	persona  string
+	prompt   string
+	model    Model
+	tools    *ToolRegistry
+	prompter Prompter
+	history  []llms.MessageContent
+
+	maxToolFailures int
+	toolFailures    int
+
+	observers []TurnObserver
+	turn      int
+}
+
+// defaultMaxToolFailures is the number of consecutive failed tool calls after
+// which Run gives up, unless set with WithMaxToolFailures.
+const defaultMaxToolFailures = 5
+
+// A LLMWrapperOption configures a LLMWrapper.
+type LLMWrapperOption func(*LLMWrapper)
+
+// WithMaxToolFailures sets the number of consecutive failed tool calls after
+// which Run gives up. Failures before then are reported to the LLM so that it
+// can correct itself.
+func WithMaxToolFailures(n int) LLMWrapperOption {
+	return func(l *LLMWrapper) {
+		l.maxToolFailures = n
+	}
+}
+
+// WithTurnObserver tells o as the LLM starts each turn, numbered from 1 for
+// each response of the LLM, and each tool call within it. The turn ends with
+// the text of the response once its tool calls have been made, before the
+// user is prompted.
+func WithTurnObserver(o TurnObserver) LLMWrapperOption {
+	return func(l *LLMWrapper) {
+		l.observers = append(l.observers, o)
+	}
 }
 
 // NewLLMWrapper creates a LLMWrapper.
 // The persona is set as the LLM system prompt and the prompt is the initial prompt.
-func NewLLMWrapper(logger *slog.Logger, persona string, prompt string, m Model, ce CommandExecutor, fw FileWriter, p Prompter) *LLMWrapper {
-	return &LLMWrapper{logger: logger, persona: persona, prompt: prompt, model: m, commandExecutor: ce, fileWriter: fw, prompter: p}
+// The tools in the registry are made available to the LLM.
+func NewLLMWrapper(logger *slog.Logger, persona string, prompt string, m Model, tools *ToolRegistry, p Prompter, opts ...LLMWrapperOption) *LLMWrapper {
+	l := &LLMWrapper{logger: logger, persona: persona, prompt: prompt, model: m, tools: tools, prompter: p, maxToolFailures: defaultMaxToolFailures}
+	for _, o := range opts {
+		o(l)
+	}
+	return l
 }
 
-// Run executes against the LLM.
+// Run executes against the LLM until ctx is done, which also stops waiting
+// for the user to reply.
+// The tools in the registry are closed when Run returns.
 func (l *LLMWrapper) Run(ctx context.Context) error {
+	defer func() {
+		if err := l.tools.Close(); err != nil {
+			l.logger.Warn("closing tools", "err", err)
+		}
+	}()
 	l.history = []llms.MessageContent{
 		{
 			Role: llms.ChatMessageTypeSystem,
@@ -76,12 +158,15 @@ func (l *LLMWrapper) Run(ctx context.Context) error {
 			r, err := l.model.GenerateContent(
 				ctx,
 				l.history,
-				llms.WithTools([]llms.Tool{executeCommandTool, writeFileTool}),
+				llms.WithTools(l.tools.Definitions()),
 			)
 			if err != nil {
 				return err
 			}
-			if err = l.processResponse(r); err != nil {
+			if err = l.processResponse(ctx, r); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
 				return err
 			}
 			time.Sleep(5 * time.Millisecond)
@@ -89,22 +174,40 @@ func (l *LLMWrapper) Run(ctx context.Context) error {
 	}
 }
 
-func (l *LLMWrapper) processResponse(r *llms.ContentResponse) error {
+func (l *LLMWrapper) processResponse(ctx context.Context, r *llms.ContentResponse) error {
 	if len(r.Choices) == 0 {
 		return nil
 	}
+	l.turn++
+	for _, o := range l.observers {
+		o.StartTurn(l.turn)
+	}
+	ended := false
+	endTurn := func() {
+		if ended {
+			return
+		}
+		ended = true
+		text := responseText(r)
+		for _, o := range l.observers {
+			o.EndTurn(l.turn, text)
+		}
+	}
+	defer endTurn()
+
 	for _, c := range r.Choices {
 		if len(c.Content) > 0 {
-			l.logger.Info("AI says", "content", c.Content)
+			l.logger.Info("AI says", "turn", l.turn, "content", c.Content)
 		}
-		if err := l.performToolCalls(c.ToolCalls); err != nil {
+		if err := l.performToolCalls(ctx, c.ToolCalls); err != nil {
 			return err
 		}
 		if len(c.ToolCalls) > 0 {
 			break
 		}
 		if c.StopReason == "end_turn" {
-			if err := l.promptUser(c.Content); err != nil {
+			endTurn()
+			if err := l.promptUser(ctx, c.Content); err != nil {
 				return err
 			}
 		}
@@ -112,10 +215,21 @@ func (l *LLMWrapper) processResponse(r *llms.ContentResponse) error {
 	return nil
 }
 
-func (l *LLMWrapper) promptUser(q string) error {
+// responseText returns the text of the choices in r.
+func responseText(r *llms.ContentResponse) string {
+	var texts []string
+	for _, c := range r.Choices {
+		if c.Content != "" {
+			texts = append(texts, c.Content)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+func (l *LLMWrapper) promptUser(ctx context.Context, q string) error {
 	l.recordText(llms.ChatMessageTypeAI, q)
 
-	prompt, err := l.prompter.Prompt(q)
+	prompt, err := l.prompter.Prompt(ctx, q)
 	if err != nil {
 		return err
 	}
@@ -151,7 +265,12 @@ func (l *LLMWrapper) recordToolCall(tc llms.ToolCall) {
 	l.history = append(l.history, r)
 }
 
-func (l *LLMWrapper) recordToolResponse(tc llms.ToolCall, content string) {
+// recordToolResponse records the response to a tool call. The langchaingo
+// tool response has no is_error field, so errors are marked in the content.
+func (l *LLMWrapper) recordToolResponse(tc llms.ToolCall, content string, isError bool) {
+	if isError {
+		content = "Error: " + content
+	}
 	l.history = append(l.history, llms.MessageContent{
 		Role: llms.ChatMessageTypeTool,
 		Parts: []llms.ContentPart{
@@ -164,81 +283,50 @@ func (l *LLMWrapper) recordToolResponse(tc llms.ToolCall, content string) {
 	})
 }
 
-func (l *LLMWrapper) performToolCalls(calls []llms.ToolCall) error {
+func (l *LLMWrapper) performToolCalls(ctx context.Context, calls []llms.ToolCall) error {
 	for _, tc := range calls {
 		l.recordToolCall(tc)
+		for _, o := range l.observers {
+			o.StartToolCall(tc.ID, tc.FunctionCall.Name)
+		}
 
-		switch tc.FunctionCall.Name {
-		case "executeCommand":
-			var args struct {
-				Command string
-			}
-			err := json.Unmarshal([]byte(tc.FunctionCall.Arguments), &args)
-			if err != nil {
-				return fmt.Errorf("could not parse tool call arguments: %q: %w", tc.FunctionCall.Name, err)
-			}
-			prefix := "The command ran successfully with the output"
-			output, err := l.commandExecutor.Execute(args.Command)
-			if err != nil {
-				prefix = "The command failed with the output"
-			}
-			l.recordToolResponse(tc, fmt.Sprintf("%s:\n%s", prefix, output))
-		case "writeFile":
-			var args struct {
-				Path    string
-				Content string
-			}
-			err := json.Unmarshal([]byte(tc.FunctionCall.Arguments), &args)
-			if err != nil {
-				return fmt.Errorf("could not parse tool call arguments: %q: %w", tc.FunctionCall.Name, err)
-			}
-
-			if err := l.fileWriter.WriteFile(args.Path, args.Content); err != nil {
-				return fmt.Errorf("tool call failed: %q: %w", tc.FunctionCall.Name, err)
+		content, err := l.callTool(ctx, tc)
+		if err != nil {
+			l.toolFailures++
+			l.logger.Warn("tool call failed", "tool", tc.FunctionCall.Name, "err", err, "consecutive", l.toolFailures)
+			l.recordToolResponse(tc, err.Error(), true)
+			if l.toolFailures >= l.maxToolFailures {
+				return fmt.Errorf("giving up after %d consecutive tool call failures: %w", l.toolFailures, err)
 			}
-			l.recordToolResponse(tc, "ok")
-		default:
-			return fmt.Errorf("unrecognised tool call from model: %q", tc.FunctionCall.Name)
+			continue
 		}
+		l.toolFailures = 0
+		l.recordToolResponse(tc, content, false)
 	}
 	return nil
 }
 
-var executeCommandTool = llms.Tool{
-	Type: "function",
-	Function: &llms.FunctionDefinition{
-		Name:        "executeCommand",
-		Description: "Execute an operating system bash command",
-		Parameters: map[string]any{
-			"type": "object",
-			"properties": map[string]any{
-				"command": map[string]any{
-					"type":        "string",
-					"description": "The command to execute",
-				},
-			},
-			"required": []string{"command"},
-		},
-	},
-}
-var writeFileTool = llms.Tool{
-	Type: "function",
-	Function: &llms.FunctionDefinition{
-		Name:        "writeFile",
-		Description: "Write a file to the filesystem",
-		Parameters: map[string]any{
-			"type": "object",
-			"properties": map[string]any{
-				"content": map[string]any{
-					"type":        "string",
-					"description": "The content of the file as a string",
-				},
-				"path": map[string]any{
-					"type":        "string",
-					"description": "The relative path of the file within the project",
-				},
-			},
-			"required": []string{"content", "path"},
-		},
-	},
+func (l *LLMWrapper) callTool(ctx context.Context, tc llms.ToolCall) (string, error) {
+	t, ok := l.tools.Lookup(tc.FunctionCall.Name)
+	if !ok {
+		return "", fmt.Errorf("unrecognised tool call from model: %q", tc.FunctionCall.Name)
+	}
+
+	var argErr *ArgumentError
+	err := validateArguments(t.Definition(), tc.FunctionCall.Arguments)
+	switch {
+	case errors.As(err, &argErr):
+		return "", fmt.Errorf("could not parse tool call arguments: %q: %w", tc.FunctionCall.Name, argErr.Err)
+	case err != nil:
+		return "", fmt.Errorf("invalid tool call arguments: %q:\n%w", tc.FunctionCall.Name, err)
+	}
+
+	content, err := t.Call(ctx, tc.FunctionCall.Arguments)
+	switch {
+	case errors.As(err, &argErr):
+		return "", fmt.Errorf("could not parse tool call arguments: %q: %w", tc.FunctionCall.Name, argErr.Err)
+	case err != nil:
+		return "", fmt.Errorf("tool call failed: %q: %w", tc.FunctionCall.Name, err)
+	}
+	return content, nil
 }
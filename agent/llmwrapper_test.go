@@ -3,7 +3,10 @@ package agent_test
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
+	"os/exec"
+	"time"
 
 	"github.com/acrmp/minimalprompt/agent"
 	"github.com/acrmp/minimalprompt/agent/agentfakes"
@@ -21,11 +24,16 @@ var _ = Describe("LLMWrapper", func() {
 		m         *agentfakes.FakeModel
 		e         *agentfakes.FakeCommandExecutor
 		w         *agentfakes.FakeFileWriter
+		f         *agentfakes.FakeFileEditor
+		r         *agentfakes.FakeFileReader
 		p         *agentfakes.FakePrompter
+		tools     *agent.ToolRegistry
+		opts      []agent.LLMWrapperOption
 		a         *agent.LLMWrapper
 		ctx       context.Context
 		cancel    context.CancelFunc
-		errCh     chan error = make(chan error)
+		errCh     chan error
+		done      chan struct{}
 	)
 
 	BeforeEach(func() {
@@ -34,27 +42,46 @@ var _ = Describe("LLMWrapper", func() {
 
 		m = &agentfakes.FakeModel{}
 		m.GenerateContentReturns(&llms.ContentResponse{}, nil)
+		// Unless a spec sets its responses, the model waits for the run to
+		// stop rather than being called again and again.
+		m.GenerateContentStub = func(ctx context.Context, _ []llms.MessageContent, _ ...llms.CallOption) (*llms.ContentResponse, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
 
 		w = &agentfakes.FakeFileWriter{}
+		f = &agentfakes.FakeFileEditor{}
+		r = &agentfakes.FakeFileReader{}
 		e = &agentfakes.FakeCommandExecutor{}
 		p = &agentfakes.FakePrompter{}
+		tools = agent.NewToolRegistry()
+		opts = nil
 		ctx, cancel = context.WithCancel(context.Background())
 	})
 
 	JustBeforeEach(func() {
+		err := tools.Register(agent.BuiltinTools(e, w, f, r)...)
+		Expect(err).ToNot(HaveOccurred())
+
 		a = agent.NewLLMWrapper(
 			logger,
 			"You are a Software Engineer",
 			"Please develop a simple calculator",
-			m, e, w, p)
+			m, tools, p, opts...)
+		errCh = make(chan error, 1)
+		done = make(chan struct{})
 		go func() {
 			defer GinkgoRecover()
+			defer close(done)
 			errCh <- a.Run(ctx)
 		}()
 	})
 
 	AfterEach(func() {
 		cancel()
+		// Wait for the run to stop so that it does not use the fakes of
+		// the next spec.
+		Eventually(done).Should(BeClosed())
 	})
 
 	It("sets the system prompt and initial prompt for the specified persona", func() {
@@ -110,7 +137,7 @@ var _ = Describe("LLMWrapper", func() {
 			Eventually(logOutput).Should(gbytes.Say(`AI says.*What do you think?`))
 			Eventually(p.PromptCallCount).Should(Equal(1))
 
-			prompt := p.PromptArgsForCall(0)
+			_, prompt := p.PromptArgsForCall(0)
 			Expect(prompt).To(Equal("What do you think?"))
 		})
 
@@ -152,7 +179,78 @@ var _ = Describe("LLMWrapper", func() {
 				Eventually(errCh).Should(Receive(MatchError("prompt error")))
 			})
 		})
+
+		Context("when the run is cancelled while waiting for a reply", func() {
+			BeforeEach(func() {
+				p.PromptStub = func(ctx context.Context, _ string) (string, error) {
+					<-ctx.Done()
+					return "", ctx.Err()
+				}
+			})
+
+			It("stops without an error", func() {
+				Eventually(p.PromptCallCount).Should(Equal(1))
+				cancel()
+				Eventually(errCh).Should(Receive(BeNil()))
+			})
+		})
+	})
+
+	Context("with a turn observer", func() {
+		var o *agentfakes.FakeTurnObserver
+
+		BeforeEach(func() {
+			o = &agentfakes.FakeTurnObserver{}
+			opts = append(opts, agent.WithTurnObserver(o))
+			m.GenerateContentReturnsOnCall(0, &llms.ContentResponse{
+				Choices: []*llms.ContentChoice{{
+					ToolCalls: []llms.ToolCall{
+						{ID: "call-1", Type: "function", FunctionCall: &llms.FunctionCall{Name: "writeFile", Arguments: `{"path": "a.txt", "content": "a"}`}},
+						{ID: "call-2", Type: "function", FunctionCall: &llms.FunctionCall{Name: "writeFile", Arguments: `{"path": "b.txt", "content": "b"}`}},
+					},
+				}},
+			}, nil)
+			m.GenerateContentReturnsOnCall(1, &llms.ContentResponse{
+				Choices: []*llms.ContentChoice{{Content: "Done", StopReason: "end_turn"}},
+			}, nil)
+			p.PromptReturns("Thanks", nil)
+			o.EndTurnCalls(func(int, string) {
+				defer GinkgoRecover()
+				Expect(p.PromptCallCount()).To(BeZero())
+			})
+		})
+
+		It("tells it about each turn and tool call", func() {
+			Eventually(p.PromptCallCount).Should(Equal(1))
+			Expect(o.StartTurnCallCount()).To(Equal(2))
+			Expect(o.StartTurnArgsForCall(0)).To(Equal(1))
+			Expect(o.StartTurnArgsForCall(1)).To(Equal(2))
+
+			Expect(o.StartToolCallCallCount()).To(Equal(2))
+			id, tool := o.StartToolCallArgsForCall(0)
+			Expect(id).To(Equal("call-1"))
+			Expect(tool).To(Equal("writeFile"))
+			id, _ = o.StartToolCallArgsForCall(1)
+			Expect(id).To(Equal("call-2"))
+			Expect(w.WriteFileCallCount()).To(Equal(2))
+		})
+
+		It("ends each turn with the text of the model before prompting the user", func() {
+			Eventually(p.PromptCallCount).Should(Equal(1))
+			Expect(o.EndTurnCallCount()).To(Equal(2))
+			turn, text := o.EndTurnArgsForCall(0)
+			Expect(turn).To(Equal(1))
+			Expect(text).To(BeEmpty())
+			turn, text = o.EndTurnArgsForCall(1)
+			Expect(turn).To(Equal(2))
+			Expect(text).To(Equal("Done"))
+		})
+
+		It("logs the turn with the text of the model", func() {
+			Eventually(logOutput).Should(gbytes.Say(`AI says" turn=2 content=Done`))
+		})
 	})
+
 	Describe("writing files", func() {
 		It("advertises a tool to write to the filesystem", func() {
 			Eventually(m.GenerateContentCallCount).Should(Equal(1))
@@ -173,19 +271,22 @@ var _ = Describe("LLMWrapper", func() {
 			Expect(tool.Function.Name).To(Equal("writeFile"))
 			Expect(tool.Function.Description).To(Equal("Write a file to the filesystem"))
 
-			params := tool.Function.Parameters.(map[string]any)
-			Expect(params["type"]).To(Equal("object"))
-			props := params["properties"].(map[string]any)
+			params := tool.Function.Parameters.(*agent.Schema)
+			Expect(params.Type).To(Equal("object"))
+			props := params.Properties
 
 			Expect(props).To(HaveKey("content"))
-			Expect(props["content"]).To(HaveKeyWithValue("type", "string"))
-			Expect(props["content"]).To(HaveKeyWithValue("description", "The content of the file as a string"))
+			Expect(props["content"].Type).To(Equal("string"))
+			Expect(props["content"].Description).To(Equal("The content of the file as a string"))
 
 			Expect(props).To(HaveKey("path"))
-			Expect(props["path"]).To(HaveKeyWithValue("type", "string"))
-			Expect(props["path"]).To(HaveKeyWithValue("description", "The relative path of the file within the project"))
+			Expect(props["path"].Type).To(Equal("string"))
+			Expect(props["path"].Description).To(Equal("The relative path of the file within the project"))
 
-			Expect(params["required"]).To(ConsistOf([]string{"content", "path"}))
+			Expect(props).To(HaveKey("executable"))
+			Expect(props["executable"].Type).To(Equal("boolean"))
+
+			Expect(params.Required).To(ConsistOf([]string{"content", "path"}))
 		})
 
 		Context("when the model invokes the tool", func() {
@@ -218,9 +319,10 @@ var _ = Describe("LLMWrapper", func() {
 				Expect(msgs[1].Role).To(Equal(llms.ChatMessageTypeHuman))
 
 				Eventually(w.WriteFileCallCount).Should(Equal(1))
-				path, content := w.WriteFileArgsForCall(0)
+				_, path, content, executable := w.WriteFileArgsForCall(0)
 				Expect(path).To(Equal("/path/to/some/file"))
 				Expect(content).To(Equal("content for the file"))
+				Expect(executable).To(BeFalse())
 			})
 
 			It("does not log empty text output", func() {
@@ -269,6 +371,78 @@ var _ = Describe("LLMWrapper", func() {
 			})
 		})
 
+		Context("when the model asks for an executable file", func() {
+			BeforeEach(func() {
+				m.GenerateContentReturnsOnCall(0,
+					&llms.ContentResponse{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{
+										ID:   "abc123",
+										Type: "function",
+										FunctionCall: &llms.FunctionCall{
+											Name:      "writeFile",
+											Arguments: `{"path":"build.sh","content":"#!/bin/sh\ngo build ./...\n","executable":true}`,
+										},
+									},
+								},
+							},
+						},
+					},
+					nil,
+				)
+			})
+
+			It("writes an executable file", func() {
+				Eventually(w.WriteFileCallCount).Should(Equal(1))
+				_, path, _, executable := w.WriteFileArgsForCall(0)
+				Expect(path).To(Equal("build.sh"))
+				Expect(executable).To(BeTrue())
+			})
+		})
+
+		Context("when the user denies the write", func() {
+			BeforeEach(func() {
+				m.GenerateContentReturnsOnCall(0,
+					&llms.ContentResponse{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{
+										ID:   "abc123",
+										Type: "function",
+										FunctionCall: &llms.FunctionCall{
+											Name:      "writeFile",
+											Arguments: `{"path":"main.go","content":"package main"}`,
+										},
+									},
+								},
+							},
+						},
+					},
+					nil,
+				)
+				w.WriteFileReturns(&agent.ApprovalDeniedError{Feedback: "Add a main function"})
+			})
+
+			It("shares the feedback with the model", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[3].Parts).To(Equal(
+					[]llms.ContentPart{
+						llms.ToolCallResponse{
+							ToolCallID: "abc123",
+							Name:       "writeFile",
+							Content:    "The user denied writing the file and it was not written, the feedback from the user was:\nAdd a main function",
+						},
+					},
+				))
+			})
+		})
+
 		Context("when the model returns multiple choices and not all invoke the tool", func() {
 			BeforeEach(func() {
 				m.GenerateContentReturnsOnCall(0,
@@ -297,7 +471,7 @@ var _ = Describe("LLMWrapper", func() {
 			It("chooses the choice that invokes the tool and writes to the filesystem", func() {
 				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 1))
 				Eventually(w.WriteFileCallCount).Should(Equal(1))
-				path, content := w.WriteFileArgsForCall(0)
+				_, path, content, _ := w.WriteFileArgsForCall(0)
 				Expect(path).To(Equal("/path/to/some/file"))
 				Expect(content).To(Equal("content for the file"))
 			})
@@ -339,7 +513,7 @@ var _ = Describe("LLMWrapper", func() {
 			It("chooses the first choice that invokes the tool and writes to the filesystem", func() {
 				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 1))
 				Eventually(w.WriteFileCallCount).Should(Equal(1))
-				path, content := w.WriteFileArgsForCall(0)
+				_, path, content, _ := w.WriteFileArgsForCall(0)
 				Expect(path).To(Equal("/path/to/some/file"))
 				Expect(content).To(Equal("content for the file"))
 			})
@@ -366,8 +540,35 @@ var _ = Describe("LLMWrapper", func() {
 				)
 			})
 
-			It("errors", func() {
-				Eventually(errCh).Should(Receive(MatchError(`unrecognised tool call from model: "accelerate"`)))
+			It("shares the error with the model", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[3].Parts).To(Equal(
+					[]llms.ContentPart{
+						llms.ToolCallResponse{
+							Name:    "accelerate",
+							Content: `Error: unrecognised tool call from model: "accelerate"`,
+						},
+					},
+				))
+			})
+
+			It("errors after repeated failures", func() {
+				Eventually(errCh).Should(Receive(MatchError(`giving up after 5 consecutive tool call failures: unrecognised tool call from model: "accelerate"`)))
+				Expect(m.GenerateContentCallCount()).To(Equal(5))
+			})
+
+			Context("when the maximum number of failures is configured", func() {
+				BeforeEach(func() {
+					opts = append(opts, agent.WithMaxToolFailures(2))
+				})
+
+				It("errors after that many failures", func() {
+					Eventually(errCh).Should(Receive(MatchError(MatchRegexp(`^giving up after 2 consecutive tool call failures`))))
+					Expect(m.GenerateContentCallCount()).To(Equal(2))
+				})
 			})
 		})
 
@@ -392,8 +593,59 @@ var _ = Describe("LLMWrapper", func() {
 					nil,
 				)
 			})
-			It("errors", func() {
-				Eventually(errCh).Should(Receive(MatchError(MatchRegexp(`could not parse tool call arguments: "writeFile":.*JSON`))))
+			It("shares the error with the model", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[3].Role).To(Equal(llms.ChatMessageTypeTool))
+				Expect(msgs[3].Parts).To(HaveLen(1))
+				Expect(msgs[3].Parts[0].(llms.ToolCallResponse).Content).To(MatchRegexp(`^Error: could not parse tool call arguments: "writeFile":.*JSON`))
+			})
+		})
+
+		Context("when the model tool arguments are invalid", func() {
+			BeforeEach(func() {
+				m.GenerateContentReturnsOnCall(0,
+					&llms.ContentResponse{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{
+										ID:   "abc123",
+										Type: "function",
+										FunctionCall: &llms.FunctionCall{
+											Name:      "writeFile",
+											Arguments: `{"content":7}`,
+										},
+									},
+								},
+							},
+						},
+					},
+					nil,
+				)
+			})
+
+			It("does not write to the filesystem", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+				Expect(w.WriteFileCallCount()).To(Equal(0))
+			})
+
+			It("shares the validation errors with the model", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[3].Parts).To(Equal(
+					[]llms.ContentPart{
+						llms.ToolCallResponse{
+							ToolCallID: "abc123",
+							Name:       "writeFile",
+							Content:    "Error: invalid tool call arguments: \"writeFile\":\nmissing required property \"path\"\nproperty \"content\": expected string, got number",
+						},
+					},
+				))
 			})
 		})
 
@@ -419,15 +671,21 @@ var _ = Describe("LLMWrapper", func() {
 				)
 				w.WriteFileReturns(errors.New("some-error"))
 			})
-			It("errors", func() {
-				Eventually(errCh).Should(Receive(MatchError(MatchRegexp(`tool call failed: "writeFile": some-error`))))
+			It("shares the error with the model", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[3].Role).To(Equal(llms.ChatMessageTypeTool))
+				Expect(msgs[3].Parts).To(HaveLen(1))
+				Expect(msgs[3].Parts[0].(llms.ToolCallResponse).Content).To(MatchRegexp(`^Error: tool call failed: "writeFile": some-error$`))
 			})
 		})
 	})
 
-	Describe("executing commands", func() {
-		It("advertises a tool to execute commands", func() {
-			Eventually(m.GenerateContentCallCount).Should(Equal(1))
+	Describe("editing files", func() {
+		It("advertises a tool to edit files", func() {
+			Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 1))
 			_, _, opts := m.GenerateContentArgsForCall(0)
 			co := &llms.CallOptions{}
 			for _, o := range opts {
@@ -436,24 +694,27 @@ var _ = Describe("LLMWrapper", func() {
 
 			var tool llms.Tool
 			for _, t := range co.Tools {
-				if t.Type == "function" && t.Function.Name == "executeCommand" {
+				if t.Type == "function" && t.Function.Name == "editFile" {
 					tool = t
 					break
 				}
 			}
 			Expect(tool.Type).To(Equal("function"))
-			Expect(tool.Function.Name).To(Equal("executeCommand"))
-			Expect(tool.Function.Description).To(Equal("Execute an operating system bash command"))
+			Expect(tool.Function.Name).To(Equal("editFile"))
+			Expect(tool.Function.Description).To(ContainSubstring("Edit a file by replacing an exact string"))
 
-			params := tool.Function.Parameters.(map[string]any)
-			Expect(params["type"]).To(Equal("object"))
-			props := params["properties"].(map[string]any)
+			params := tool.Function.Parameters.(*agent.Schema)
+			Expect(params.Type).To(Equal("object"))
+			props := params.Properties
 
-			Expect(props).To(HaveKey("command"))
-			Expect(props["command"]).To(HaveKeyWithValue("type", "string"))
-			Expect(props["command"]).To(HaveKeyWithValue("description", "The command to execute"))
+			Expect(props).To(HaveKey("path"))
+			Expect(props["path"].Type).To(Equal("string"))
+			Expect(props).To(HaveKey("old_string"))
+			Expect(props["old_string"].Type).To(Equal("string"))
+			Expect(props).To(HaveKey("new_string"))
+			Expect(props["new_string"].Type).To(Equal("string"))
 
-			Expect(params["required"]).To(ConsistOf([]string{"command"}))
+			Expect(params.Required).To(ConsistOf([]string{"path", "old_string", "new_string"}))
 		})
 
 		Context("when the model invokes the tool", func() {
@@ -467,8 +728,8 @@ var _ = Describe("LLMWrapper", func() {
 										ID:   "abc123",
 										Type: "function",
 										FunctionCall: &llms.FunctionCall{
-											Name:      "executeCommand",
-											Arguments: `{"command":"whoami"}`,
+											Name:      "editFile",
+											Arguments: `{"path":"main.go","old_string":"red","new_string":"yellow"}`,
 										},
 									},
 								},
@@ -477,64 +738,35 @@ var _ = Describe("LLMWrapper", func() {
 					},
 					nil,
 				)
-				e.ExecuteReturns("engineer", nil)
 			})
 
-			It("executes the command", func() {
-				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 1))
-				ctx, msgs, _ := m.GenerateContentArgsForCall(0)
-				Expect(ctx).To(Equal(ctx))
-				Expect(msgs[0].Role).To(Equal(llms.ChatMessageTypeSystem))
-
-				Eventually(e.ExecuteCallCount).Should(Equal(1))
-				command := e.ExecuteArgsForCall(0)
-				Expect(command).To(Equal("whoami"))
+			It("edits the file", func() {
+				Eventually(f.EditFileCallCount).Should(Equal(1))
+				_, path, oldString, newString := f.EditFileArgsForCall(0)
+				Expect(path).To(Equal("main.go"))
+				Expect(oldString).To(Equal("red"))
+				Expect(newString).To(Equal("yellow"))
 			})
 
-			It("shares the command output with the model", func() {
+			It("tells the model the edit succeeded", func() {
 				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
 
-				ctx, msgs, _ := m.GenerateContentArgsForCall(0)
-				Expect(ctx).To(Equal(ctx))
-				Expect(msgs).To(HaveLen(2))
-				Expect(msgs[0].Role).To(Equal(llms.ChatMessageTypeSystem))
-				Expect(msgs[1].Role).To(Equal(llms.ChatMessageTypeHuman))
-
-				ctx, msgs, _ = m.GenerateContentArgsForCall(1)
-				Expect(ctx).To(Equal(ctx))
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
 				Expect(msgs).To(HaveLen(4))
-				Expect(msgs[0].Role).To(Equal(llms.ChatMessageTypeSystem))
-				Expect(msgs[1].Role).To(Equal(llms.ChatMessageTypeHuman))
-
-				Expect(msgs[2].Role).To(Equal(llms.ChatMessageTypeAI))
-				Expect(msgs[2].Parts).To(Equal(
-					[]llms.ContentPart{
-						llms.ToolCall{
-							ID:   "abc123",
-							Type: "function",
-							FunctionCall: &llms.FunctionCall{
-								Name:      "executeCommand",
-								Arguments: `{"command":"whoami"}`,
-							},
-						},
-					},
-				))
-
 				Expect(msgs[3].Role).To(Equal(llms.ChatMessageTypeTool))
 				Expect(msgs[3].Parts).To(Equal(
 					[]llms.ContentPart{
 						llms.ToolCallResponse{
 							ToolCallID: "abc123",
-							Name:       "executeCommand",
-							Content:    "The command ran successfully with the output:\nengineer",
+							Name:       "editFile",
+							Content:    "ok",
 						},
 					},
 				))
 			})
-
 		})
 
-		Context("when the model tool arguments cannot be parsed", func() {
+		Context("when the edit fails", func() {
 			BeforeEach(func() {
 				m.GenerateContentReturnsOnCall(0,
 					&llms.ContentResponse{
@@ -542,10 +774,11 @@ var _ = Describe("LLMWrapper", func() {
 							{
 								ToolCalls: []llms.ToolCall{
 									{
+										ID:   "abc123",
 										Type: "function",
 										FunctionCall: &llms.FunctionCall{
-											Name:      "executeCommand",
-											Arguments: `{"not json"`,
+											Name:      "editFile",
+											Arguments: `{"path":"main.go","old_string":"red","new_string":"yellow"}`,
 										},
 									},
 								},
@@ -554,25 +787,38 @@ var _ = Describe("LLMWrapper", func() {
 					},
 					nil,
 				)
+				f.EditFileReturns(errors.New(`old_string was not found in "main.go"`))
 			})
-			It("errors", func() {
-				Eventually(errCh).Should(Receive(MatchError(MatchRegexp(`could not parse tool call arguments: "executeCommand":.*JSON`))))
+
+			It("shares the error with the model", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[3].Parts).To(Equal(
+					[]llms.ContentPart{
+						llms.ToolCallResponse{
+							ToolCallID: "abc123",
+							Name:       "editFile",
+							Content:    `Error: tool call failed: "editFile": old_string was not found in "main.go"`,
+						},
+					},
+				))
 			})
 		})
 
-		Context("when there is an error executing the command", func() {
+		Context("when the model tool arguments cannot be parsed", func() {
 			BeforeEach(func() {
-				m.GenerateContentReturns(
+				m.GenerateContentReturnsOnCall(0,
 					&llms.ContentResponse{
 						Choices: []*llms.ContentChoice{
 							{
 								ToolCalls: []llms.ToolCall{
 									{
-										ID:   "abc123",
 										Type: "function",
 										FunctionCall: &llms.FunctionCall{
-											Name:      "executeCommand",
-											Arguments: `{"command":"whoami"}`,
+											Name:      "editFile",
+											Arguments: `{"not json"`,
 										},
 									},
 								},
@@ -581,51 +827,1012 @@ var _ = Describe("LLMWrapper", func() {
 					},
 					nil,
 				)
-				e.ExecuteReturns("user unknown", errors.New("some-error"))
 			})
-
-			It("shares the command output with the model", func() {
+			It("shares the error with the model", func() {
 				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
 
-				ctx, msgs, _ := m.GenerateContentArgsForCall(0)
-				Expect(ctx).To(Equal(ctx))
-				Expect(msgs).To(HaveLen(2))
-				Expect(msgs[0].Role).To(Equal(llms.ChatMessageTypeSystem))
-				Expect(msgs[1].Role).To(Equal(llms.ChatMessageTypeHuman))
-
-				ctx, msgs, _ = m.GenerateContentArgsForCall(1)
-				Expect(ctx).To(Equal(ctx))
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
 				Expect(msgs).To(HaveLen(4))
-				Expect(msgs[0].Role).To(Equal(llms.ChatMessageTypeSystem))
-				Expect(msgs[1].Role).To(Equal(llms.ChatMessageTypeHuman))
-
-				Expect(msgs[2].Role).To(Equal(llms.ChatMessageTypeAI))
-				Expect(msgs[2].Parts).To(Equal(
-					[]llms.ContentPart{
-						llms.ToolCall{
-							ID:   "abc123",
-							Type: "function",
-							FunctionCall: &llms.FunctionCall{
-								Name:      "executeCommand",
-								Arguments: `{"command":"whoami"}`,
-							},
-						},
-					},
-				))
-
 				Expect(msgs[3].Role).To(Equal(llms.ChatMessageTypeTool))
-				Expect(msgs[3].Parts).To(Equal(
-					[]llms.ContentPart{
-						llms.ToolCallResponse{
-							ToolCallID: "abc123",
+				Expect(msgs[3].Parts).To(HaveLen(1))
+				Expect(msgs[3].Parts[0].(llms.ToolCallResponse).Content).To(MatchRegexp(`^Error: could not parse tool call arguments: "editFile":.*JSON`))
+			})
+		})
+	})
+
+	Describe("applying patches", func() {
+		It("advertises a tool to apply patches", func() {
+			Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 1))
+			_, _, opts := m.GenerateContentArgsForCall(0)
+			co := &llms.CallOptions{}
+			for _, o := range opts {
+				o(co)
+			}
+
+			var tool llms.Tool
+			for _, t := range co.Tools {
+				if t.Type == "function" && t.Function.Name == "applyPatch" {
+					tool = t
+					break
+				}
+			}
+			Expect(tool.Type).To(Equal("function"))
+			Expect(tool.Function.Name).To(Equal("applyPatch"))
+			Expect(tool.Function.Description).To(ContainSubstring("Apply a unified diff"))
+
+			params := tool.Function.Parameters.(*agent.Schema)
+			Expect(params.Type).To(Equal("object"))
+			props := params.Properties
+
+			Expect(props).To(HaveKey("patch"))
+			Expect(props["patch"].Type).To(Equal("string"))
+
+			Expect(params.Required).To(ConsistOf([]string{"patch"}))
+		})
+
+		Context("when the model invokes the tool", func() {
+			BeforeEach(func() {
+				m.GenerateContentReturnsOnCall(0,
+					&llms.ContentResponse{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{
+										ID:   "abc123",
+										Type: "function",
+										FunctionCall: &llms.FunctionCall{
+											Name:      "applyPatch",
+											Arguments: `{"patch":"--- a/f\n+++ b/f\n@@ -1 +1 @@\n-red\n+yellow\n"}`,
+										},
+									},
+								},
+							},
+						},
+					},
+					nil,
+				)
+			})
+
+			It("applies the patch", func() {
+				Eventually(f.ApplyPatchCallCount).Should(Equal(1))
+				_, patch := f.ApplyPatchArgsForCall(0)
+				Expect(patch).To(Equal("--- a/f\n+++ b/f\n@@ -1 +1 @@\n-red\n+yellow\n"))
+			})
+
+			It("tells the model the patch applied", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[3].Parts).To(Equal(
+					[]llms.ContentPart{
+						llms.ToolCallResponse{
+							ToolCallID: "abc123",
+							Name:       "applyPatch",
+							Content:    "ok",
+						},
+					},
+				))
+			})
+		})
+
+		Context("when hunks fail to apply", func() {
+			BeforeEach(func() {
+				m.GenerateContentReturnsOnCall(0,
+					&llms.ContentResponse{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{
+										ID:   "abc123",
+										Type: "function",
+										FunctionCall: &llms.FunctionCall{
+											Name:      "applyPatch",
+											Arguments: `{"patch":"--- a/f\n+++ b/f\n@@ -1 +1 @@\n-red\n+yellow\n"}`,
+										},
+									},
+								},
+							},
+						},
+					},
+					nil,
+				)
+				f.ApplyPatchReturns(errors.New("patch was not applied, 1 hunks failed"))
+			})
+
+			It("shares the failure with the model", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[3].Parts).To(Equal(
+					[]llms.ContentPart{
+						llms.ToolCallResponse{
+							ToolCallID: "abc123",
+							Name:       "applyPatch",
+							Content:    `Error: tool call failed: "applyPatch": patch was not applied, 1 hunks failed`,
+						},
+					},
+				))
+			})
+		})
+	})
+
+	Describe("reading files", func() {
+		It("advertises a tool to read from the filesystem", func() {
+			Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 1))
+			_, _, opts := m.GenerateContentArgsForCall(0)
+			co := &llms.CallOptions{}
+			for _, o := range opts {
+				o(co)
+			}
+
+			var tool llms.Tool
+			for _, t := range co.Tools {
+				if t.Type == "function" && t.Function.Name == "readFile" {
+					tool = t
+					break
+				}
+			}
+			Expect(tool.Type).To(Equal("function"))
+			Expect(tool.Function.Name).To(Equal("readFile"))
+			Expect(tool.Function.Description).To(ContainSubstring("Read a file from the filesystem"))
+
+			params := tool.Function.Parameters.(*agent.Schema)
+			Expect(params.Type).To(Equal("object"))
+			props := params.Properties
+
+			Expect(props).To(HaveKey("path"))
+			Expect(props["path"].Type).To(Equal("string"))
+			Expect(props).To(HaveKey("start"))
+			Expect(props["start"].Type).To(Equal("integer"))
+			Expect(props).To(HaveKey("end"))
+			Expect(props["end"].Type).To(Equal("integer"))
+
+			Expect(params.Required).To(ConsistOf([]string{"path"}))
+		})
+
+		Context("when the model invokes the tool", func() {
+			BeforeEach(func() {
+				m.GenerateContentReturnsOnCall(0,
+					&llms.ContentResponse{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{
+										ID:   "abc123",
+										Type: "function",
+										FunctionCall: &llms.FunctionCall{
+											Name:      "readFile",
+											Arguments: `{"path":"main.go","start":3,"end":4}`,
+										},
+									},
+								},
+							},
+						},
+					},
+					nil,
+				)
+				r.ReadFileReturns("     3\tfunc main() {\n     4\t}\n", nil)
+			})
+
+			It("reads from the filesystem", func() {
+				Eventually(r.ReadFileCallCount).Should(Equal(1))
+				path, start, end := r.ReadFileArgsForCall(0)
+				Expect(path).To(Equal("main.go"))
+				Expect(start).To(Equal(3))
+				Expect(end).To(Equal(4))
+			})
+
+			It("shares the file content with the model", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[3].Role).To(Equal(llms.ChatMessageTypeTool))
+				Expect(msgs[3].Parts).To(Equal(
+					[]llms.ContentPart{
+						llms.ToolCallResponse{
+							ToolCallID: "abc123",
+							Name:       "readFile",
+							Content:    "     3\tfunc main() {\n     4\t}\n",
+						},
+					},
+				))
+			})
+		})
+
+		Context("when the file cannot be read", func() {
+			BeforeEach(func() {
+				m.GenerateContentReturnsOnCall(0,
+					&llms.ContentResponse{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{
+										ID:   "abc123",
+										Type: "function",
+										FunctionCall: &llms.FunctionCall{
+											Name:      "readFile",
+											Arguments: `{"path":"missing.go"}`,
+										},
+									},
+								},
+							},
+						},
+					},
+					nil,
+				)
+				r.ReadFileReturns("", errors.New("some-error"))
+			})
+
+			It("shares the error with the model", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[3].Parts).To(Equal(
+					[]llms.ContentPart{
+						llms.ToolCallResponse{
+							ToolCallID: "abc123",
+							Name:       "readFile",
+							Content:    `Error: tool call failed: "readFile": some-error`,
+						},
+					},
+				))
+			})
+		})
+
+		Context("when the model tool arguments cannot be parsed", func() {
+			BeforeEach(func() {
+				m.GenerateContentReturnsOnCall(0,
+					&llms.ContentResponse{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{
+										Type: "function",
+										FunctionCall: &llms.FunctionCall{
+											Name:      "readFile",
+											Arguments: `{"not json"`,
+										},
+									},
+								},
+							},
+						},
+					},
+					nil,
+				)
+			})
+			It("shares the error with the model", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[3].Role).To(Equal(llms.ChatMessageTypeTool))
+				Expect(msgs[3].Parts).To(HaveLen(1))
+				Expect(msgs[3].Parts[0].(llms.ToolCallResponse).Content).To(MatchRegexp(`^Error: could not parse tool call arguments: "readFile":.*JSON`))
+			})
+		})
+	})
+
+	Describe("listing directories", func() {
+		It("advertises a tool to list directories", func() {
+			Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 1))
+			_, _, opts := m.GenerateContentArgsForCall(0)
+			co := &llms.CallOptions{}
+			for _, o := range opts {
+				o(co)
+			}
+
+			var tool llms.Tool
+			for _, t := range co.Tools {
+				if t.Type == "function" && t.Function.Name == "listDirectory" {
+					tool = t
+					break
+				}
+			}
+			Expect(tool.Type).To(Equal("function"))
+			Expect(tool.Function.Name).To(Equal("listDirectory"))
+
+			params := tool.Function.Parameters.(*agent.Schema)
+			props := params.Properties
+			Expect(props["path"].Type).To(Equal("string"))
+			Expect(props["depth"].Type).To(Equal("integer"))
+		})
+
+		Context("when the model invokes the tool", func() {
+			BeforeEach(func() {
+				m.GenerateContentReturnsOnCall(0,
+					&llms.ContentResponse{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{
+										ID:   "abc123",
+										Type: "function",
+										FunctionCall: &llms.FunctionCall{
+											Name:      "listDirectory",
+											Arguments: `{"path":"cmd","depth":2}`,
+										},
+									},
+								},
+							},
+						},
+					},
+					nil,
+				)
+				r.ListDirectoryReturns("main.go 13B\n", nil)
+			})
+
+			It("shares the listing with the model", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				path, depth := r.ListDirectoryArgsForCall(0)
+				Expect(path).To(Equal("cmd"))
+				Expect(depth).To(Equal(2))
+
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[3].Parts).To(Equal(
+					[]llms.ContentPart{
+						llms.ToolCallResponse{
+							ToolCallID: "abc123",
+							Name:       "listDirectory",
+							Content:    "main.go 13B\n",
+						},
+					},
+				))
+			})
+		})
+	})
+
+	Describe("searching code", func() {
+		It("advertises a tool to search code", func() {
+			Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 1))
+			_, _, opts := m.GenerateContentArgsForCall(0)
+			co := &llms.CallOptions{}
+			for _, o := range opts {
+				o(co)
+			}
+
+			var tool llms.Tool
+			for _, t := range co.Tools {
+				if t.Type == "function" && t.Function.Name == "searchCode" {
+					tool = t
+					break
+				}
+			}
+			Expect(tool.Type).To(Equal("function"))
+			Expect(tool.Function.Name).To(Equal("searchCode"))
+
+			params := tool.Function.Parameters.(*agent.Schema)
+			props := params.Properties
+			Expect(props["pattern"].Type).To(Equal("string"))
+			Expect(props["glob"].Type).To(Equal("string"))
+			Expect(props["context"].Type).To(Equal("integer"))
+			Expect(props["max_results"].Type).To(Equal("integer"))
+			Expect(params.Required).To(ConsistOf([]string{"pattern"}))
+		})
+
+		Context("when the model invokes the tool", func() {
+			BeforeEach(func() {
+				m.GenerateContentReturnsOnCall(0,
+					&llms.ContentResponse{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{
+										ID:   "abc123",
+										Type: "function",
+										FunctionCall: &llms.FunctionCall{
+											Name:      "searchCode",
+											Arguments: `{"pattern":"func main","glob":"*.go","context":2,"max_results":10}`,
+										},
+									},
+								},
+							},
+						},
+					},
+					nil,
+				)
+				r.SearchCodeReturns("main.go:3:func main() {\n", nil)
+			})
+
+			It("shares the results with the model", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				pattern, glob, contextLines, maxResults := r.SearchCodeArgsForCall(0)
+				Expect(pattern).To(Equal("func main"))
+				Expect(glob).To(Equal("*.go"))
+				Expect(contextLines).To(Equal(2))
+				Expect(maxResults).To(Equal(10))
+
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[3].Parts).To(Equal(
+					[]llms.ContentPart{
+						llms.ToolCallResponse{
+							ToolCallID: "abc123",
+							Name:       "searchCode",
+							Content:    "main.go:3:func main() {\n",
+						},
+					},
+				))
+			})
+		})
+	})
+
+	Describe("executing commands", func() {
+		It("advertises a tool to execute commands", func() {
+			Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 1))
+			_, _, opts := m.GenerateContentArgsForCall(0)
+			co := &llms.CallOptions{}
+			for _, o := range opts {
+				o(co)
+			}
+
+			var tool llms.Tool
+			for _, t := range co.Tools {
+				if t.Type == "function" && t.Function.Name == "executeCommand" {
+					tool = t
+					break
+				}
+			}
+			Expect(tool.Type).To(Equal("function"))
+			Expect(tool.Function.Name).To(Equal("executeCommand"))
+			Expect(tool.Function.Description).To(Equal("Execute an operating system shell command"))
+
+			params := tool.Function.Parameters.(*agent.Schema)
+			Expect(params.Type).To(Equal("object"))
+			props := params.Properties
+
+			Expect(props).To(HaveKey("command"))
+			Expect(props["command"].Type).To(Equal("string"))
+			Expect(props["command"].Description).To(Equal("The command to execute"))
+
+			Expect(props).To(HaveKey("timeout"))
+			Expect(props["timeout"].Type).To(Equal("integer"))
+
+			Expect(params.Required).To(ConsistOf([]string{"command"}))
+		})
+
+		Context("when the model invokes the tool", func() {
+			BeforeEach(func() {
+				m.GenerateContentReturnsOnCall(0,
+					&llms.ContentResponse{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{
+										ID:   "abc123",
+										Type: "function",
+										FunctionCall: &llms.FunctionCall{
+											Name:      "executeCommand",
+											Arguments: `{"command":"whoami"}`,
+										},
+									},
+								},
+							},
+						},
+					},
+					nil,
+				)
+				e.ExecuteReturns(agent.CommandResult{Duration: 1234567 * time.Microsecond, Stdout: "engineer"}, nil)
+			})
+
+			It("executes the command", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 1))
+				ctx, msgs, _ := m.GenerateContentArgsForCall(0)
+				Expect(ctx).To(Equal(ctx))
+				Expect(msgs[0].Role).To(Equal(llms.ChatMessageTypeSystem))
+
+				Eventually(e.ExecuteCallCount).Should(Equal(1))
+				_, command := e.ExecuteArgsForCall(0)
+				Expect(command).To(Equal("whoami"))
+			})
+
+			It("shares the command output with the model", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				ctx, msgs, _ := m.GenerateContentArgsForCall(0)
+				Expect(ctx).To(Equal(ctx))
+				Expect(msgs).To(HaveLen(2))
+				Expect(msgs[0].Role).To(Equal(llms.ChatMessageTypeSystem))
+				Expect(msgs[1].Role).To(Equal(llms.ChatMessageTypeHuman))
+
+				ctx, msgs, _ = m.GenerateContentArgsForCall(1)
+				Expect(ctx).To(Equal(ctx))
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[0].Role).To(Equal(llms.ChatMessageTypeSystem))
+				Expect(msgs[1].Role).To(Equal(llms.ChatMessageTypeHuman))
+
+				Expect(msgs[2].Role).To(Equal(llms.ChatMessageTypeAI))
+				Expect(msgs[2].Parts).To(Equal(
+					[]llms.ContentPart{
+						llms.ToolCall{
+							ID:   "abc123",
+							Type: "function",
+							FunctionCall: &llms.FunctionCall{
+								Name:      "executeCommand",
+								Arguments: `{"command":"whoami"}`,
+							},
+						},
+					},
+				))
+
+				Expect(msgs[3].Role).To(Equal(llms.ChatMessageTypeTool))
+				Expect(msgs[3].Parts).To(Equal(
+					[]llms.ContentPart{
+						llms.ToolCallResponse{
+							ToolCallID: "abc123",
+							Name:       "executeCommand",
+							Content:    "status: succeeded\nexit_code: 0\nduration: 1.235s\n<stdout>\nengineer\n</stdout>\n<stderr>\n</stderr>\n",
+						},
+					},
+				))
+			})
+
+		})
+
+		Context("when the model tool arguments cannot be parsed", func() {
+			BeforeEach(func() {
+				m.GenerateContentReturnsOnCall(0,
+					&llms.ContentResponse{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{
+										Type: "function",
+										FunctionCall: &llms.FunctionCall{
+											Name:      "executeCommand",
+											Arguments: `{"not json"`,
+										},
+									},
+								},
+							},
+						},
+					},
+					nil,
+				)
+			})
+			It("shares the error with the model", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[3].Role).To(Equal(llms.ChatMessageTypeTool))
+				Expect(msgs[3].Parts).To(HaveLen(1))
+				Expect(msgs[3].Parts[0].(llms.ToolCallResponse).Content).To(MatchRegexp(`^Error: could not parse tool call arguments: "executeCommand":.*JSON`))
+			})
+		})
+
+		Context("when there is an error executing the command", func() {
+			BeforeEach(func() {
+				m.GenerateContentReturns(
+					&llms.ContentResponse{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{
+										ID:   "abc123",
+										Type: "function",
+										FunctionCall: &llms.FunctionCall{
+											Name:      "executeCommand",
+											Arguments: `{"command":"whoami"}`,
+										},
+									},
+								},
+							},
+						},
+					},
+					nil,
+				)
+				e.ExecuteReturns(agent.CommandResult{ExitCode: 1, Duration: 20 * time.Millisecond, Stderr: "user unknown\n"}, errors.New("exit status 1"))
+			})
+
+			It("shares the command output with the model", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				ctx, msgs, _ := m.GenerateContentArgsForCall(0)
+				Expect(ctx).To(Equal(ctx))
+				Expect(msgs).To(HaveLen(2))
+				Expect(msgs[0].Role).To(Equal(llms.ChatMessageTypeSystem))
+				Expect(msgs[1].Role).To(Equal(llms.ChatMessageTypeHuman))
+
+				ctx, msgs, _ = m.GenerateContentArgsForCall(1)
+				Expect(ctx).To(Equal(ctx))
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[0].Role).To(Equal(llms.ChatMessageTypeSystem))
+				Expect(msgs[1].Role).To(Equal(llms.ChatMessageTypeHuman))
+
+				Expect(msgs[2].Role).To(Equal(llms.ChatMessageTypeAI))
+				Expect(msgs[2].Parts).To(Equal(
+					[]llms.ContentPart{
+						llms.ToolCall{
+							ID:   "abc123",
+							Type: "function",
+							FunctionCall: &llms.FunctionCall{
+								Name:      "executeCommand",
+								Arguments: `{"command":"whoami"}`,
+							},
+						},
+					},
+				))
+
+				Expect(msgs[3].Role).To(Equal(llms.ChatMessageTypeTool))
+				Expect(msgs[3].Parts).To(Equal(
+					[]llms.ContentPart{
+						llms.ToolCallResponse{
+							ToolCallID: "abc123",
+							Name:       "executeCommand",
+							Content:    "status: failed\nexit_code: 1\nduration: 20ms\n<stdout>\n</stdout>\n<stderr>\nuser unknown\n</stderr>\n",
+						},
+					},
+				))
+			})
+		})
+
+		Context("when the model requests a timeout", func() {
+			BeforeEach(func() {
+				m.GenerateContentReturnsOnCall(0,
+					&llms.ContentResponse{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{
+										ID:   "abc123",
+										Type: "function",
+										FunctionCall: &llms.FunctionCall{
+											Name:      "executeCommand",
+											Arguments: `{"command":"go test ./...","timeout":30}`,
+										},
+									},
+								},
+							},
+						},
+					},
+					nil,
+				)
+			})
+
+			It("executes the command with a deadline", func() {
+				Eventually(e.ExecuteCallCount).Should(Equal(1))
+				ctx, _ := e.ExecuteArgsForCall(0)
+				deadline, ok := ctx.Deadline()
+				Expect(ok).To(BeTrue())
+				Expect(time.Until(deadline)).To(BeNumerically("~", 30*time.Second, 5*time.Second))
+			})
+		})
+
+		Context("when the command exceeds a limit", func() {
+			BeforeEach(func() {
+				m.GenerateContentReturnsOnCall(0,
+					&llms.ContentResponse{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{
+										ID:   "abc123",
+										Type: "function",
+										FunctionCall: &llms.FunctionCall{
+											Name:      "executeCommand",
+											Arguments: `{"command":"./fork-bomb"}`,
+										},
+									},
+								},
+							},
+						},
+					},
+					nil,
+				)
+				e.ExecuteReturns(agent.CommandResult{ExitCode: 1, Duration: time.Second, Stdout: "forking\n"}, &agent.LimitExceededError{Limit: "process limit of 512", Err: errors.New("exit status 1")})
+			})
+
+			It("tells the model which limit was exceeded", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[3].Parts).To(Equal(
+					[]llms.ContentPart{
+						llms.ToolCallResponse{
+							ToolCallID: "abc123",
+							Name:       "executeCommand",
+							Content:    "status: exceeded its process limit of 512\nexit_code: 1\nduration: 1s\n<stdout>\nforking\n</stdout>\n<stderr>\n</stderr>\n",
+						},
+					},
+				))
+			})
+		})
+
+		Context("when the command times out", func() {
+			BeforeEach(func() {
+				m.GenerateContentReturnsOnCall(0,
+					&llms.ContentResponse{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{
+										ID:   "abc123",
+										Type: "function",
+										FunctionCall: &llms.FunctionCall{
+											Name:      "executeCommand",
+											Arguments: `{"command":"go run ./server"}`,
+										},
+									},
+								},
+							},
+						},
+					},
+					nil,
+				)
+				e.ExecuteReturns(agent.CommandResult{ExitCode: -1, Signal: "SIGKILL", Duration: 2 * time.Minute, Stdout: "listening on :8080\n"}, fmt.Errorf("%w: signal: killed", agent.ErrCommandTimedOut))
+			})
+
+			It("tells the model the command timed out with the partial output", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[3].Parts).To(Equal(
+					[]llms.ContentPart{
+						llms.ToolCallResponse{
+							ToolCallID: "abc123",
+							Name:       "executeCommand",
+							Content:    "status: timed out\nexit_code: -1\nsignal: SIGKILL\nduration: 2m0s\n<stdout>\nlistening on :8080\n</stdout>\n<stderr>\n</stderr>\n",
+						},
+					},
+				))
+			})
+		})
+
+		Context("when the command is denied by the policy", func() {
+			BeforeEach(func() {
+				m.GenerateContentReturnsOnCall(0,
+					&llms.ContentResponse{
+						Choices: []*llms.ContentChoice{
+							{
+								ToolCalls: []llms.ToolCall{
+									{
+										ID:   "abc123",
+										Type: "function",
+										FunctionCall: &llms.FunctionCall{
+											Name:      "executeCommand",
+											Arguments: `{"command":"git push origin"}`,
+										},
+									},
+								},
+							},
+						},
+					},
+					nil,
+				)
+				e.ExecuteReturns(agent.CommandResult{}, &agent.PolicyDeniedError{Command: "git push origin", Rule: "git push", Reason: `is denied by rule "git push"`})
+			})
+
+			It("tells the model why the command was not run", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[3].Parts).To(Equal(
+					[]llms.ContentPart{
+						llms.ToolCallResponse{
+							ToolCallID: "abc123",
 							Name:       "executeCommand",
-							Content:    "The command failed with the output:\nuser unknown",
+							Content:    `The command was denied by the command policy and was not run: "git push origin" is denied by rule "git push"`,
 						},
 					},
 				))
 			})
 		})
 	})
+	Describe("registered tools", func() {
+		var ticketLookup *agentfakes.FakeTool
+
+		BeforeEach(func() {
+			ticketLookup = &agentfakes.FakeTool{}
+			ticketLookup.DefinitionReturns(llms.FunctionDefinition{
+				Name:        "lookupTicket",
+				Description: "Look up a ticket in the tracker",
+			})
+			ticketLookup.CallReturns("Make it yellow", nil)
+			Expect(tools.Register(ticketLookup)).To(Succeed())
+
+			m.GenerateContentReturnsOnCall(0,
+				&llms.ContentResponse{
+					Choices: []*llms.ContentChoice{
+						{
+							ToolCalls: []llms.ToolCall{
+								{
+									ID:   "abc123",
+									Type: "function",
+									FunctionCall: &llms.FunctionCall{
+										Name:      "lookupTicket",
+										Arguments: `{"id":"T-1"}`,
+									},
+								},
+							},
+						},
+					},
+				},
+				nil,
+			)
+		})
+
+		It("advertises the tool", func() {
+			Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 1))
+			_, _, opts := m.GenerateContentArgsForCall(0)
+			co := &llms.CallOptions{}
+			for _, o := range opts {
+				o(co)
+			}
+
+			var names []string
+			for _, t := range co.Tools {
+				names = append(names, t.Function.Name)
+			}
+			Expect(names).To(ContainElements("lookupTicket", "executeCommand", "writeFile"))
+		})
+
+		It("calls the tool and shares the result with the model", func() {
+			Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+			Expect(ticketLookup.CallCallCount()).To(Equal(1))
+			_, arguments := ticketLookup.CallArgsForCall(0)
+			Expect(arguments).To(Equal(`{"id":"T-1"}`))
+
+			_, msgs, _ := m.GenerateContentArgsForCall(1)
+			Expect(msgs).To(HaveLen(4))
+			Expect(msgs[3].Parts).To(Equal(
+				[]llms.ContentPart{
+					llms.ToolCallResponse{
+						ToolCallID: "abc123",
+						Name:       "lookupTicket",
+						Content:    "Make it yellow",
+					},
+				},
+			))
+		})
+	})
+
+	Describe("background processes", func() {
+		var pm *agentfakes.FakeProcessManager
+
+		BeforeEach(func() {
+			pm = &agentfakes.FakeProcessManager{}
+			pm.StartReturns("p1", nil)
+			Expect(tools.Register(agent.ProcessTools(pm)...)).To(Succeed())
+
+			m.GenerateContentReturnsOnCall(0,
+				&llms.ContentResponse{
+					Choices: []*llms.ContentChoice{
+						{
+							ToolCalls: []llms.ToolCall{
+								{
+									ID:   "abc123",
+									Type: "function",
+									FunctionCall: &llms.FunctionCall{
+										Name:      "startProcess",
+										Arguments: `{"command":"go run ./server"}`,
+									},
+								},
+							},
+						},
+					},
+				},
+				nil,
+			)
+		})
+
+		It("starts the process and shares its id with the model", func() {
+			Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+			Expect(pm.StartCallCount()).To(Equal(1))
+			_, command := pm.StartArgsForCall(0)
+			Expect(command).To(Equal("go run ./server"))
+
+			_, msgs, _ := m.GenerateContentArgsForCall(1)
+			Expect(msgs).To(HaveLen(4))
+			Expect(msgs[3].Parts).To(Equal(
+				[]llms.ContentPart{
+					llms.ToolCallResponse{
+						ToolCallID: "abc123",
+						Name:       "startProcess",
+						Content:    "Started process p1, read its output with readProcessOutput and stop it with stopProcess",
+					},
+				},
+			))
+		})
+
+		It("stops the processes when the run ends", func() {
+			Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+			Expect(pm.CloseCallCount()).To(Equal(0))
+
+			cancel()
+			Eventually(pm.CloseCallCount).Should(Equal(1))
+		})
+	})
+
+	Describe("running programs", func() {
+		var pr *agentfakes.FakeProgramRunner
+
+		BeforeEach(func() {
+			pr = &agentfakes.FakeProgramRunner{}
+			pr.RunProgramReturns(agent.CommandResult{Duration: time.Second, Stdout: "ok\n"}, nil)
+			Expect(tools.Register(agent.NewRunProgramTool(pr))).To(Succeed())
+
+			m.GenerateContentReturnsOnCall(0,
+				&llms.ContentResponse{
+					Choices: []*llms.ContentChoice{
+						{
+							ToolCalls: []llms.ToolCall{
+								{
+									ID:   "abc123",
+									Type: "function",
+									FunctionCall: &llms.FunctionCall{
+										Name:      "runProgram",
+										Arguments: `{"argv":["go","test","-run","Test Calc$"]}`,
+									},
+								},
+							},
+						},
+					},
+				},
+				nil,
+			)
+		})
+
+		It("runs the program with the arguments and shares the result with the model", func() {
+			Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+			Expect(pr.RunProgramCallCount()).To(Equal(1))
+			_, argv := pr.RunProgramArgsForCall(0)
+			Expect(argv).To(Equal([]string{"go", "test", "-run", "Test Calc$"}))
+
+			_, msgs, _ := m.GenerateContentArgsForCall(1)
+			Expect(msgs).To(HaveLen(4))
+			Expect(msgs[3].Parts).To(Equal(
+				[]llms.ContentPart{
+					llms.ToolCallResponse{
+						ToolCallID: "abc123",
+						Name:       "runProgram",
+						Content:    "status: succeeded\nexit_code: 0\nduration: 1s\n<stdout>\nok\n</stdout>\n<stderr>\n</stderr>\n",
+					},
+				},
+			))
+		})
+
+		Context("when the program cannot be found", func() {
+			BeforeEach(func() {
+				pr.RunProgramReturns(agent.CommandResult{ExitCode: -1}, &exec.Error{Name: "go", Err: exec.ErrNotFound})
+			})
+
+			It("shares the error with the model", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[3].Parts[0].(llms.ToolCallResponse).Content).To(Equal(`Error: tool call failed: "runProgram": exec: "go": executable file not found in $PATH`))
+			})
+		})
+
+		Context("when the program is denied by the policy", func() {
+			BeforeEach(func() {
+				pr.RunProgramReturns(agent.CommandResult{}, &agent.PolicyDeniedError{Command: "go test -run 'Test Calc$'", Reason: "is not allowed by any rule"})
+			})
+
+			It("tells the model why the program was not run", func() {
+				Eventually(m.GenerateContentCallCount).Should(BeNumerically(">=", 2))
+
+				_, msgs, _ := m.GenerateContentArgsForCall(1)
+				Expect(msgs).To(HaveLen(4))
+				Expect(msgs[3].Parts[0].(llms.ToolCallResponse).Content).To(Equal(`The program was denied by the command policy and was not run: "go test -run 'Test Calc$'" is not allowed by any rule`))
+			})
+		})
+	})
+
 	Context("when there is an error talking to the model", func() {
 		BeforeEach(func() {
 			m.GenerateContentReturns(nil, errors.New("some error"))
@@ -0,0 +1,287 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A filePatch is the set of changes to a single file in a unified diff.
+type filePatch struct {
+	oldPath string
+	newPath string
+	hunks   []hunk
+}
+
+// A hunk is a single @@ section of a unified diff.
+type hunk struct {
+	header   string
+	oldStart int
+	lines    []string
+	oldNoEOL bool
+	newNoEOL bool
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,\d+)? \+\d+(?:,\d+)? @@`)
+
+// parsePatch parses a unified diff into per-file patches.
+// Content before the first file header, such as git extended headers, is
+// ignored.
+func parsePatch(patch string) ([]filePatch, error) {
+	lines := strings.Split(strings.ReplaceAll(patch, "\r\n", "\n"), "\n")
+	var (
+		fps []filePatch
+		fp  *filePatch
+		h   *hunk
+	)
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			fps = append(fps, filePatch{
+				oldPath: patchPath(line[4:]),
+				newPath: patchPath(lines[i+1][4:]),
+			})
+			fp, h = &fps[len(fps)-1], nil
+			i++
+		case strings.HasPrefix(line, "@@"):
+			if fp == nil {
+				return nil, fmt.Errorf("hunk %q appears before a file header", line)
+			}
+			fp.hunks = append(fp.hunks, hunk{header: line, oldStart: -1})
+			h = &fp.hunks[len(fp.hunks)-1]
+			if m := hunkHeader.FindStringSubmatch(line); m != nil {
+				h.oldStart, _ = strconv.Atoi(m[1])
+			}
+		case h == nil:
+			// Preamble such as "diff --git" or "index" lines.
+		case strings.HasPrefix(line, `\`):
+			if len(h.lines) > 0 {
+				switch h.lines[len(h.lines)-1][0] {
+				case '-':
+					h.oldNoEOL = true
+				case '+':
+					h.newNoEOL = true
+				default:
+					h.oldNoEOL, h.newNoEOL = true, true
+				}
+			}
+		case line == "":
+			// Editors and models often strip the space from empty context lines.
+			h.lines = append(h.lines, " ")
+		case line[0] == ' ' || line[0] == '-' || line[0] == '+':
+			h.lines = append(h.lines, line)
+		default:
+			h = nil
+		}
+	}
+
+	for i := range fps {
+		for j := range fps[i].hunks {
+			h := &fps[i].hunks[j]
+			for len(h.lines) > 0 && h.lines[len(h.lines)-1] == " " {
+				h.lines = h.lines[:len(h.lines)-1]
+			}
+		}
+	}
+	if len(fps) == 0 {
+		return nil, errors.New("no file headers found, expected a unified diff with --- and +++ lines")
+	}
+	return fps, nil
+}
+
+// patchPath strips timestamps and the a/ or b/ prefix from a diff file header.
+func patchPath(p string) string {
+	if i := strings.Index(p, "\t"); i >= 0 {
+		p = p[:i]
+	}
+	p = strings.TrimSpace(p)
+	if p == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(p, "a/") || strings.HasPrefix(p, "b/") {
+		p = p[2:]
+	}
+	return p
+}
+
+// A patchedFile holds the in-memory state of a file while a patch is applied.
+type patchedFile struct {
+	path    string
+	existed bool
+	deleted bool
+	lines   []string
+	eol     bool
+	mode    os.FileMode
+	orig    []byte
+}
+
+// ApplyPatch applies the unified diff patch to files within the directory.
+// Every hunk must match the existing file content, although hunks may be
+// found at an offset from the line numbers in their header. Either all hunks
+// are applied or none are.
+// It errors if a path is not local, if any hunk fails to apply or if there is
+// an IO error.
+func (fw *SimpleFileWriter) ApplyPatch(ctx context.Context, patch string) error {
+	fw.logger.Info("applying patch")
+	fps, err := parsePatch(patch)
+	if err != nil {
+		return err
+	}
+
+	files := map[string]*patchedFile{}
+	var order []string
+	var failures []string
+	for _, fp := range fps {
+		p := fp.newPath
+		if p == "" {
+			p = fp.oldPath
+		}
+		if p == "" {
+			return errors.New("file header names neither an old nor a new file")
+		}
+		pf, ok := files[p]
+		if !ok {
+			if pf, err = fw.loadPatchedFile(p, fp.oldPath == ""); err != nil {
+				return err
+			}
+			files[p] = pf
+			order = append(order, p)
+		}
+
+		for i, h := range fp.hunks {
+			if err := pf.apply(h); err != nil {
+				failures = append(failures, fmt.Sprintf("hunk %d (%s) of %q: %s", i+1, h.header, p, err))
+			}
+		}
+		if fp.newPath == "" {
+			if len(pf.lines) > 0 {
+				failures = append(failures, fmt.Sprintf("%q: file is not empty after removing lines so cannot be deleted", p))
+			}
+			pf.deleted = true
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("patch was not applied, %d hunks failed:\n%s", len(failures), strings.Join(failures, "\n"))
+	}
+
+	for i, p := range order {
+		if err := fw.writePatchedFile(files[p]); err != nil {
+			for _, q := range order[:i+1] {
+				fw.restorePatchedFile(files[q])
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (fw *SimpleFileWriter) loadPatchedFile(path string, create bool) (*patchedFile, error) {
+	pf := &patchedFile{path: path, eol: true, mode: 0600}
+	b, mode, err := readFileInRoot(fw.dir, path)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && create:
+		return pf, nil
+	case err != nil:
+		return nil, err
+	case create:
+		return nil, fmt.Errorf("%q already exists but the patch creates it", path)
+	}
+	pf.existed, pf.orig, pf.mode = true, b, mode
+	content := string(b)
+	if content == "" {
+		return pf, nil
+	}
+	pf.eol = strings.HasSuffix(content, "\n")
+	pf.lines = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	return pf, nil
+}
+
+// apply applies h to the file, searching outwards from the line number in
+// the hunk header for a location where the hunk context matches.
+func (pf *patchedFile) apply(h hunk) error {
+	var old, replacement []string
+	for _, l := range h.lines {
+		switch l[0] {
+		case ' ':
+			old = append(old, l[1:])
+			replacement = append(replacement, l[1:])
+		case '-':
+			old = append(old, l[1:])
+		case '+':
+			replacement = append(replacement, l[1:])
+		}
+	}
+
+	want := h.oldStart - 1
+	if len(old) == 0 {
+		// A pure insertion, such as into a new file, has no context to match.
+		want = h.oldStart
+	}
+	at := -1
+	for d := 0; at < 0 && (want-d >= 0 || want+d <= len(pf.lines)); d++ {
+		for _, c := range []int{want - d, want + d} {
+			if c >= 0 && pf.matches(old, c) {
+				at = c
+				break
+			}
+		}
+	}
+	if at < 0 {
+		return errors.New("the context and removed lines do not match the file")
+	}
+
+	lines := make([]string, 0, len(pf.lines)-len(old)+len(replacement))
+	lines = append(lines, pf.lines[:at]...)
+	lines = append(lines, replacement...)
+	lines = append(lines, pf.lines[at+len(old):]...)
+	if at+len(old) == len(pf.lines) {
+		switch {
+		case h.newNoEOL:
+			pf.eol = false
+		case h.oldNoEOL:
+			pf.eol = true
+		}
+	}
+	pf.lines = lines
+	return nil
+}
+
+func (pf *patchedFile) matches(old []string, at int) bool {
+	if at+len(old) > len(pf.lines) {
+		return false
+	}
+	for i, l := range old {
+		if pf.lines[at+i] != l {
+			return false
+		}
+	}
+	return true
+}
+
+func (fw *SimpleFileWriter) writePatchedFile(pf *patchedFile) error {
+	if pf.deleted {
+		return removeInRoot(fw.dir, pf.path)
+	}
+	content := strings.Join(pf.lines, "\n")
+	if len(pf.lines) > 0 && pf.eol {
+		content += "\n"
+	}
+	return writeFileInRoot(fw.dir, pf.path, []byte(content), pf.mode, false)
+}
+
+func (fw *SimpleFileWriter) restorePatchedFile(pf *patchedFile) {
+	var err error
+	if pf.existed {
+		err = writeFileInRoot(fw.dir, pf.path, pf.orig, pf.mode, false)
+	} else {
+		err = removeInRoot(fw.dir, pf.path)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fw.logger.Error("restoring file after failed patch", "path", pf.path, "err", err)
+	}
+}
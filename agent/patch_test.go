@@ -0,0 +1,177 @@
+package agent_test
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/acrmp/minimalprompt/agent"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("ApplyPatch", func() {
+	var (
+		dir       string
+		fe        agent.FileEditor
+		logger    *slog.Logger
+		logOutput *gbytes.Buffer
+	)
+
+	readFile := func(path string) string {
+		b, err := os.ReadFile(filepath.Join(dir, path))
+		Expect(err).ToNot(HaveOccurred())
+		return string(b)
+	}
+
+	BeforeEach(func() {
+		var err error
+
+		logOutput = gbytes.NewBuffer()
+		logger = slog.New(slog.NewTextHandler(logOutput, nil))
+
+		dir, err = os.MkdirTemp("", "patch")
+		Expect(err).ToNot(HaveOccurred())
+		fe = agent.NewSimpleFileWriter(logger, dir)
+
+		err = os.WriteFile(filepath.Join(dir, "fruit.txt"), []byte("apple\nbanana\ncherry\ndate\nelderberry\nfig\ngrape\n"), 0600)
+		Expect(err).ToNot(HaveOccurred())
+		err = os.WriteFile(filepath.Join(dir, "veg.txt"), []byte("carrot\nleek\nonion\n"), 0755)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := os.RemoveAll(dir)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("applies hunks across multiple files", func() {
+		err := fe.ApplyPatch(context.Background(), `diff --git a/fruit.txt b/fruit.txt
+index 1234567..89abcde 100644
+--- a/fruit.txt
++++ b/fruit.txt
+@@ -1,3 +1,3 @@
+ apple
+-banana
++blueberry
+ cherry
+@@ -5,3 +5,4 @@
+ elderberry
+ fig
++guava
+ grape
+--- a/veg.txt
++++ b/veg.txt
+@@ -1,3 +1,2 @@
+ carrot
+-leek
+ onion
+`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(readFile("fruit.txt")).To(Equal("apple\nblueberry\ncherry\ndate\nelderberry\nfig\nguava\ngrape\n"))
+		Expect(readFile("veg.txt")).To(Equal("carrot\nonion\n"))
+	})
+
+	It("preserves the file mode", func() {
+		err := fe.ApplyPatch(context.Background(), "--- a/veg.txt\n+++ b/veg.txt\n@@ -2 +2 @@\n-leek\n+potato\n")
+		Expect(err).ToNot(HaveOccurred())
+
+		fi, err := os.Stat(filepath.Join(dir, "veg.txt"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fi.Mode().Perm()).To(Equal(os.FileMode(0755)))
+	})
+
+	It("logs that it is applying the patch", func() {
+		err := fe.ApplyPatch(context.Background(), "--- a/veg.txt\n+++ b/veg.txt\n@@ -2 +2 @@\n-leek\n+potato\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(logOutput).To(gbytes.Say(`applying patch`))
+	})
+
+	It("finds hunks whose line numbers are offset", func() {
+		err := fe.ApplyPatch(context.Background(), "--- a/fruit.txt\n+++ b/fruit.txt\n@@ -10,2 +10,2 @@\n date\n-elderberry\n+eggplant\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(readFile("fruit.txt")).To(Equal("apple\nbanana\ncherry\ndate\neggplant\nfig\ngrape\n"))
+	})
+
+	It("creates new files", func() {
+		err := fe.ApplyPatch(context.Background(), "--- /dev/null\n+++ b/nuts/list.txt\n@@ -0,0 +1,2 @@\n+almond\n+brazil\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(readFile("nuts/list.txt")).To(Equal("almond\nbrazil\n"))
+	})
+
+	It("deletes files", func() {
+		err := fe.ApplyPatch(context.Background(), "--- a/veg.txt\n+++ /dev/null\n@@ -1,3 +0,0 @@\n-carrot\n-leek\n-onion\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(filepath.Join(dir, "veg.txt")).ToNot(BeAnExistingFile())
+	})
+
+	It("respects missing newlines at the end of the file", func() {
+		err := fe.ApplyPatch(context.Background(), "--- a/veg.txt\n+++ b/veg.txt\n@@ -3 +3 @@\n-onion\n+shallot\n\\ No newline at end of file\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(readFile("veg.txt")).To(Equal("carrot\nleek\nshallot"))
+	})
+
+	Context("when a hunk does not match the file", func() {
+		It("errors without changing any files", func() {
+			err := fe.ApplyPatch(context.Background(), `--- a/veg.txt
++++ b/veg.txt
+@@ -1,3 +1,2 @@
+ carrot
+-leek
+ onion
+--- a/fruit.txt
++++ b/fruit.txt
+@@ -1,2 +1,2 @@
+ apple
+-kiwi
++lime
+`)
+			Expect(err).To(MatchError(ContainSubstring("patch was not applied, 1 hunks failed")))
+			Expect(err).To(MatchError(ContainSubstring(`hunk 1 (@@ -1,2 +1,2 @@) of "fruit.txt": the context and removed lines do not match the file`)))
+			Expect(readFile("veg.txt")).To(Equal("carrot\nleek\nonion\n"))
+			Expect(readFile("fruit.txt")).To(Equal("apple\nbanana\ncherry\ndate\nelderberry\nfig\ngrape\n"))
+		})
+	})
+
+	Context("when the patch creates a file that already exists", func() {
+		It("errors", func() {
+			err := fe.ApplyPatch(context.Background(), "--- /dev/null\n+++ b/veg.txt\n@@ -0,0 +1 @@\n+turnip\n")
+			Expect(err).To(MatchError(`"veg.txt" already exists but the patch creates it`))
+		})
+	})
+
+	Context("when the patched file does not exist", func() {
+		It("errors", func() {
+			err := fe.ApplyPatch(context.Background(), "--- a/missing.txt\n+++ b/missing.txt\n@@ -1 +1 @@\n-a\n+b\n")
+			Expect(err).To(MatchError(os.ErrNotExist))
+		})
+	})
+
+	Context("when the patch is not a unified diff", func() {
+		It("errors", func() {
+			err := fe.ApplyPatch(context.Background(), "please change banana to blueberry")
+			Expect(err).To(MatchError(ContainSubstring("no file headers found")))
+		})
+	})
+
+	Context("when the path is not a local path", func() {
+		It("errors", func() {
+			err := fe.ApplyPatch(context.Background(), "--- a/../../traversal\n+++ b/../../traversal\n@@ -1 +1 @@\n-a\n+b\n")
+			Expect(err).To(MatchError(`path is not a local path: "../../traversal"`))
+		})
+	})
+
+	Context("when the path leads through a symlink that leaves the directory", func() {
+		It("errors without changing the file", func() {
+			outside := GinkgoT().TempDir()
+			Expect(os.WriteFile(filepath.Join(outside, "notes.txt"), []byte("a\n"), 0600)).To(Succeed())
+			Expect(os.Symlink(filepath.Join(outside, "notes.txt"), filepath.Join(dir, "notes.txt"))).To(Succeed())
+
+			err := fe.ApplyPatch(context.Background(), "--- a/notes.txt\n+++ b/notes.txt\n@@ -1 +1 @@\n-a\n+b\n")
+			Expect(err).To(MatchError(`path leads outside of the directory through a symlink: "notes.txt"`))
+			Expect(os.ReadFile(filepath.Join(outside, "notes.txt"))).To(Equal([]byte("a\n")))
+		})
+	})
+})
@@ -0,0 +1,145 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tmc/langchaingo/llms"
+	"gopkg.in/yaml.v3"
+)
+
+// maxPluginOutput is the number of bytes of plugin output returned to the
+// model.
+const maxPluginOutput = 64 * 1024
+
+// A PluginManifest declares tools that are implemented by external
+// executables.
+type PluginManifest struct {
+	Tools []PluginDefinition `yaml:"tools"`
+}
+
+// A PluginDefinition declares a single external tool.
+type PluginDefinition struct {
+	// Name is the name of the tool advertised to the model.
+	Name string `yaml:"name"`
+	// Description describes the tool to the model.
+	Description string `yaml:"description"`
+	// Parameters is the JSON schema of the tool arguments.
+	Parameters *Schema `yaml:"parameters"`
+	// Executable is the path of the program that implements the tool.
+	// Relative paths are resolved against the directory of the manifest.
+	Executable string `yaml:"executable"`
+	// Args are passed to the executable on the command line.
+	Args []string `yaml:"args"`
+}
+
+// LoadPlugins reads the YAML plugin manifest at path and creates a
+// PluginTool for each tool it declares. The tools execute in the working
+// directory specified with dir and are configured with opts.
+// It errors if the manifest cannot be read or a tool is missing its name or
+// executable.
+func LoadPlugins(logger *slog.Logger, path, dir string, opts ...ExecOption) ([]Tool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m PluginManifest
+	d := yaml.NewDecoder(bytes.NewReader(b))
+	d.KnownFields(true)
+	if err := d.Decode(&m); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid plugin manifest %q: %w", path, err)
+	}
+
+	var tools []Tool
+	for i, def := range m.Tools {
+		if def.Name == "" {
+			return nil, fmt.Errorf("invalid plugin manifest %q: tool %d has no name", path, i+1)
+		}
+		if def.Executable == "" {
+			return nil, fmt.Errorf("invalid plugin manifest %q: tool %q has no executable", path, def.Name)
+		}
+		if !filepath.IsAbs(def.Executable) && strings.ContainsRune(def.Executable, filepath.Separator) {
+			def.Executable = filepath.Join(filepath.Dir(path), def.Executable)
+		}
+		if def.Parameters == nil {
+			def.Parameters = &Schema{Type: "object"}
+		}
+		tools = append(tools, NewPluginTool(logger, def, dir, opts...))
+	}
+	return tools, nil
+}
+
+// A PluginTool is a Tool implemented by an external executable.
+// The executable receives the tool arguments as JSON on stdin and its
+// stdout is returned to the model.
+type PluginTool struct {
+	logger *slog.Logger
+	def    PluginDefinition
+	dir    string
+	config execConfig
+}
+
+// NewPluginTool creates a PluginTool.
+// The executable runs in the working directory specified with dir.
+func NewPluginTool(logger *slog.Logger, def PluginDefinition, dir string, opts ...ExecOption) *PluginTool {
+	return &PluginTool{logger: logger, def: def, dir: dir, config: newExecConfig(opts)}
+}
+
+// Definition returns the definition of the tool from the manifest.
+func (p *PluginTool) Definition() llms.FunctionDefinition {
+	return llms.FunctionDefinition{
+		Name:        p.def.Name,
+		Description: p.def.Description,
+		Parameters:  p.def.Parameters,
+	}
+}
+
+// Call runs the executable with arguments on stdin and returns its stdout,
+// truncated to maxPluginOutput bytes. It errors, including stderr, if the
+// executable exits with a non-zero exit code.
+//
+// The executable runs in its own process group, which is killed if ctx is
+// done before it exits, in the same way as commands.
+func (p *PluginTool) Call(ctx context.Context, arguments string) (string, error) {
+	p.logger.Info("running plugin", "tool", p.def.Name, "executable", p.def.Executable)
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.config.timeout)
+		defer cancel()
+	}
+	c := exec.CommandContext(ctx, p.def.Executable, p.def.Args...)
+	c.Dir = p.dir
+	c.Env = p.config.env.environ()
+	c.Stdin = strings.NewReader(arguments)
+	killProcessGroup(c)
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+
+	if err := c.Run(); err != nil {
+		stderrText := truncatePluginOutput(strings.TrimSpace(stderr.String()))
+		return "", fmt.Errorf("plugin %q failed: %w: %s", p.def.Name, contextError(ctx, err), stderrText)
+	}
+	return truncatePluginOutput(stdout.String()), nil
+}
+
+// truncatePluginOutput keeps the first maxPluginOutput bytes of output.
+func truncatePluginOutput(output string) string {
+	if len(output) <= maxPluginOutput {
+		return output
+	}
+	n := maxPluginOutput
+	for n > 0 && !utf8.RuneStart(output[n]) {
+		n--
+	}
+	return fmt.Sprintf("%s\n[truncated: %d of %d bytes shown]\n", output[:n], n, len(output))
+}
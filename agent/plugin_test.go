@@ -0,0 +1,196 @@
+package agent_test
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/acrmp/minimalprompt/agent"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Plugins", func() {
+	var (
+		dir, pluginDir, manifest string
+		logger                   *slog.Logger
+		logOutput                *gbytes.Buffer
+	)
+
+	writeManifest := func(content string) {
+		Expect(os.WriteFile(manifest, []byte(content), 0600)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		logOutput = gbytes.NewBuffer()
+		logger = slog.New(slog.NewTextHandler(logOutput, nil))
+
+		dir, err = os.MkdirTemp("", "plugin-workdir")
+		Expect(err).ToNot(HaveOccurred())
+		pluginDir, err = os.MkdirTemp("", "plugins")
+		Expect(err).ToNot(HaveOccurred())
+		manifest = filepath.Join(pluginDir, "plugins.yaml")
+
+		err = os.MkdirAll(filepath.Join(pluginDir, "bin"), 0700)
+		Expect(err).ToNot(HaveOccurred())
+		err = os.WriteFile(filepath.Join(pluginDir, "bin", "migrate"), []byte(`#!/usr/bin/env bash
+if [ "$1" != "--json" ]; then echo "missing flag" >&2; exit 3; fi
+printf 'migrated in %s with ' "$(basename "$PWD")"
+cat
+`), 0700)
+		Expect(err).ToNot(HaveOccurred())
+
+		writeManifest(`tools:
+  - name: migrateSchema
+    description: Migrate the database schema
+    executable: ./bin/migrate
+    args: ["--json"]
+    parameters:
+      type: object
+      properties:
+        direction:
+          type: string
+          description: Whether to migrate up or down
+          enum: [up, down]
+      required: [direction]
+`)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+		Expect(os.RemoveAll(pluginDir)).To(Succeed())
+	})
+
+	It("creates a tool for each plugin in the manifest", func() {
+		tools, err := agent.LoadPlugins(logger, manifest, dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tools).To(HaveLen(1))
+
+		def := tools[0].Definition()
+		Expect(def.Name).To(Equal("migrateSchema"))
+		Expect(def.Description).To(Equal("Migrate the database schema"))
+		Expect(def.Parameters).To(Equal(&agent.Schema{
+			Type: "object",
+			Properties: map[string]*agent.Schema{
+				"direction": {
+					Type:        "string",
+					Description: "Whether to migrate up or down",
+					Enum:        []any{"up", "down"},
+				},
+			},
+			Required: []string{"direction"},
+		}))
+	})
+
+	It("runs the executable in the directory with the arguments on stdin", func() {
+		tools, err := agent.LoadPlugins(logger, manifest, dir)
+		Expect(err).ToNot(HaveOccurred())
+
+		output, err := tools[0].Call(context.Background(), `{"direction":"up"}`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output).To(Equal(`migrated in ` + filepath.Base(dir) + ` with {"direction":"up"}`))
+		Expect(logOutput).To(gbytes.Say(`running plugin.*migrateSchema`))
+	})
+
+	It("runs the executable with the configured environment", func() {
+		GinkgoT().Setenv("ANTHROPIC_API_KEY", "sk-ant-secret")
+		Expect(os.WriteFile(filepath.Join(pluginDir, "bin", "migrate"), []byte("#!/usr/bin/env bash\nenv\n"), 0700)).To(Succeed())
+		env, err := agent.NewEnvironment(agent.EnvironmentConfig{Set: map[string]string{"DATABASE_URL": "postgres://localhost/app"}})
+		Expect(err).ToNot(HaveOccurred())
+		tools, err := agent.LoadPlugins(logger, manifest, dir, agent.WithEnvironment(env))
+		Expect(err).ToNot(HaveOccurred())
+
+		output, err := tools[0].Call(context.Background(), `{"direction":"up"}`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output).To(ContainSubstring("DATABASE_URL=postgres://localhost/app\n"))
+		Expect(output).ToNot(ContainSubstring("ANTHROPIC_API_KEY"))
+	})
+
+	It("truncates long output", func() {
+		Expect(os.WriteFile(filepath.Join(pluginDir, "bin", "migrate"), []byte("#!/usr/bin/env bash\nhead -c 100000 /dev/zero | tr '\\0' x\n"), 0700)).To(Succeed())
+		tools, err := agent.LoadPlugins(logger, manifest, dir)
+		Expect(err).ToNot(HaveOccurred())
+
+		output, err := tools[0].Call(context.Background(), `{"direction":"up"}`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output).To(HavePrefix(strings.Repeat("x", 65536) + "\n"))
+		Expect(output).To(HaveSuffix("[truncated: 65536 of 100000 bytes shown]\n"))
+	})
+
+	Context("when the executable does not finish in time", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(pluginDir, "bin", "migrate"), []byte("#!/usr/bin/env bash\n(sleep 2; touch late) &\nwait\n"), 0700)).To(Succeed())
+		})
+
+		It("kills the process group and errors", func() {
+			tools, err := agent.LoadPlugins(logger, manifest, dir, agent.WithTimeout(500*time.Millisecond))
+			Expect(err).ToNot(HaveOccurred())
+
+			start := time.Now()
+			_, err = tools[0].Call(context.Background(), `{"direction":"up"}`)
+			Expect(err).To(MatchError(agent.ErrCommandTimedOut))
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+
+			Consistently(filepath.Join(dir, "late"), 3*time.Second).ShouldNot(BeAnExistingFile())
+		})
+	})
+
+	Context("when the executable fails", func() {
+		BeforeEach(func() {
+			writeManifest(`tools:
+  - name: migrateSchema
+    executable: ./bin/migrate
+`)
+		})
+
+		It("errors with stderr", func() {
+			tools, err := agent.LoadPlugins(logger, manifest, dir)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = tools[0].Call(context.Background(), `{}`)
+			Expect(err).To(MatchError(`plugin "migrateSchema" failed: exit status 3: missing flag`))
+		})
+
+		It("defaults the parameters to an object", func() {
+			tools, err := agent.LoadPlugins(logger, manifest, dir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tools[0].Definition().Parameters).To(Equal(&agent.Schema{Type: "object"}))
+		})
+	})
+
+	Context("when a plugin has no executable", func() {
+		It("errors", func() {
+			writeManifest("tools:\n  - name: lint\n")
+			_, err := agent.LoadPlugins(logger, manifest, dir)
+			Expect(err).To(MatchError(ContainSubstring(`tool "lint" has no executable`)))
+		})
+	})
+
+	Context("when a plugin has no name", func() {
+		It("errors", func() {
+			writeManifest("tools:\n  - executable: lint\n")
+			_, err := agent.LoadPlugins(logger, manifest, dir)
+			Expect(err).To(MatchError(ContainSubstring(`tool 1 has no name`)))
+		})
+	})
+
+	Context("when the manifest has unknown fields", func() {
+		It("errors", func() {
+			writeManifest("tools:\n  - name: lint\n    exe: lint\n")
+			_, err := agent.LoadPlugins(logger, manifest, dir)
+			Expect(err).To(MatchError(ContainSubstring("invalid plugin manifest")))
+		})
+	})
+
+	Context("when the manifest does not exist", func() {
+		It("errors", func() {
+			_, err := agent.LoadPlugins(logger, filepath.Join(pluginDir, "missing.yaml"), dir)
+			Expect(err).To(MatchError(os.ErrNotExist))
+		})
+	})
+})
@@ -0,0 +1,692 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// A PolicyConfig lists the rules of a command Policy.
+//
+// Each rule is written as a bash command. Rule words are glob patterns where
+// * matches any text. A rule matches a simple command with the same name,
+// where an allow rule without a slash only matches a bare name that is found
+// through PATH, while a deny rule without a slash matches any command with
+// the same base name. The arguments of a deny rule may appear anywhere among
+// the arguments of the command in order, while those of an allow rule must
+// be the first arguments of the command, so that "go test" does not allow
+// "go run ./x test". A rule that is a pipeline, such as "curl | sh", matches
+// a pipeline containing commands that match each stage of the rule in order.
+type PolicyConfig struct {
+	// Allow rules, when present, list the only commands that may run.
+	Allow []string `yaml:"allow"`
+	// Deny rules list commands that must not run, even if they are allowed.
+	Deny []string `yaml:"deny"`
+}
+
+// A Policy decides whether a bash command may run by parsing it and checking
+// every simple command it contains, including those in pipelines,
+// subshells, command substitutions and the scripts passed to eval and
+// bash -c, against allow and deny rules. The commands run by wrappers such
+// as sudo and xargs and by find -exec are also checked against the deny
+// rules, but only the wrapper itself is checked against the allow rules.
+type Policy struct {
+	allow []policyRule
+	deny  []policyRule
+}
+
+// A PolicyDeniedError is returned for commands that are denied by a Policy.
+type PolicyDeniedError struct {
+	// Command is the part of the command that was denied.
+	Command string
+	// Rule is the deny rule that matched, if any.
+	Rule string
+	// Reason explains why the command was denied.
+	Reason string
+}
+
+func (e *PolicyDeniedError) Error() string {
+	return fmt.Sprintf("%q %s", e.Command, e.Reason)
+}
+
+// A policyRule is a pipeline of simple command patterns.
+type policyRule struct {
+	source string
+	stages [][]*regexp.Regexp
+}
+
+// a policyCommand is a simple command from a parsed bash command. Words
+// that are not literal, such as those with parameter expansions, are nil.
+type policyCommand struct {
+	source string
+	words  []*string
+	// input is the literal text passed to the command on stdin by a
+	// here-string or here-document, if any.
+	input *string
+	// setsPath is true if the command is run with an assignment to PATH, so
+	// that its name may not be found through the usual PATH.
+	setsPath bool
+	// resolved is the path that PATH resolves a bare name to, if known.
+	resolved string
+}
+
+// shellWrappers are commands that run their arguments as another command,
+// with the options of each that take a separate argument.
+var shellWrappers = map[string][]string{
+	"builtin": nil,
+	"command": nil,
+	"doas":    {"-C", "-u"},
+	"env":     {"-C", "-u", "--chdir", "--unset"},
+	"exec":    {"-a"},
+	"nice":    {"-n", "--adjustment"},
+	"nohup":   nil,
+	"pkexec":  {"--user"},
+	"stdbuf":  {"-e", "-i", "-o", "--error", "--input", "--output"},
+	"sudo":    {"-C", "-D", "-g", "-h", "-p", "-R", "-r", "-T", "-t", "-U", "-u", "--chdir", "--chroot", "--close-from", "--command-timeout", "--group", "--host", "--other-user", "--prompt", "--role", "--type", "--user"},
+	"time":    {"-f", "-o", "--format", "--output"},
+	"timeout": {"-k", "-s", "--kill-after", "--signal"},
+	"xargs":   {"-a", "-d", "-E", "-I", "-L", "-n", "-P", "-s", "--arg-file", "--delimiter", "--max-args", "--max-chars", "--max-lines", "--max-procs", "--process-slot-var"},
+}
+
+// privilegeWrappers are wrappers that run their command as another user.
+// They never match allow rules, so the user is always asked before they run.
+var privilegeWrappers = []string{"doas", "pkexec", "sudo"}
+
+// findActions are the actions of find that run a command, whose words end
+// with ; or with {} +.
+var findActions = []string{"-exec", "-execdir", "-ok", "-okdir"}
+
+// shells are commands whose -c argument, or script read from stdin, is parsed
+// and checked as a script.
+var shells = []string{"bash", "dash", "ksh", "sh", "zsh"}
+
+// LoadPolicy reads a Policy from the YAML file at path.
+func LoadPolicy(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c PolicyConfig
+	d := yaml.NewDecoder(bytes.NewReader(b))
+	d.KnownFields(true)
+	if err := d.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid command policy %q: %w", path, err)
+	}
+	p, err := NewPolicy(c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid command policy %q: %w", path, err)
+	}
+	return p, nil
+}
+
+// NewPolicy creates a Policy from the rules in c.
+// It errors if a rule is not a simple command or pipeline.
+func NewPolicy(c PolicyConfig) (*Policy, error) {
+	p := &Policy{}
+	for _, r := range c.Allow {
+		rule, err := parseRule(r)
+		if err != nil {
+			return nil, err
+		}
+		p.allow = append(p.allow, rule)
+	}
+	for _, r := range c.Deny {
+		rule, err := parseRule(r)
+		if err != nil {
+			return nil, err
+		}
+		p.deny = append(p.deny, rule)
+	}
+	return p, nil
+}
+
+func parseRule(r string) (policyRule, error) {
+	f, err := syntax.NewParser(syntax.Variant(syntax.LangBash)).Parse(strings.NewReader(r), "")
+	if err != nil {
+		return policyRule{}, fmt.Errorf("rule %q: %w", r, err)
+	}
+	if len(f.Stmts) != 1 {
+		return policyRule{}, fmt.Errorf("rule %q must be a single command or pipeline", r)
+	}
+	rule := policyRule{source: r}
+	for _, s := range pipelineStages(f.Stmts[0]) {
+		c, ok := simpleCommand(r, s)
+		if !ok {
+			return policyRule{}, fmt.Errorf("rule %q must be a single command or pipeline", r)
+		}
+		var stage []*regexp.Regexp
+		for _, w := range c.words {
+			if w == nil {
+				return policyRule{}, fmt.Errorf("rule %q must only contain literal words", r)
+			}
+			stage = append(stage, wordPattern(*w))
+		}
+		rule.stages = append(rule.stages, stage)
+	}
+	return rule, nil
+}
+
+// wordPattern converts a glob, where * matches any text including slashes,
+// to a regular expression.
+func wordPattern(glob string) *regexp.Regexp {
+	re := regexp.QuoteMeta(glob)
+	re = strings.ReplaceAll(re, `\*`, `.*`)
+	re = strings.ReplaceAll(re, `\?`, `.`)
+	return regexp.MustCompile("^" + re + "$")
+}
+
+// Check returns a PolicyDeniedError if the command must not run.
+func (p *Policy) Check(command string) error {
+	return p.check(command, 0)
+}
+
+// CheckProgram returns a PolicyDeniedError if the program in argv must not
+// run. Every argument is a literal word, so rules match the exact program
+// and arguments. A rule with a slash matches the program when it is argv[0]
+// or the path that PATH resolves argv[0] to.
+func (p *Policy) CheckProgram(argv []string) error {
+	c := policyCommand{source: quoteArgv(argv)}
+	for _, a := range argv {
+		c.words = append(c.words, &a)
+	}
+	if len(argv) > 0 && !strings.Contains(argv[0], "/") {
+		c.resolved, _ = exec.LookPath(argv[0])
+	}
+	return p.checkCommand(c, 0)
+}
+
+// maxScriptDepth limits how deeply scripts passed to shells are checked.
+const maxScriptDepth = 5
+
+func (p *Policy) check(command string, depth int) error {
+	if depth > maxScriptDepth {
+		return &PolicyDeniedError{Command: command, Reason: "nests too many scripts to be checked"}
+	}
+	f, err := syntax.NewParser(syntax.Variant(syntax.LangBash)).Parse(strings.NewReader(command), "")
+	if err != nil {
+		return &PolicyDeniedError{Command: command, Reason: fmt.Sprintf("could not be parsed: %s", err)}
+	}
+
+	var denied error
+	syntax.Walk(f, func(n syntax.Node) bool {
+		if denied != nil {
+			return false
+		}
+		switch n := n.(type) {
+		case *syntax.BinaryCmd:
+			if n.Op == syntax.Pipe || n.Op == syntax.PipeAll {
+				var pipeline []policyCommand
+				for _, s := range pipelineStages(&syntax.Stmt{Cmd: n}) {
+					if c, ok := simpleCommand(command, s); ok {
+						pipeline = append(pipeline, c)
+					}
+				}
+				denied = p.checkPipeline(pipeline)
+			}
+		case *syntax.Stmt:
+			if c, ok := simpleCommand(command, n); ok {
+				denied = p.checkCommand(c, depth)
+			}
+		}
+		return true
+	})
+	return denied
+}
+
+// checkPipeline applies the deny rules with more than one stage.
+func (p *Policy) checkPipeline(pipeline []policyCommand) error {
+	for _, r := range p.deny {
+		if len(r.stages) > 1 && r.matchesPipeline(pipeline, true) {
+			var sources []string
+			for _, c := range pipeline {
+				sources = append(sources, c.source)
+			}
+			return &PolicyDeniedError{Command: strings.Join(sources, " | "), Rule: r.source, Reason: fmt.Sprintf("is denied by rule %q", r.source)}
+		}
+	}
+	return nil
+}
+
+func (p *Policy) checkCommand(c policyCommand, depth int) error {
+	candidates := unwrap(c)
+	for _, r := range p.deny {
+		if len(r.stages) != 1 {
+			continue
+		}
+		for _, cc := range candidates {
+			if r.matches(cc, 0, true, false) {
+				return &PolicyDeniedError{Command: c.source, Rule: r.source, Reason: fmt.Sprintf("is denied by rule %q", r.source)}
+			}
+		}
+	}
+
+	for _, cc := range candidates {
+		script, ok := shellScript(cc)
+		if !ok {
+			continue
+		}
+		if script == nil {
+			if len(p.allow) > 0 {
+				return &PolicyDeniedError{Command: c.source, Reason: "runs a script that cannot be checked"}
+			}
+		} else if err := p.check(*script, depth+1); err != nil {
+			return err
+		}
+	}
+
+	if len(p.allow) == 0 {
+		return nil
+	}
+	if c.words[0] != nil && slices.Contains(privilegeWrappers, path.Base(*c.words[0])) {
+		return &PolicyDeniedError{Command: c.source, Reason: "runs a command as another user"}
+	}
+	for _, r := range p.allow {
+		if len(r.stages) == 1 && r.matches(c, 0, false, true) {
+			return nil
+		}
+	}
+	return &PolicyDeniedError{Command: c.source, Reason: "is not allowed by any rule"}
+}
+
+// matchesPipeline reports whether the stages of the rule match commands of
+// the pipeline in order.
+func (r policyRule) matchesPipeline(pipeline []policyCommand, unknown bool) bool {
+	stage := 0
+	for _, c := range pipeline {
+		for _, cc := range unwrap(c) {
+			if r.matches(cc, stage, unknown, false) {
+				stage++
+				break
+			}
+		}
+		if stage == len(r.stages) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the stage of the rule matches the command. Words
+// that are not literal match when unknown is true. When allow is true the
+// arguments of the rule must be the first arguments of the command, and a
+// name without a slash only matches a bare name found through PATH.
+func (r policyRule) matches(c policyCommand, stage int, unknown, allow bool) bool {
+	patterns := r.stages[stage]
+	if len(c.words) == 0 {
+		return false
+	}
+	match := func(re *regexp.Regexp, w *string) bool {
+		if w == nil {
+			return unknown
+		}
+		return re.MatchString(*w)
+	}
+	if !r.matchesName(patterns[0], c, unknown, allow) {
+		return false
+	}
+	if allow {
+		if len(c.words) < len(patterns) {
+			return false
+		}
+		for i, re := range patterns[1:] {
+			if !match(re, c.words[i+1]) {
+				return false
+			}
+		}
+		return true
+	}
+	i := 0
+	for _, w := range c.words[1:] {
+		if i < len(patterns)-1 && match(patterns[i+1], w) {
+			i++
+		}
+	}
+	return i == len(patterns)-1
+}
+
+// matchesName reports whether the name pattern of a rule matches the name of
+// the command.
+func (r policyRule) matchesName(re *regexp.Regexp, c policyCommand, unknown, allow bool) bool {
+	name := c.words[0]
+	switch {
+	case name == nil:
+		return unknown
+	case strings.Contains(re.String(), "/"):
+		return re.MatchString(*name) || (c.resolved != "" && re.MatchString(c.resolved))
+	case allow:
+		return !c.setsPath && !strings.Contains(*name, "/") && re.MatchString(*name)
+	default:
+		return re.MatchString(path.Base(*name))
+	}
+}
+
+// unwrap returns the command followed by the commands run by it and by them
+// in turn, such as the commands run by sudo, env, xargs and find -exec.
+func unwrap(c policyCommand) []policyCommand {
+	candidates := []policyCommand{c}
+	for i := 0; i < len(candidates); i++ {
+		candidates = append(candidates, innerCommands(candidates[i])...)
+	}
+	return candidates
+}
+
+// innerCommands returns the commands that c runs as a wrapper or with the
+// actions of find.
+func innerCommands(c policyCommand) []policyCommand {
+	if len(c.words) == 0 || c.words[0] == nil {
+		return nil
+	}
+	name := path.Base(*c.words[0])
+	if name == "find" {
+		return findCommands(c)
+	}
+	options, ok := shellWrappers[name]
+	if !ok {
+		return nil
+	}
+	words := c.words[1:]
+	for len(words) > 0 && words[0] != nil {
+		w := *words[0]
+		if w == "--" {
+			words = words[1:]
+			break
+		}
+		if !strings.HasPrefix(w, "-") && (name != "env" || !strings.Contains(w, "=")) {
+			break
+		}
+		words = words[1:]
+		if slices.Contains(options, w) && len(words) > 0 {
+			words = words[1:]
+		}
+	}
+	if name == "timeout" && len(words) > 0 {
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		return nil
+	}
+	return []policyCommand{{source: c.source, words: words}}
+}
+
+// findCommands returns the commands run by the actions of find.
+func findCommands(c policyCommand) []policyCommand {
+	var commands []policyCommand
+	var words []*string
+	action := false
+	for _, w := range c.words[1:] {
+		switch {
+		case !action:
+			action = w != nil && slices.Contains(findActions, *w)
+		case w != nil && (*w == ";" || (*w == "+" && len(words) > 0 && words[len(words)-1] != nil && *words[len(words)-1] == "{}")):
+			if len(words) > 0 {
+				commands = append(commands, policyCommand{source: c.source, words: words})
+			}
+			words, action = nil, false
+		default:
+			words = append(words, w)
+		}
+	}
+	if len(words) > 0 {
+		commands = append(commands, policyCommand{source: c.source, words: words})
+	}
+	return commands
+}
+
+// shellScript returns the script run by eval or a shell with -c, or by a
+// shell reading its script from stdin. The script is nil if it is not
+// literal, such as one piped to the shell.
+func shellScript(c policyCommand) (*string, bool) {
+	if len(c.words) == 0 || c.words[0] == nil {
+		return nil, false
+	}
+	name := path.Base(*c.words[0])
+	switch {
+	case name == "eval":
+		var parts []string
+		for _, w := range c.words[1:] {
+			if w == nil {
+				return nil, true
+			}
+			parts = append(parts, *w)
+		}
+		script := strings.Join(parts, " ")
+		return &script, true
+	case slices.Contains(shells, name):
+		for i := 1; i < len(c.words); i++ {
+			w := c.words[i]
+			switch {
+			case w == nil:
+				return nil, true
+			case !strings.HasPrefix(*w, "-") && !strings.HasPrefix(*w, "+"):
+				// The shell runs a script file.
+				return nil, false
+			case strings.HasPrefix(*w, "--"):
+			case strings.HasPrefix(*w, "-") && strings.Contains(*w, "c"):
+				if i+1 < len(c.words) {
+					return c.words[i+1], true
+				}
+				return nil, false
+			case strings.HasPrefix(*w, "-") && strings.Contains(*w, "s"):
+				return c.input, true
+			case shellOptionArgument(*w):
+				i++
+			}
+		}
+		return c.input, true
+	}
+	return nil, false
+}
+
+// shellOptionArgument reports whether the shell option w takes the next word
+// as its argument, as -o and -O do.
+func shellOptionArgument(w string) bool {
+	return w == "-o" || w == "+o" || w == "-O" || w == "+O"
+}
+
+// pipelineStages flattens a pipeline into its stages.
+func pipelineStages(s *syntax.Stmt) []*syntax.Stmt {
+	if b, ok := s.Cmd.(*syntax.BinaryCmd); ok && (b.Op == syntax.Pipe || b.Op == syntax.PipeAll) {
+		return append(pipelineStages(b.X), pipelineStages(b.Y)...)
+	}
+	return []*syntax.Stmt{s}
+}
+
+// simpleCommand returns the words of the statement if it is a simple
+// command.
+func simpleCommand(source string, s *syntax.Stmt) (policyCommand, bool) {
+	call, ok := s.Cmd.(*syntax.CallExpr)
+	if !ok || len(call.Args) == 0 {
+		return policyCommand{}, false
+	}
+	c := policyCommand{source: source[call.Pos().Offset():call.End().Offset()]}
+	for _, w := range call.Args {
+		c.words = append(c.words, literalWord(w))
+	}
+	for _, a := range call.Assigns {
+		c.setsPath = c.setsPath || a.Name.Value == "PATH"
+	}
+	for _, r := range s.Redirs {
+		if r.N != nil && r.N.Value != "0" {
+			continue
+		}
+		switch r.Op {
+		case syntax.WordHdoc:
+			c.input = literalWord(r.Word)
+		case syntax.Hdoc, syntax.DashHdoc:
+			c.input = heredoc(r.Hdoc)
+		case syntax.RdrIn, syntax.RdrInOut:
+			c.input = nil
+		}
+	}
+	return c, true
+}
+
+// heredoc returns the body of a here-document, or nil if it contains
+// expansions.
+func heredoc(w *syntax.Word) *string {
+	var b strings.Builder
+	if w != nil {
+		for _, part := range w.Parts {
+			lit, ok := part.(*syntax.Lit)
+			if !ok {
+				return nil
+			}
+			b.WriteString(lit.Value)
+		}
+	}
+	s := b.String()
+	return &s
+}
+
+// literalWord returns the value of a word after quote removal, or nil if
+// the word contains expansions.
+func literalWord(w *syntax.Word) *string {
+	var b strings.Builder
+	for _, part := range w.Parts {
+		switch part := part.(type) {
+		case *syntax.Lit:
+			b.WriteString(unescape(part.Value, false))
+		case *syntax.SglQuoted:
+			if part.Dollar {
+				return nil
+			}
+			b.WriteString(part.Value)
+		case *syntax.DblQuoted:
+			for _, dp := range part.Parts {
+				lit, ok := dp.(*syntax.Lit)
+				if !ok {
+					return nil
+				}
+				b.WriteString(unescape(lit.Value, true))
+			}
+		default:
+			return nil
+		}
+	}
+	s := b.String()
+	return &s
+}
+
+// unescape removes the backslashes that quote characters in a literal, so
+// that r\m is checked as rm. Within double quotes a backslash only quotes $,
+// `, ", \ and newline, and otherwise stays.
+func unescape(lit string, quoted bool) string {
+	if !strings.Contains(lit, `\`) {
+		return lit
+	}
+	var b strings.Builder
+	for i := 0; i < len(lit); i++ {
+		if lit[i] != '\\' || i+1 == len(lit) {
+			b.WriteByte(lit[i])
+			continue
+		}
+		next := lit[i+1]
+		switch {
+		case next == '\n':
+			// A line continuation is removed.
+		case !quoted || strings.IndexByte("$`\"\\", next) >= 0:
+			b.WriteByte(next)
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(next)
+		}
+		i++
+	}
+	return b.String()
+}
+
+// A PolicyExecutor checks commands against a Policy before executing them
+// with another CommandExecutor. Every decision is logged for auditing.
+type PolicyExecutor struct {
+	logger *slog.Logger
+	next   CommandExecutor
+	policy *Policy
+}
+
+// NewPolicyExecutor creates a PolicyExecutor that executes the commands
+// allowed by policy with next.
+func NewPolicyExecutor(logger *slog.Logger, next CommandExecutor, policy *Policy) *PolicyExecutor {
+	return &PolicyExecutor{logger: logger, next: next, policy: policy}
+}
+
+// Execute executes the command if it is allowed by the policy.
+// It returns a PolicyDeniedError without executing the command otherwise.
+func (pe *PolicyExecutor) Execute(ctx context.Context, command string) (CommandResult, error) {
+	if err := checkPolicy(pe.logger, pe.policy, command); err != nil {
+		return CommandResult{}, err
+	}
+	return pe.next.Execute(ctx, command)
+}
+
+// A PolicyProcessManager checks commands against a Policy before starting
+// them with another ProcessManager.
+type PolicyProcessManager struct {
+	ProcessManager
+	logger *slog.Logger
+	policy *Policy
+}
+
+// NewPolicyProcessManager creates a PolicyProcessManager that starts the
+// processes allowed by policy with next.
+func NewPolicyProcessManager(logger *slog.Logger, next ProcessManager, policy *Policy) *PolicyProcessManager {
+	return &PolicyProcessManager{ProcessManager: next, logger: logger, policy: policy}
+}
+
+// Start starts the process if its command is allowed by the policy.
+// It returns a PolicyDeniedError without starting the process otherwise.
+func (pm *PolicyProcessManager) Start(ctx context.Context, command string) (string, error) {
+	if err := checkPolicy(pm.logger, pm.policy, command); err != nil {
+		return "", err
+	}
+	return pm.ProcessManager.Start(ctx, command)
+}
+
+// A PolicyProgramRunner checks programs against a Policy before running
+// them with another ProgramRunner.
+type PolicyProgramRunner struct {
+	logger *slog.Logger
+	next   ProgramRunner
+	policy *Policy
+}
+
+// NewPolicyProgramRunner creates a PolicyProgramRunner that runs the
+// programs allowed by policy with next.
+func NewPolicyProgramRunner(logger *slog.Logger, next ProgramRunner, policy *Policy) *PolicyProgramRunner {
+	return &PolicyProgramRunner{logger: logger, next: next, policy: policy}
+}
+
+// RunProgram runs the program if it is allowed by the policy.
+// It returns a PolicyDeniedError without running the program otherwise.
+func (pr *PolicyProgramRunner) RunProgram(ctx context.Context, argv []string) (CommandResult, error) {
+	err := pr.policy.CheckProgram(argv)
+	var denied *PolicyDeniedError
+	if errors.As(err, &denied) {
+		pr.logger.Warn("program denied by policy", "argv", argv, "rule", denied.Rule, "reason", denied.Reason)
+		return CommandResult{}, err
+	}
+	pr.logger.Info("program allowed by policy", "argv", argv)
+	return pr.next.RunProgram(ctx, argv)
+}
+
+func checkPolicy(logger *slog.Logger, policy *Policy, command string) error {
+	err := policy.Check(command)
+	var denied *PolicyDeniedError
+	if errors.As(err, &denied) {
+		logger.Warn("command denied by policy", "command", command, "denied", denied.Command, "rule", denied.Rule, "reason", denied.Reason)
+		return err
+	}
+	logger.Info("command allowed by policy", "command", command)
+	return err
+}
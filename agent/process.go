@@ -0,0 +1,255 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// processBufferSize is the number of bytes of output kept for each
+	// background process.
+	processBufferSize = 64 * 1024
+	// defaultProcessLines is the number of lines of output returned by
+	// ReadOutput when no number is given.
+	defaultProcessLines = 50
+	// defaultStopGracePeriod is how long Stop waits after SIGTERM before
+	// killing a process.
+	defaultStopGracePeriod = 5 * time.Second
+)
+
+// A BashProcessManager runs long-lived bash commands, such as servers, in
+// the background. Each process is identified by an ID that is unique within
+// the manager, and the most recent output of each is kept in a ring buffer.
+type BashProcessManager struct {
+	logger    *slog.Logger
+	dir       string
+	config    execConfig
+	mu        sync.Mutex
+	processes map[string]*process
+	next      int
+}
+
+type process struct {
+	command string
+	cmd     *exec.Cmd
+	output  *ringBuffer
+	done    chan struct{}
+	err     error
+}
+
+// WithStopGracePeriod sets how long the Stop of a BashProcessManager waits
+// for a process to exit after SIGTERM before killing it. It defaults to 5
+// seconds.
+func WithStopGracePeriod(d time.Duration) ExecOption {
+	return func(c *execConfig) {
+		c.grace = d
+	}
+}
+
+// NewBashProcessManager creates a BashProcessManager.
+// Processes start in the working directory specified with dir.
+func NewBashProcessManager(logger *slog.Logger, dir string, opts ...ExecOption) *BashProcessManager {
+	return &BashProcessManager{logger: logger, dir: dir, config: newExecConfig(opts), processes: map[string]*process{}}
+}
+
+// Start runs the bash command in the background in its own process group
+// and returns the ID of the process. The process outlives ctx and runs until
+// it is stopped or the manager is closed.
+func (m *BashProcessManager) Start(ctx context.Context, command string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.next++
+	id := fmt.Sprintf("p%d", m.next)
+	m.logger.Info("starting process", "id", id, "command", command)
+
+	p := &process{command: command, output: newRingBuffer(processBufferSize), done: make(chan struct{})}
+	p.cmd = exec.Command(m.config.shell, "-c", m.config.limits.ulimits()+command)
+	p.cmd.Dir = m.dir
+	p.cmd.Env = m.config.env.environ()
+	p.cmd.Stdout = p.output
+	p.cmd.Stderr = p.output
+	p.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// Processes that leave the group may hold the output pipe open.
+	p.cmd.WaitDelay = time.Second
+	applyLimits(p.cmd, m.config.limits)
+	if err := p.cmd.Start(); err != nil {
+		return "", err
+	}
+	go func() {
+		p.err = p.cmd.Wait()
+		close(p.done)
+	}()
+	m.processes[id] = p
+	return id, nil
+}
+
+// ReadOutput returns the status of the process followed by up to lines lines
+// of its most recent output. A lines of 0 returns defaultProcessLines lines.
+// It errors if there is no process with the ID.
+func (m *BashProcessManager) ReadOutput(id string, lines int) (string, error) {
+	p, err := m.lookup(id)
+	if err != nil {
+		return "", err
+	}
+	if lines <= 0 {
+		lines = defaultProcessLines
+	}
+	return p.status(id) + p.output.Tail(lines), nil
+}
+
+// Stop sends SIGTERM to the process group, killing it if it has not exited
+// within the grace period, and returns the status and final output of the
+// process.
+// It errors if there is no process with the ID.
+func (m *BashProcessManager) Stop(id string) (string, error) {
+	p, err := m.lookup(id)
+	if err != nil {
+		return "", err
+	}
+	m.logger.Info("stopping process", "id", id)
+	p.stop(m.config.grace)
+	return p.status(id) + p.output.Tail(defaultProcessLines), nil
+}
+
+// Close kills every process that is still running.
+func (m *BashProcessManager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, p := range m.processes {
+		if !p.exited() {
+			m.logger.Info("stopping process", "id", id)
+			p.stop(0)
+		}
+	}
+	return nil
+}
+
+func (m *BashProcessManager) lookup(id string) (*process, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.processes[id]
+	if !ok {
+		return nil, fmt.Errorf("no process with id %q", id)
+	}
+	return p, nil
+}
+
+func (p *process) exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop signals the process group to terminate, killing it after grace.
+func (p *process) stop(grace time.Duration) {
+	if p.exited() {
+		return
+	}
+	pgid := -p.cmd.Process.Pid
+	if grace > 0 {
+		_ = syscall.Kill(pgid, syscall.SIGTERM)
+		select {
+		case <-p.done:
+			return
+		case <-time.After(grace):
+		}
+	}
+	_ = syscall.Kill(pgid, syscall.SIGKILL)
+	<-p.done
+}
+
+func (p *process) status(id string) string {
+	if !p.exited() {
+		return fmt.Sprintf("Process %s is running: %s\n", id, p.command)
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case p.err == nil:
+		return fmt.Sprintf("Process %s exited successfully: %s\n", id, p.command)
+	case errors.As(p.err, &exitErr):
+		return fmt.Sprintf("Process %s exited with %s: %s\n", id, exitErr.ProcessState, p.command)
+	}
+	return fmt.Sprintf("Process %s failed: %s: %s\n", id, p.err, p.command)
+}
+
+// A ringBuffer keeps the most recent bytes written to it.
+type ringBuffer struct {
+	mu      sync.Mutex
+	buf     []byte
+	start   int
+	full    bool
+	dropped bool
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{buf: make([]byte, 0, size)}
+}
+
+func (r *ringBuffer) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(b)
+	size := cap(r.buf)
+	if len(b) > size {
+		b = b[len(b)-size:]
+		r.dropped = true
+	}
+	for len(b) > 0 {
+		if !r.full {
+			k := min(len(b), size-len(r.buf))
+			r.buf = append(r.buf, b[:k]...)
+			b = b[k:]
+			r.full = len(r.buf) == size
+			continue
+		}
+		k := copy(r.buf[r.start:], b)
+		r.start = (r.start + k) % size
+		b = b[k:]
+		r.dropped = true
+	}
+	return n, nil
+}
+
+// Tail returns up to n of the most recent lines, preceded by a marker if
+// earlier output is not included.
+func (r *ringBuffer) Tail(n int) string {
+	r.mu.Lock()
+	content := string(r.buf[r.start:]) + string(r.buf[:r.start])
+	dropped := r.dropped
+	r.mu.Unlock()
+
+	if content == "" {
+		return "(no output)\n"
+	}
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if dropped {
+		// The first line may have been partially overwritten.
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+		dropped = true
+	}
+	out := strings.Join(lines, "")
+	if !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	if dropped {
+		out = "[earlier output omitted]\n" + out
+	}
+	return out
+}
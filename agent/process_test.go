@@ -0,0 +1,162 @@
+package agent_test
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/acrmp/minimalprompt/agent"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("BashProcessManager", func() {
+	var (
+		dir       string
+		pm        *agent.BashProcessManager
+		logger    *slog.Logger
+		logOutput *gbytes.Buffer
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "processes")
+		Expect(err).ToNot(HaveOccurred())
+
+		logOutput = gbytes.NewBuffer()
+		logger = slog.New(slog.NewTextHandler(logOutput, nil))
+
+		pm = agent.NewBashProcessManager(logger, dir)
+	})
+
+	AfterEach(func() {
+		Expect(pm.Close()).To(Succeed())
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("starts processes in the background with ids unique to the manager", func() {
+		id, err := pm.Start(context.Background(), "sleep 30")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(id).To(Equal("p1"))
+
+		id, err = pm.Start(context.Background(), "sleep 30")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(id).To(Equal("p2"))
+
+		Expect(logOutput).To(gbytes.Say(`starting process.*id=p1.*sleep 30`))
+	})
+
+	It("runs the process in the directory", func() {
+		_, err := pm.Start(context.Background(), "touch started")
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(filepath.Join(dir, "started")).Should(BeAnExistingFile())
+	})
+
+	It("reads the status and output of a running process", func() {
+		id, err := pm.Start(context.Background(), "echo listening; echo warning >&2; sleep 30")
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func() (string, error) {
+			return pm.ReadOutput(id, 0)
+		}).Should(Equal("Process p1 is running: echo listening; echo warning >&2; sleep 30\nlistening\nwarning\n"))
+	})
+
+	It("reads the most recent lines of output", func() {
+		id, err := pm.Start(context.Background(), "seq 1 100; sleep 30")
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func() (string, error) {
+			return pm.ReadOutput(id, 2)
+		}).Should(Equal("Process p1 is running: seq 1 100; sleep 30\n[earlier output omitted]\n99\n100\n"))
+	})
+
+	It("keeps only the most recent output of a noisy process", func() {
+		id, err := pm.Start(context.Background(), "yes 'some output' | head -n 100000; echo last")
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func() (string, error) {
+			return pm.ReadOutput(id, 100000)
+		}).Should(HaveSuffix("some output\nlast\n"))
+
+		output, err := pm.ReadOutput(id, 100000)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output).To(ContainSubstring("[earlier output omitted]\n"))
+		Expect(len(output)).To(BeNumerically("<=", 64*1024+200))
+	})
+
+	It("reports when a process has exited", func() {
+		id, err := pm.Start(context.Background(), "echo done; exit 3")
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func() (string, error) {
+			return pm.ReadOutput(id, 0)
+		}).Should(Equal("Process p1 exited with exit status 3: echo done; exit 3\ndone\n"))
+	})
+
+	It("reports when a process has no output", func() {
+		id, err := pm.Start(context.Background(), "sleep 30")
+		Expect(err).ToNot(HaveOccurred())
+
+		output, err := pm.ReadOutput(id, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output).To(Equal("Process p1 is running: sleep 30\n(no output)\n"))
+	})
+
+	It("stops a process and everything it started", func() {
+		id, err := pm.Start(context.Background(), "(sleep 1; touch late-file) & echo serving; sleep 30")
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(func() (string, error) {
+			return pm.ReadOutput(id, 0)
+		}).Should(HaveSuffix("serving\n"))
+
+		output, err := pm.Stop(id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output).To(HavePrefix("Process p1 exited with signal: terminated"))
+		Expect(output).To(HaveSuffix("serving\n"))
+		Expect(logOutput).To(gbytes.Say(`stopping process.*id=p1`))
+
+		Consistently(filepath.Join(dir, "late-file"), 2*time.Second).ShouldNot(BeAnExistingFile())
+	})
+
+	It("kills a process that ignores SIGTERM", func() {
+		pm = agent.NewBashProcessManager(logger, dir, agent.WithStopGracePeriod(200*time.Millisecond))
+		id, err := pm.Start(context.Background(), "trap '' TERM; echo ready; while true; do sleep 0.1; done")
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(func() (string, error) {
+			return pm.ReadOutput(id, 0)
+		}).Should(HaveSuffix("ready\n"))
+
+		output, err := pm.Stop(id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output).To(HavePrefix("Process p1 exited with signal: killed"))
+	})
+
+	It("stops every running process when closed", func() {
+		_, err := pm.Start(context.Background(), "sleep 1; touch first-file")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = pm.Start(context.Background(), "sleep 1; touch second-file")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(pm.Close()).To(Succeed())
+		Consistently(func() []string {
+			entries, _ := os.ReadDir(dir)
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			return names
+		}, 2*time.Second).Should(BeEmpty())
+	})
+
+	Context("when there is no process with the id", func() {
+		It("errors", func() {
+			_, err := pm.ReadOutput("p9", 0)
+			Expect(err).To(MatchError(`no process with id "p9"`))
+			_, err = pm.Stop("p9")
+			Expect(err).To(MatchError(`no process with id "p9"`))
+		})
+	})
+})
@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"context"
 	"fmt"
 	"io"
 )
@@ -18,11 +19,26 @@ func NewTerminalPrompter(r io.Reader, w io.Writer) *TerminalPrompter {
 
 // Prompt shows the prompt p to the user and returns the response when the
 // reader has read to the end of the stream.
-func (tp *TerminalPrompter) Prompt(p string) (string, error) {
+// It returns the error of ctx if ctx is done first, leaving the reader to
+// be read to the end in the background.
+func (tp *TerminalPrompter) Prompt(ctx context.Context, p string) (string, error) {
 	fmt.Fprintf(tp.w, "%s\n\nreply>", p)
-	b, err := io.ReadAll(tp.r)
-	if err != nil {
-		return "", err
+	type reply struct {
+		b   []byte
+		err error
+	}
+	replies := make(chan reply, 1)
+	go func() {
+		b, err := io.ReadAll(tp.r)
+		replies <- reply{b: b, err: err}
+	}()
+	select {
+	case r := <-replies:
+		if r.err != nil {
+			return "", r.err
+		}
+		return string(r.b), nil
+	case <-ctx.Done():
+		return "", ctx.Err()
 	}
-	return string(b), nil
 }
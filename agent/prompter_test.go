@@ -1,6 +1,7 @@
 package agent_test
 
 import (
+	"context"
 	"errors"
 	"io"
 	"strings"
@@ -25,19 +26,32 @@ var _ = Describe("Prompter", func() {
 	})
 
 	It("prompts the user", func() {
-		p, err := tp.Prompt("some prompt")
+		p, err := tp.Prompt(context.Background(), "some prompt")
 		Expect(err).ToNot(HaveOccurred())
 		Eventually(w).Should(gbytes.Say("some prompt\n\nreply>"))
 		Expect(p).To(Equal("A response from the user"))
 	})
 
+	Context("when the context is done before the user replies", func() {
+		BeforeEach(func() {
+			r, _ = io.Pipe()
+			tp = agent.NewTerminalPrompter(r, w)
+		})
+		It("returns the error of the context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := tp.Prompt(ctx, "some prompt")
+			Expect(err).To(MatchError(context.Canceled))
+		})
+	})
+
 	Context("when there is an error reading the prompt", func() {
 		BeforeEach(func() {
 			r = &erroringReader{}
 			tp = agent.NewTerminalPrompter(r, w)
 		})
 		It("errors", func() {
-			_, err := tp.Prompt("some prompt")
+			_, err := tp.Prompt(context.Background(), "some prompt")
 			Expect(err).To(MatchError("an error"))
 		})
 	})
@@ -0,0 +1,350 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxSymlinks is the number of symlinks that may be followed while resolving
+// a single path.
+const maxSymlinks = 40
+
+// errSymlinkEscape is returned when a path leads outside of its directory
+// through a symlink.
+var errSymlinkEscape = errors.New("path leads outside of the directory through a symlink")
+
+// walkRoot resolves the local path within root and calls final with an open
+// file descriptor for the directory holding the last component of the path
+// and its name. It returns the resolved path relative to root.
+//
+// Each component is opened relative to the directory before it without
+// following symlinks, so a symlink swapped in while the path is being
+// resolved cannot redirect it. Symlinks are instead read and resolved within
+// root, erroring if they lead outside of it. Symlinks in the last component
+// are resolved when final fails with ELOOP, which openat returns for
+// symlinks opened with O_NOFOLLOW. Missing directories are created when
+// mkdirs is true.
+func walkRoot(root, path string, mkdirs bool, final func(dirfd int, name string) error) (string, error) {
+	if !filepath.IsLocal(path) {
+		return "", fmt.Errorf("path is not a local path: %q", path)
+	}
+	rootfd, err := unix.Open(root, unix.O_DIRECTORY|unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return "", &os.PathError{Op: "open", Path: root, Err: err}
+	}
+	defer unix.Close(rootfd)
+
+	dirfd := rootfd
+	setDir := func(fd int) {
+		if dirfd != rootfd {
+			unix.Close(dirfd)
+		}
+		dirfd = fd
+	}
+	defer setDir(rootfd)
+
+	var resolved []string
+	pending := splitPath(path)
+	links := 0
+	for {
+		name := "."
+		if len(pending) > 0 {
+			name, pending = pending[0], pending[1:]
+		}
+		switch {
+		case name == "." && len(pending) > 0:
+			continue
+		case name == "..":
+			if len(resolved) == 0 {
+				return "", fmt.Errorf("%w: %q", errSymlinkEscape, path)
+			}
+			resolved = resolved[:len(resolved)-1]
+			fd, err := openDirs(rootfd, resolved)
+			if err != nil {
+				return "", &os.PathError{Op: "open", Path: path, Err: err}
+			}
+			setDir(fd)
+			if len(pending) > 0 {
+				continue
+			}
+			name = "."
+		}
+
+		if len(pending) == 0 {
+			err = final(dirfd, name)
+			if err == nil {
+				return filepath.Join(append(resolved, name)...), nil
+			}
+		} else {
+			var fd int
+			fd, err = openDir(dirfd, name)
+			if errors.Is(err, unix.ENOENT) && mkdirs {
+				if err = unix.Mkdirat(dirfd, name, 0700); err == nil || errors.Is(err, unix.EEXIST) {
+					fd, err = openDir(dirfd, name)
+				}
+			}
+			if err == nil {
+				setDir(fd)
+				resolved = append(resolved, name)
+				continue
+			}
+		}
+
+		if !errors.Is(err, unix.ELOOP) && !errors.Is(err, unix.ENOTDIR) {
+			return "", &os.PathError{Op: "open", Path: path, Err: err}
+		}
+		target, linkErr := readlinkat(dirfd, name)
+		if linkErr != nil {
+			return "", &os.PathError{Op: "open", Path: path, Err: err}
+		}
+		if links++; links > maxSymlinks {
+			return "", &os.PathError{Op: "open", Path: path, Err: unix.ELOOP}
+		}
+		if filepath.IsAbs(target) {
+			target, err = rootRelative(root, target)
+			if err != nil {
+				return "", fmt.Errorf("%w: %q", errSymlinkEscape, path)
+			}
+			resolved = nil
+			setDir(rootfd)
+		}
+		pending = append(splitPath(target), pending...)
+	}
+}
+
+// splitPath splits a path into its components.
+func splitPath(path string) []string {
+	return strings.Split(filepath.ToSlash(path), "/")
+}
+
+func openDir(dirfd int, name string) (int, error) {
+	return unix.Openat(dirfd, name, unix.O_DIRECTORY|unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+}
+
+// openDirs opens the directory at the resolved components beneath rootfd
+// one component at a time.
+func openDirs(rootfd int, components []string) (int, error) {
+	fd, err := openDir(rootfd, ".")
+	for _, c := range components {
+		if err != nil {
+			return -1, err
+		}
+		var next int
+		next, err = openDir(fd, c)
+		unix.Close(fd)
+		fd = next
+	}
+	return fd, err
+}
+
+func readlinkat(dirfd int, name string) (string, error) {
+	b := make([]byte, unix.PathMax)
+	n, err := unix.Readlinkat(dirfd, name, b)
+	if err != nil {
+		return "", err
+	}
+	return string(b[:n]), nil
+}
+
+// rootRelative returns the absolute symlink target relative to root, or
+// errors if it is not within root.
+func rootRelative(root, target string) (string, error) {
+	real, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	real, err = filepath.Abs(real)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(real, target)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", errSymlinkEscape
+	}
+	return rel, nil
+}
+
+// openInRoot opens the file at the local path within root like os.OpenFile,
+// without following symlinks that lead outside of root. Missing parent
+// directories are created when flag includes os.O_CREATE.
+func openInRoot(root, path string, flag int, perm os.FileMode) (*os.File, error) {
+	var f *os.File
+	_, err := walkRoot(root, path, flag&os.O_CREATE != 0, func(dirfd int, name string) error {
+		fd, err := unix.Openat(dirfd, name, flag|unix.O_NOFOLLOW|unix.O_CLOEXEC, uint32(perm.Perm()))
+		if err != nil {
+			return err
+		}
+		f = os.NewFile(uintptr(fd), filepath.Join(root, path))
+		return nil
+	})
+	return f, err
+}
+
+// openDirInRoot opens the directory at the local path within root without
+// following symlinks that lead outside of root. It also returns the resolved
+// path relative to root.
+func openDirInRoot(root, path string) (*os.File, string, error) {
+	var f *os.File
+	resolved, err := walkRoot(root, path, false, func(dirfd int, name string) error {
+		fd, err := openDir(dirfd, name)
+		if err != nil {
+			return err
+		}
+		f = os.NewFile(uintptr(fd), filepath.Join(root, path))
+		return nil
+	})
+	return f, resolved, err
+}
+
+// openDirAt opens the directory name within the open directory d without
+// following a symlink.
+func openDirAt(d *os.File, name string) (*os.File, error) {
+	path := filepath.Join(d.Name(), name)
+	fd, err := openDir(int(d.Fd()), name)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// openFileAt opens the regular file name within the open directory d for
+// reading without following a symlink.
+func openFileAt(d *os.File, name string) (*os.File, error) {
+	path := filepath.Join(d.Name(), name)
+	fd, err := unix.Openat(int(d.Fd()), name, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	f := os.NewFile(uintptr(fd), path)
+	if fi, err := f.Stat(); err != nil || !fi.Mode().IsRegular() {
+		f.Close()
+		return nil, &os.PathError{Op: "open", Path: path, Err: unix.EINVAL}
+	}
+	return f, nil
+}
+
+// sizeAt returns the size of the file name within the open directory d,
+// without following a symlink.
+func sizeAt(d *os.File, name string) (int64, error) {
+	var st unix.Stat_t
+	if err := unix.Fstatat(int(d.Fd()), name, &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return 0, err
+	}
+	return st.Size, nil
+}
+
+// readFileInRoot returns the content and permissions of the file at the
+// local path within root.
+func readFileInRoot(root, path string) ([]byte, os.FileMode, error) {
+	f, err := openInRoot(root, path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if fi.IsDir() {
+		return nil, 0, fmt.Errorf("%q is a directory", path)
+	}
+	b, err := io.ReadAll(f)
+	return b, fi.Mode().Perm(), err
+}
+
+// writeFileInRoot atomically replaces the file at the local path within root
+// with data, creating its parent directories if they do not exist.
+//
+// The data is written to a temporary file in the same directory, synced and
+// renamed over the file, so the file is never left partially written. An
+// existing file keeps its permissions while a new file is created with
+// perm. Execute permission is added for everyone who can read the file when
+// executable is true.
+func writeFileInRoot(root, path string, data []byte, perm os.FileMode, executable bool) error {
+	return replaceInRoot(root, path, data, func(existing os.FileMode, exists bool) os.FileMode {
+		p := perm
+		if exists {
+			p = existing
+		}
+		if executable {
+			p |= (p & 0444) >> 2
+		}
+		return p
+	})
+}
+
+// restoreFileInRoot atomically replaces the file at the local path within
+// root with data and sets its permissions to perm, whether or not it exists.
+func restoreFileInRoot(root, path string, data []byte, perm os.FileMode) error {
+	return replaceInRoot(root, path, data, func(os.FileMode, bool) os.FileMode {
+		return perm
+	})
+}
+
+// replaceInRoot atomically replaces the file at the local path within root
+// with data, giving it the permissions returned by perm for the permissions
+// of the existing file, if there is one.
+func replaceInRoot(root, path string, data []byte, perm func(existing os.FileMode, exists bool) os.FileMode) error {
+	_, err := walkRoot(root, path, true, func(dirfd int, name string) error {
+		var st unix.Stat_t
+		err := unix.Fstatat(dirfd, name, &st, unix.AT_SYMLINK_NOFOLLOW)
+		switch {
+		case err == nil && st.Mode&unix.S_IFMT == unix.S_IFLNK:
+			// Replace the target of the symlink rather than the symlink.
+			return unix.ELOOP
+		case err == nil && st.Mode&unix.S_IFMT != unix.S_IFREG:
+			return unix.EISDIR
+		case err != nil && !errors.Is(err, unix.ENOENT):
+			return err
+		}
+		return writeAtomic(dirfd, name, data, perm(os.FileMode(st.Mode).Perm(), err == nil))
+	})
+	return err
+}
+
+// writeAtomic writes data to a temporary file in the directory dirfd and
+// renames it to name.
+func writeAtomic(dirfd int, name string, data []byte, perm os.FileMode) error {
+	tmp := fmt.Sprintf(".%s.%x.tmp", name, rand.Uint64())
+	fd, err := unix.Openat(dirfd, tmp, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC, uint32(perm))
+	if err != nil {
+		return err
+	}
+	f := os.NewFile(uintptr(fd), tmp)
+	err = func() error {
+		defer f.Close()
+		if _, err := f.Write(data); err != nil {
+			return err
+		}
+		// The permissions given to openat are reduced by the umask.
+		if err := f.Chmod(perm); err != nil {
+			return err
+		}
+		return f.Sync()
+	}()
+	if err == nil {
+		err = unix.Renameat(dirfd, tmp, dirfd, name)
+	}
+	if err != nil {
+		unix.Unlinkat(dirfd, tmp, 0)
+		return err
+	}
+	// Sync the directory so that the rename survives a crash.
+	return unix.Fsync(dirfd)
+}
+
+// removeInRoot removes the file at the local path within root. A symlink is
+// removed rather than its target.
+func removeInRoot(root, path string) error {
+	_, err := walkRoot(root, path, false, func(dirfd int, name string) error {
+		return unix.Unlinkat(dirfd, name, 0)
+	})
+	return err
+}
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+)
+
+// ErrSandboxUnsupported is returned when commands cannot be sandboxed on
+// this platform.
+var ErrSandboxUnsupported = errors.New("sandboxing is not supported on this platform")
+
+// sandboxCacheEnvironment points the caches of tools such as go, which would
+// otherwise be written beneath the read-only home directory, at the private
+// /tmp of each command.
+var sandboxCacheEnvironment = []string{
+	"GOCACHE=/tmp/.cache/go-build",
+	"GOMODCACHE=/tmp/go/pkg/mod",
+	"XDG_CACHE_HOME=/tmp/.cache",
+}
+
+// A SandboxExecutor executes bash commands isolated from the rest of the
+// system with unprivileged Linux namespaces.
+//
+// Each command runs in new user, mount and PID namespaces. The output
+// directory is the only writable bind mount, the rest of the root filesystem
+// is remounted read-only and /tmp is replaced with an empty tmpfs, where the
+// go build and module caches are kept. The command runs as a non-root user
+// without capabilities, so it cannot remount the filesystem writable. Unless
+// the network is enabled with WithNetwork the command also runs in a new
+// network namespace with no interfaces up.
+type SandboxExecutor struct {
+	logger *slog.Logger
+	dir    string
+	config execConfig
+}
+
+// WithNetwork allows the commands of a SandboxExecutor to access the
+// network.
+func WithNetwork() ExecOption {
+	return func(c *execConfig) {
+		c.network = true
+	}
+}
+
+// NewSandboxExecutor creates a SandboxExecutor.
+// The command executes in the working directory specified with dir, which is
+// the only directory it can write to.
+func NewSandboxExecutor(logger *slog.Logger, dir string, opts ...ExecOption) *SandboxExecutor {
+	return &SandboxExecutor{logger: logger, dir: dir, config: newExecConfig(opts)}
+}
+
+// Execute runs the bash command represented by cmd in the sandbox and
+// returns its result as well as any error. A non-zero exit code is returned
+// as an error.
+//
+// If ctx is done before the command exits every process in the sandbox is
+// killed and the output captured so far is returned. The error wraps
+// ErrCommandTimedOut if the deadline was exceeded, or is a
+// LimitExceededError if the command exceeded one of its limits.
+func (s *SandboxExecutor) Execute(ctx context.Context, cmd string) (CommandResult, error) {
+	s.logger.Info("executing sandboxed command", "command", cmd, "network", s.config.network)
+	return s.run(ctx, []string{s.config.shell, "-c", s.config.limits.ulimits() + cmd}, true)
+}
+
+// RunProgram runs the program named by argv[0] in the sandbox with the
+// arguments in the rest of argv and returns its result as well as any
+// error. The arguments are passed to the program as they are, without being
+// parsed by a shell. A non-zero exit code is returned as an error.
+func (s *SandboxExecutor) RunProgram(ctx context.Context, argv []string) (CommandResult, error) {
+	if len(argv) == 0 {
+		return CommandResult{}, errEmptyArgv
+	}
+	s.logger.Info("running sandboxed program", "argv", argv, "network", s.config.network)
+	return s.run(ctx, programArgv(s.config.shell, s.config.limits, argv), false)
+}
+
+// run runs argv in the sandbox. shell is whether argv runs a shell command
+// rather than a program.
+func (s *SandboxExecutor) run(ctx context.Context, argv []string, shell bool) (CommandResult, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.timeout)
+		defer cancel()
+	}
+
+	dir, err := filepath.Abs(s.dir)
+	if err == nil {
+		dir, err = filepath.EvalSymlinks(dir)
+	}
+	if err != nil {
+		return CommandResult{}, err
+	}
+	c, err := sandboxCommand(ctx, dir, s.config.network, s.config.shell, argv)
+	if err != nil {
+		return CommandResult{}, err
+	}
+	c.Env = append(s.config.env.environ(), sandboxCacheEnvironment...)
+	killProcessGroup(c)
+	applyLimits(c, s.config.limits)
+
+	before := s.config.limits.snapshot()
+	r, err := runCommand(c, s.config.stream)
+	logResult(s.logger, r)
+	return r, s.config.limits.check(before, contextError(ctx, err), shell)
+}
+
+// Check runs an empty command in the sandbox to confirm that the namespaces
+// and mounts it needs can be created.
+func (s *SandboxExecutor) Check() error {
+	o, err := s.Execute(context.Background(), "true")
+	if err != nil {
+		return fmt.Errorf("sandbox check failed: %w: %s", err, strings.TrimSpace(o.Output()))
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// sandboxSetup runs as root in the new user namespace to prepare the mount
+// namespace before replacing itself with the program in its remaining
+// arguments. The output directory is held open while /tmp is replaced so
+// that it can be bound back into place even when it lives under /tmp.
+//
+// The program runs in a nested user and mount namespace as the non-root user
+// and group given after the directory, so that it has no capabilities and
+// the read-only mounts are locked and cannot be remounted writable.
+const sandboxSetup = `set -eu
+dir=$1
+uid=$2
+gid=$3
+shift 3
+mount --make-rprivate /
+mount -t proc proc /proc
+exec 3<"$dir"
+mount -t tmpfs tmpfs /tmp
+mkdir -p "$dir"
+mount --no-canonicalize --bind /proc/self/fd/3 "$dir"
+exec 3<&-
+while read -r _ _ _ _ mp opts _; do
+	mp=$(printf '%b' "$mp")
+	case "$mp" in "$dir"|/tmp|/proc) continue;; esac
+	case ",$opts," in *,ro,*) continue;; esac
+	mount -o remount,bind,ro "$mp"
+done </proc/self/mountinfo
+cd "$dir"
+exec unshare --user --mount --map-user="$uid" --map-group="$gid" -- "$@"
+`
+
+// sandboxID is the user and group ID that sandboxed commands run as when
+// minimalprompt runs as root, as they must not be root in the sandbox.
+const sandboxID = 1000
+
+// sandboxUser returns id, or sandboxID if id is root.
+func sandboxUser(id int) string {
+	if id == 0 {
+		id = sandboxID
+	}
+	return strconv.Itoa(id)
+}
+
+func sandboxCommand(ctx context.Context, dir string, network bool, shell string, argv []string) (*exec.Cmd, error) {
+	c := exec.CommandContext(ctx, shell, append([]string{"-c", sandboxSetup, "sandbox", dir, sandboxUser(os.Getuid()), sandboxUser(os.Getgid())}, argv...)...)
+	c.Dir = dir
+	flags := syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID
+	if !network {
+		flags |= syscall.CLONE_NEWNET
+	}
+	c.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  uintptr(flags),
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+	}
+	return c, nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package agent
+
+import (
+	"context"
+	"os/exec"
+)
+
+func sandboxCommand(ctx context.Context, dir string, network bool, shell string, argv []string) (*exec.Cmd, error) {
+	return nil, ErrSandboxUnsupported
+}
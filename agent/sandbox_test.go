@@ -0,0 +1,181 @@
+package agent_test
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/acrmp/minimalprompt/agent"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("SandboxExecutor", func() {
+	var (
+		dir       string
+		opts      []agent.ExecOption
+		sandbox   *agent.SandboxExecutor
+		logger    *slog.Logger
+		logOutput *gbytes.Buffer
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "sandbox")
+		Expect(err).ToNot(HaveOccurred())
+
+		logOutput = gbytes.NewBuffer()
+		logger = slog.New(slog.NewTextHandler(logOutput, nil))
+		opts = nil
+	})
+
+	JustBeforeEach(func() {
+		sandbox = agent.NewSandboxExecutor(logger, dir, opts...)
+		if err := sandbox.Check(); err != nil {
+			Skip(err.Error())
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	const interfaces = `awk -F: 'NR > 2 { gsub(/ /, "", $1); print $1 }' /proc/net/dev`
+
+	execute := func(command string) (string, error) {
+		r, err := sandbox.Execute(context.Background(), command)
+		return r.Output(), err
+	}
+
+	It("executes the provided command in the directory", func() {
+		output, err := execute("printf 'hello world' > some-file; cat some-file")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output).To(Equal("hello world"))
+
+		b, err := os.ReadFile(filepath.Join(dir, "some-file"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(b)).To(Equal("hello world"))
+	})
+
+	It("logs that it is executing the command", func() {
+		_, err := execute("printf 'hello world'")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(logOutput).To(gbytes.Say(`executing sandboxed command.*printf 'hello world'`))
+	})
+
+	It("can read but not write outside the directory", func() {
+		outside, err := filepath.Abs("sandbox_test.go")
+		Expect(err).ToNot(HaveOccurred())
+
+		output, err := execute("head -n 1 " + outside)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output).To(Equal("package agent_test\n"))
+
+		escaped := filepath.Join(filepath.Dir(outside), "escaped")
+		output, err = execute("touch " + escaped)
+		Expect(err).To(HaveOccurred())
+		Expect(output).To(ContainSubstring("Read-only file system"))
+		Expect(escaped).ToNot(BeAnExistingFile())
+	})
+
+	It("runs commands without root privileges", func() {
+		output, err := execute("id -u; grep CapEff /proc/self/status")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output).ToNot(HavePrefix("0\n"))
+		Expect(output).To(ContainSubstring("CapEff:\t0000000000000000\n"))
+	})
+
+	It("cannot remount the filesystem to write outside the directory", func() {
+		escaped, err := filepath.Abs("escaped")
+		Expect(err).ToNot(HaveOccurred())
+		remount := `mount -o remount,bind,rw "$(findmnt -n -o TARGET -T .)" && touch escaped`
+
+		for _, command := range []string{
+			remount,
+			"unshare -Urm sh -c '" + remount + "'",
+		} {
+			output, err := execute("cd " + filepath.Dir(escaped) + " && " + command)
+			Expect(err).To(HaveOccurred(), output)
+			Expect(escaped).ToNot(BeAnExistingFile())
+		}
+	})
+
+	It("provides a private /tmp", func() {
+		f, err := os.CreateTemp("/tmp", "host-only")
+		Expect(err).ToNot(HaveOccurred())
+		f.Close()
+		defer os.Remove(f.Name())
+
+		output, err := execute("touch /tmp/scratch && ls -A /tmp")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output).To(ContainSubstring("scratch\n"))
+		Expect(output).ToNot(ContainSubstring(filepath.Base(f.Name())))
+		Expect("/tmp/scratch").ToNot(BeAnExistingFile())
+	})
+
+	It("keeps the go caches in the private /tmp", func() {
+		output, err := execute("echo $GOCACHE $GOMODCACHE $XDG_CACHE_HOME")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output).To(Equal("/tmp/.cache/go-build /tmp/go/pkg/mod /tmp/.cache\n"))
+	})
+
+	It("cannot see processes outside the sandbox", func() {
+		output, err := execute("echo $$; ls /proc | grep -c '^[0-9]'")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output).To(HavePrefix("1\n"))
+	})
+
+	It("has no network", func() {
+		output, err := execute(interfaces)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(output).To(Equal("lo\n"))
+	})
+
+	It("runs programs in the sandbox without a shell", func() {
+		r, err := sandbox.RunProgram(context.Background(), []string{"sh", "-c", `printf '%s|' "$0" "$$"; touch "$1"`, "a b", "some file"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Stdout).To(Equal("a b|1|"))
+		Expect(filepath.Join(dir, "some file")).To(BeAnExistingFile())
+	})
+
+	Context("when the network is enabled", func() {
+		BeforeEach(func() {
+			opts = append(opts, agent.WithNetwork())
+		})
+
+		It("shares the network of the host", func() {
+			host, err := exec.Command("bash", "-c", interfaces).Output()
+			Expect(err).ToNot(HaveOccurred())
+
+			output, err := execute(interfaces)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(output).To(Equal(string(host)))
+		})
+	})
+
+	Context("when the command exits with a non-zero exit code", func() {
+		It("errors", func() {
+			o, err := execute("printf 'still captured'; exit 3")
+			Expect(err).To(MatchError("exit status 3"))
+			Expect(o).To(Equal("still captured"))
+		})
+	})
+
+	Context("when the command does not finish before the deadline", func() {
+		BeforeEach(func() {
+			opts = append(opts, agent.WithTimeout(500*time.Millisecond))
+		})
+
+		It("kills every process in the sandbox", func() {
+			output, err := execute("(sleep 1; touch late-file) & echo started; sleep 30")
+			Expect(err).To(MatchError(agent.ErrCommandTimedOut))
+			Expect(output).To(Equal("started\n"))
+
+			Consistently(filepath.Join(dir, "late-file"), 2*time.Second).ShouldNot(BeAnExistingFile())
+		})
+	})
+})
@@ -0,0 +1,184 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// A Schema is the subset of JSON Schema used to describe tool parameters.
+type Schema struct {
+	Type        string             `json:"type,omitempty" yaml:"type"`
+	Description string             `json:"description,omitempty" yaml:"description"`
+	Properties  map[string]*Schema `json:"properties,omitempty" yaml:"properties"`
+	Required    []string           `json:"required,omitempty" yaml:"required"`
+	Items       *Schema            `json:"items,omitempty" yaml:"items"`
+	Enum        []any              `json:"enum,omitempty" yaml:"enum"`
+}
+
+// SchemaFor derives the schema of the JSON encoding of type T.
+//
+// Struct fields are named by their json tag and are required unless the tag
+// includes omitempty. The description tag describes a field to the model and
+// the enum tag lists the comma separated values a string field may take:
+//
+//	Mode string `json:"mode,omitempty" description:"How to open the file" enum:"read,write"`
+func SchemaFor[T any]() *Schema {
+	return schemaOf(reflect.TypeFor[T]())
+}
+
+func schemaOf(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object"}
+	case reflect.Struct:
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			p := schemaOf(f.Type)
+			p.Description = f.Tag.Get("description")
+			if e := f.Tag.Get("enum"); e != "" {
+				for _, v := range strings.Split(e, ",") {
+					p.Enum = append(p.Enum, v)
+				}
+			}
+			s.Properties[name] = p
+			if !slices.Contains(strings.Split(opts, ","), "omitempty") {
+				s.Required = append(s.Required, name)
+			}
+		}
+		return s
+	}
+	return &Schema{}
+}
+
+// Validate checks that v, a value decoded from JSON, conforms to the schema.
+// All violations are reported together so that they can be corrected at
+// once.
+func (s *Schema) Validate(v any) error {
+	var errs []error
+	s.validate("", v, &errs)
+	return errors.Join(errs...)
+}
+
+func (s *Schema) validate(path string, v any, errs *[]error) {
+	at := "arguments"
+	if path != "" {
+		at = fmt.Sprintf("property %q", path)
+	}
+	if v == nil {
+		if s.Type != "" {
+			*errs = append(*errs, fmt.Errorf("%s: expected %s, got null", at, s.Type))
+		}
+		return
+	}
+	if s.Type != "" && jsonType(v, s.Type) != s.Type {
+		*errs = append(*errs, fmt.Errorf("%s: expected %s, got %s", at, s.Type, jsonType(v, s.Type)))
+		return
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return fmt.Sprint(e) == fmt.Sprint(v) }) {
+		*errs = append(*errs, fmt.Errorf("%s: %v is not one of %v", at, v, s.Enum))
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, r := range s.Required {
+			if _, ok := v[r]; !ok {
+				*errs = append(*errs, fmt.Errorf("missing required property %q", propertyPath(path, r)))
+			}
+		}
+		names := make([]string, 0, len(v))
+		for n := range v {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			if p, ok := s.Properties[n]; ok {
+				p.validate(propertyPath(path, n), v[n], errs)
+			}
+		}
+	case []any:
+		if s.Items != nil {
+			for i, e := range v {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), e, errs)
+			}
+		}
+	}
+}
+
+func propertyPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// jsonType returns the JSON Schema type of v. Whole numbers are reported as
+// integers when an integer is wanted.
+func jsonType(v any, want string) string {
+	switch v := v.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if want == "integer" && v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// parametersSchema returns the schema of tool parameters, converting
+// hand-written parameter maps through their JSON encoding.
+func parametersSchema(parameters any) (*Schema, error) {
+	switch p := parameters.(type) {
+	case nil:
+		return nil, nil
+	case *Schema:
+		return p, nil
+	}
+	b, err := json.Marshal(parameters)
+	if err != nil {
+		return nil, err
+	}
+	s := &Schema{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,99 @@
+package agent_test
+
+import (
+	"encoding/json"
+
+	"github.com/acrmp/minimalprompt/agent"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Schema", func() {
+	type position struct {
+		Line int `json:"line"`
+	}
+	type args struct {
+		Path      string     `json:"path" description:"The path"`
+		Mode      string     `json:"mode,omitempty" enum:"read,write"`
+		Lines     []int      `json:"lines,omitempty"`
+		Ratio     float64    `json:"ratio,omitempty"`
+		Force     bool       `json:"force,omitempty"`
+		At        *position  `json:"at,omitempty"`
+		Positions []position `json:"positions,omitempty"`
+		Ignored   string     `json:"-"`
+		internal  string
+	}
+
+	var schema *agent.Schema
+
+	validate := func(arguments string) error {
+		var v any
+		Expect(json.Unmarshal([]byte(arguments), &v)).To(Succeed())
+		return schema.Validate(v)
+	}
+
+	BeforeEach(func() {
+		schema = agent.SchemaFor[args]()
+	})
+
+	It("derives the schema from the struct tags", func() {
+		Expect(schema).To(Equal(&agent.Schema{
+			Type: "object",
+			Properties: map[string]*agent.Schema{
+				"path":  {Type: "string", Description: "The path"},
+				"mode":  {Type: "string", Enum: []any{"read", "write"}},
+				"lines": {Type: "array", Items: &agent.Schema{Type: "integer"}},
+				"ratio": {Type: "number"},
+				"force": {Type: "boolean"},
+				"at": {
+					Type:       "object",
+					Properties: map[string]*agent.Schema{"line": {Type: "integer"}},
+					Required:   []string{"line"},
+				},
+				"positions": {
+					Type: "array",
+					Items: &agent.Schema{
+						Type:       "object",
+						Properties: map[string]*agent.Schema{"line": {Type: "integer"}},
+						Required:   []string{"line"},
+					},
+				},
+			},
+			Required: []string{"path"},
+		}))
+	})
+
+	It("accepts valid arguments", func() {
+		Expect(validate(`{"path":"main.go","mode":"read","lines":[1,2],"ratio":0.5,"force":true,"at":{"line":3}}`)).To(Succeed())
+	})
+
+	It("accepts whole numbers as numbers", func() {
+		Expect(validate(`{"path":"main.go","ratio":1}`)).To(Succeed())
+	})
+
+	It("rejects missing required properties", func() {
+		Expect(validate(`{"mode":"read"}`)).To(MatchError(`missing required property "path"`))
+	})
+
+	It("rejects properties of the wrong type", func() {
+		Expect(validate(`{"path":7}`)).To(MatchError(`property "path": expected string, got number`))
+		Expect(validate(`{"path":"a","lines":[1.5]}`)).To(MatchError(`property "lines[0]": expected integer, got number`))
+		Expect(validate(`{"path":"a","at":{"line":"three"}}`)).To(MatchError(`property "at.line": expected integer, got string`))
+		Expect(validate(`{"path":null}`)).To(MatchError(`property "path": expected string, got null`))
+	})
+
+	It("rejects values not in the enum", func() {
+		Expect(validate(`{"path":"a","mode":"append"}`)).To(MatchError(`property "mode": append is not one of [read write]`))
+	})
+
+	It("rejects arguments that are not an object", func() {
+		Expect(validate(`["main.go"]`)).To(MatchError(`arguments: expected object, got array`))
+	})
+
+	It("reports every violation", func() {
+		err := validate(`{"mode":"append","force":"yes"}`)
+		Expect(err).To(MatchError(ContainSubstring(`missing required property "path"`)))
+		Expect(err).To(MatchError(ContainSubstring(`property "force": expected boolean, got string`)))
+		Expect(err).To(MatchError(ContainSubstring(`property "mode": append is not one of [read write]`)))
+	})
+})
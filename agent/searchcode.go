@@ -0,0 +1,189 @@
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// defaultSearchResults is the number of matches returned when no limit is
+	// requested.
+	defaultSearchResults = 50
+	// maxSearchResults is the maximum number of matches in a single search.
+	maxSearchResults = 200
+	// maxSearchContext is the maximum number of context lines around a match.
+	maxSearchContext = 10
+	// maxSearchLineLength is the length at which matching lines are cut short.
+	maxSearchLineLength = 200
+)
+
+// errSearchCapped stops the directory walk once enough matches are found.
+var errSearchCapped = errors.New("search capped")
+
+// SearchCode searches the files within the directory for lines matching the
+// regular expression pattern. When glob is not empty only files matching it
+// are searched; globs without a slash match the file name and globs with a
+// slash match the relative path. Matching lines are returned as
+// path:line:text with context lines before and after each match shown as
+// path-line-text. At most maxResults matches are returned.
+// Binary files and paths excluded by .gitignore files are skipped.
+// It errors if the pattern or glob are invalid or if there is an IO error.
+func (fr *SimpleFileReader) SearchCode(pattern, glob string, context, maxResults int) (string, error) {
+	fr.logger.Info("searching code", "pattern", pattern, "glob", glob)
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern: %w", err)
+	}
+	var globRe *regexp.Regexp
+	if glob != "" {
+		if globRe, err = regexp.Compile("^" + globToRegexp(strings.TrimPrefix(glob, "/")) + "$"); err != nil {
+			return "", fmt.Errorf("invalid glob: %w", err)
+		}
+	}
+	context = max(0, min(context, maxSearchContext))
+	if maxResults <= 0 {
+		maxResults = defaultSearchResults
+	}
+	maxResults = min(maxResults, maxSearchResults)
+
+	d, _, err := openDirInRoot(fr.dir, ".")
+	if err != nil {
+		return "", err
+	}
+	defer d.Close()
+	s := &searcher{root: fr.dir, re: re, glob: glob, globRe: globRe, context: context, maxResults: maxResults}
+	s.ignore.load(fr.dir, ".")
+	err = s.walk(d, ".")
+	if err != nil && !errors.Is(err, errSearchCapped) {
+		return "", err
+	}
+
+	if s.matches == 0 {
+		return "No matches found\n", nil
+	}
+	if errors.Is(err, errSearchCapped) {
+		fmt.Fprintf(&s.b, "[results capped at %d matches, use a more specific pattern or glob to see more]\n", maxResults)
+	}
+	return s.b.String(), nil
+}
+
+type searcher struct {
+	root       string
+	re         *regexp.Regexp
+	glob       string
+	globRe     *regexp.Regexp
+	context    int
+	maxResults int
+	ignore     gitignore
+	b          strings.Builder
+	matches    int
+}
+
+// walk searches the files within the open directory d at rel, opening each
+// entry relative to d without following symlinks.
+func (s *searcher) walk(d *os.File, rel string) error {
+	des, err := d.ReadDir(-1)
+	if err != nil {
+		return err
+	}
+	sort.Slice(des, func(i, j int) bool { return des[i].Name() < des[j].Name() })
+	for _, de := range des {
+		child := path.Join(rel, de.Name())
+		if s.ignore.ignored(child, de.IsDir()) {
+			continue
+		}
+		if de.IsDir() {
+			s.ignore.load(s.root, child)
+			sub, err := openDirAt(d, de.Name())
+			if err != nil {
+				return err
+			}
+			err = s.walk(sub, child)
+			sub.Close()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !de.Type().IsRegular() || !s.globMatches(child) {
+			continue
+		}
+		if err := s.searchFile(d, de.Name(), child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// globMatches reports whether the file at rel matches the glob, if any.
+func (s *searcher) globMatches(rel string) bool {
+	if s.globRe == nil {
+		return true
+	}
+	target := path.Base(rel)
+	if strings.Contains(s.glob, "/") {
+		target = rel
+	}
+	return s.globRe.MatchString(target)
+}
+
+func (s *searcher) searchFile(d *os.File, name, rel string) error {
+	f, err := openFileAt(d, name)
+	if err != nil {
+		return err
+	}
+	content, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if bytes.IndexByte(content[:min(len(content), 8000)], 0) >= 0 {
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	shown := -1
+	for i, line := range lines {
+		if !s.re.MatchString(line) {
+			continue
+		}
+		if s.matches == s.maxResults {
+			return errSearchCapped
+		}
+		s.matches++
+
+		from := max(i-s.context, shown+1)
+		if s.context > 0 && s.b.Len() > 0 && from > shown+1 {
+			s.b.WriteString("--\n")
+		}
+		for j := from; j < i; j++ {
+			s.writeLine(rel, j, '-', lines[j])
+		}
+		if i > shown {
+			s.writeLine(rel, i, ':', line)
+			shown = i
+		}
+		for j := i + 1; j <= min(i+s.context, len(lines)-1); j++ {
+			if s.re.MatchString(lines[j]) {
+				break
+			}
+			s.writeLine(rel, j, '-', lines[j])
+			shown = j
+		}
+	}
+	return nil
+}
+
+func (s *searcher) writeLine(rel string, i int, sep byte, line string) {
+	if len(line) > maxSearchLineLength {
+		line = line[:maxSearchLineLength] + "..."
+	}
+	fmt.Fprintf(&s.b, "%s%c%d%c%s\n", rel, sep, i+1, sep, line)
+}
@@ -0,0 +1,120 @@
+package agent_test
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/acrmp/minimalprompt/agent"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("SearchCode", func() {
+	var (
+		dir       string
+		fr        agent.FileReader
+		logger    *slog.Logger
+		logOutput *gbytes.Buffer
+	)
+
+	writeFile := func(path, content string) {
+		p := filepath.Join(dir, path)
+		Expect(os.MkdirAll(filepath.Dir(p), 0700)).To(Succeed())
+		Expect(os.WriteFile(p, []byte(content), 0600)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+
+		logOutput = gbytes.NewBuffer()
+		logger = slog.New(slog.NewTextHandler(logOutput, nil))
+
+		dir, err = os.MkdirTemp("", "search")
+		Expect(err).ToNot(HaveOccurred())
+		fr = agent.NewSimpleFileReader(logger, dir)
+
+		writeFile("main.go", "package main\n\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n")
+		writeFile("cmd/app/app.go", "package app\n\nfunc Run() {\n}\n")
+		writeFile("README.md", "Run the app with go run\n")
+		writeFile("vendor/dep/dep.go", "package dep\n\nfunc Run() {}\n")
+		writeFile("image.png", "\x89PNG\x00\x00func Run()")
+	})
+
+	AfterEach(func() {
+		err := os.RemoveAll(dir)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("returns the matching lines with their path and line number", func() {
+		results, err := fr.SearchCode(`func \w+\(`, "", 0, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results).To(Equal("cmd/app/app.go:3:func Run() {\nmain.go:3:func main() {\n"))
+	})
+
+	It("does not follow symlinks out of the directory", func() {
+		outside := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(outside, "secret.go"), []byte("func Run() {}\n"), 0600)).To(Succeed())
+		Expect(os.Symlink(outside, filepath.Join(dir, "home"))).To(Succeed())
+		Expect(os.Symlink(filepath.Join(outside, "secret.go"), filepath.Join(dir, "secret.go"))).To(Succeed())
+
+		results, err := fr.SearchCode(`func Run`, "", 0, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results).To(Equal("cmd/app/app.go:3:func Run() {\n"))
+	})
+
+	It("logs that it is searching", func() {
+		_, err := fr.SearchCode("main", "", 0, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(logOutput).To(gbytes.Say(`searching code.*main`))
+	})
+
+	It("includes context lines", func() {
+		results, err := fr.SearchCode(`Println`, "", 1, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results).To(Equal("main.go-3-func main() {\nmain.go:4:\tfmt.Println(\"hello\")\nmain.go-5-}\n"))
+	})
+
+	It("only searches files matching the glob", func() {
+		results, err := fr.SearchCode(`Run`, "*.md", 0, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results).To(Equal("README.md:1:Run the app with go run\n"))
+
+		results, err = fr.SearchCode(`Run`, "cmd/**/*.go", 0, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results).To(Equal("cmd/app/app.go:3:func Run() {\n"))
+	})
+
+	It("skips ignored files", func() {
+		writeFile(".gitignore", "cmd/\n")
+		results, err := fr.SearchCode(`func Run`, "", 0, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results).To(Equal("No matches found\n"))
+	})
+
+	Context("when there are more matches than the cap", func() {
+		BeforeEach(func() {
+			var b strings.Builder
+			for i := 0; i < 10; i++ {
+				fmt.Fprintf(&b, "match %d\n", i)
+			}
+			writeFile("many.txt", b.String())
+		})
+
+		It("caps the results", func() {
+			results, err := fr.SearchCode(`^match`, "", 0, 3)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results).To(Equal("many.txt:1:match 0\nmany.txt:2:match 1\nmany.txt:3:match 2\n[results capped at 3 matches, use a more specific pattern or glob to see more]\n"))
+		})
+	})
+
+	Context("when the pattern is invalid", func() {
+		It("errors", func() {
+			_, err := fr.SearchCode(`func (`, "", 0, 0)
+			Expect(err).To(MatchError(ContainSubstring("invalid pattern")))
+		})
+	})
+})
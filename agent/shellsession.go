@@ -0,0 +1,390 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// errShellExited is returned when the shell exits before a command finishes,
+// for example because the command ran exit.
+var errShellExited = errors.New("the shell exited")
+
+// errShellKilled is returned when the shell is killed because the context
+// of a command is done.
+var errShellKilled = errors.New("signal: killed")
+
+// stderrGrace is how long to wait for the stderr marker after the stdout
+// marker, in case the command redirected the stderr of the shell.
+const stderrGrace = 100 * time.Millisecond
+
+// A ShellSession executes bash commands in a single long-lived shell so that
+// the working directory, environment variables and shell functions carry
+// over from one command to the next.
+//
+// The output of each command is delimited by printing sentinel markers to
+// stdout, followed by the exit status, and to stderr. If the shell dies it is
+// restarted in the original directory before the next command.
+type ShellSession struct {
+	logger *slog.Logger
+	dir    string
+	config execConfig
+	mu     sync.Mutex
+	shell  *shell
+	starts int
+}
+
+// NewShellSession creates a ShellSession.
+// The shell starts in the working directory specified with dir when the
+// first command is executed.
+func NewShellSession(logger *slog.Logger, dir string, opts ...ExecOption) *ShellSession {
+	return &ShellSession{logger: logger, dir: dir, config: newExecConfig(opts)}
+}
+
+// Execute runs the bash command in the shell and returns its result as well
+// as any error. A non-zero exit status is returned as an error.
+//
+// If ctx is done before the command finishes the shell is killed, along
+// with everything it started, and the output captured so far is returned.
+// The error wraps ErrCommandTimedOut if the deadline was exceeded, or is a
+// LimitExceededError if the command exceeded one of its limits.
+func (s *ShellSession) Execute(ctx context.Context, command string) (CommandResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.logger.Info("executing command", "command", command)
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.config.timeout)
+		defer cancel()
+	}
+
+	var notice string
+	if s.shell != nil && s.shell.exited() {
+		s.shell = nil
+	}
+	if s.shell == nil {
+		if s.starts > 0 {
+			s.logger.Warn("restarting shell")
+			notice = "[the shell exited and was restarted, the working directory and environment were reset]\n"
+		}
+		sh, err := startShell(s.config.shell, s.dir, s.config.env, s.config.limits)
+		if err != nil {
+			return CommandResult{}, fmt.Errorf("starting shell: %w", err)
+		}
+		s.shell = sh
+		s.starts++
+	}
+
+	before := s.config.limits.snapshot()
+	sw := newStreamWriter(s.config.stream)
+	start := time.Now()
+	stdout, stderr, err := s.shell.run(ctx, command, sw)
+	sw.finish()
+	r := newCommandResult(stdout, notice+stderr, start, err)
+	logResult(s.logger, r)
+	if err != nil && !errors.As(err, new(exitStatusError)) {
+		s.shell.kill()
+		s.shell = nil
+		err = contextError(ctx, err)
+	}
+	return r, s.config.limits.check(before, err, true)
+}
+
+// Close kills the shell and any processes it started.
+func (s *ShellSession) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shell != nil {
+		s.shell.kill()
+		s.shell = nil
+	}
+	return nil
+}
+
+// An exitStatusError is returned for commands that exit with a non-zero
+// exit status.
+type exitStatusError int
+
+func (e exitStatusError) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+// A shell is a running bash process reading commands from stdin with its
+// stdout and stderr captured to separate buffers.
+type shell struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	marker    string
+	errMarker string
+	mu        sync.Mutex
+	out       bytes.Buffer
+	errOut    bytes.Buffer
+	changed   chan struct{}
+	done      chan struct{}
+	exit      chan struct{}
+}
+
+func startShell(name, dir string, env *Environment, limits *Limits) (*shell, error) {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	// The shell reads commands from a pipe so it is not interactive and
+	// does not read any startup files.
+	c := exec.Command(name)
+	c.Dir = dir
+	c.Env = env.environ()
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	applyLimits(c, limits)
+	stdin, err := c.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, stdoutW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, stderrW, err := os.Pipe()
+	if err != nil {
+		stdout.Close()
+		stdoutW.Close()
+		return nil, err
+	}
+	c.Stdout = stdoutW
+	c.Stderr = stderrW
+	err = c.Start()
+	stdoutW.Close()
+	stderrW.Close()
+	if err != nil {
+		stdout.Close()
+		stderr.Close()
+		return nil, err
+	}
+
+	id := hex.EncodeToString(nonce)
+	sh := &shell{
+		cmd:       c,
+		stdin:     stdin,
+		marker:    "__minimalprompt_" + id + "_out__",
+		errMarker: "__minimalprompt_" + id + "_err__",
+		changed:   make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		exit:      make(chan struct{}),
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go sh.capture(stdout, &sh.out, &wg)
+	go sh.capture(stderr, &sh.errOut, &wg)
+	go func() {
+		wg.Wait()
+		close(sh.done)
+	}()
+	go func() {
+		_ = c.Wait()
+		close(sh.exit)
+	}()
+	if _, err := io.WriteString(stdin, limits.ulimits()); err != nil {
+		sh.kill()
+		return nil, err
+	}
+	return sh, nil
+}
+
+// capture copies output from r into buf until every process holding the
+// pipe has exited.
+func (sh *shell) capture(r *os.File, buf *bytes.Buffer, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer r.Close()
+
+	b := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			sh.mu.Lock()
+			buf.Write(b[:n])
+			sh.mu.Unlock()
+			select {
+			case sh.changed <- struct{}{}:
+			default:
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// run sends the command to the shell and waits for the markers that follow
+// its output, copying the output to sw as it arrives.
+func (sh *shell) run(ctx context.Context, command string, sw *streamWriter) (string, string, error) {
+	quoted := "'" + strings.ReplaceAll(command, "'", `'\''`) + "'"
+	script := fmt.Sprintf("eval %s </dev/null\nprintf '%%s%%d\\n' %s \"$?\"; printf '%%s\\n' %s >&2\n", quoted, sh.marker, sh.errMarker)
+	if _, err := io.WriteString(sh.stdin, script); err != nil {
+		return "", "", errShellExited
+	}
+
+	var streamed [2]int
+	result := func(stdout, stderr string, err error) (string, string, error) {
+		if streamed[0] < len(stdout) {
+			sw.Write([]byte(stdout[streamed[0]:]))
+		}
+		if streamed[1] < len(stderr) {
+			sw.Write([]byte(stderr[streamed[1]:]))
+		}
+		return stdout, stderr, err
+	}
+	status := func(stdout, stderr string, code int) (string, string, error) {
+		if code != 0 {
+			return result(stdout, stderr, exitStatusError(code))
+		}
+		return result(stdout, stderr, nil)
+	}
+
+	var grace <-chan time.Time
+	for {
+		stdout, stderr, code, found, ok := sh.next(false)
+		if ok {
+			return status(stdout, stderr, code)
+		}
+		if found && grace == nil {
+			grace = time.After(stderrGrace)
+		}
+		sh.stream(sw, &streamed)
+		select {
+		case <-sh.changed:
+		case <-grace:
+			stdout, stderr, code, _, _ := sh.next(true)
+			return status(stdout, stderr, code)
+		case <-sh.exit:
+			// Collect any output still in the pipes, unless background
+			// processes are holding them open.
+			select {
+			case <-sh.done:
+			case <-time.After(100 * time.Millisecond):
+			}
+			if stdout, stderr, code, _, ok := sh.next(true); ok {
+				return status(stdout, stderr, code)
+			}
+			stdout, stderr := sh.drain()
+			return result(stdout, stderr, errShellExited)
+		case <-ctx.Done():
+			stdout, stderr := sh.drain()
+			return result(stdout, stderr, errShellKilled)
+		}
+	}
+}
+
+// stream copies the output in the buffers after the offsets to sw, holding
+// back anything that may be the start of a marker, and advances the offsets.
+func (sh *shell) stream(sw *streamWriter, offsets *[2]int) {
+	if sw == nil {
+		return
+	}
+	sh.mu.Lock()
+	var chunks [2][]byte
+	for i, buf := range []*bytes.Buffer{&sh.out, &sh.errOut} {
+		b := buf.Bytes()
+		end := len(b)
+		for _, m := range []string{sh.marker, sh.errMarker} {
+			if k := bytes.Index(b[offsets[i]:], []byte(m)); k >= 0 {
+				end = min(end, offsets[i]+k)
+			}
+			for k := min(len(m)-1, len(b)); k > 0; k-- {
+				if bytes.HasSuffix(b, []byte(m[:k])) {
+					end = min(end, len(b)-k)
+					break
+				}
+			}
+		}
+		if end > offsets[i] {
+			chunks[i] = bytes.Clone(b[offsets[i]:end])
+			offsets[i] = end
+		}
+	}
+	sh.mu.Unlock()
+	sw.Write(chunks[0])
+	sw.Write(chunks[1])
+}
+
+// next removes the output of the current command from the buffers once its
+// markers and exit status have been printed, returning found if the stdout
+// marker has been printed. The stderr marker is not required if force is
+// true, as the command may have redirected the stderr of the shell.
+func (sh *shell) next(force bool) (stdout, stderr string, status int, found, ok bool) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	b := sh.out.Bytes()
+	i := bytes.Index(b, []byte(sh.marker))
+	if i < 0 {
+		return "", "", 0, false, false
+	}
+	j := bytes.IndexByte(b[i:], '\n')
+	if j < 0 {
+		return "", "", 0, false, false
+	}
+	e := sh.errOut.Bytes()
+	k := bytes.Index(e, []byte(sh.errMarker+"\n"))
+	if k < 0 && !force {
+		return "", "", 0, true, false
+	}
+
+	status, err := strconv.Atoi(string(b[i+len(sh.marker) : i+j]))
+	if err != nil {
+		status = -1
+	}
+	stdout = sh.strip(string(b[:i]))
+	sh.out.Next(i + j + 1)
+	if k < 0 {
+		stderr = sh.strip(string(e))
+		sh.errOut.Reset()
+	} else {
+		stderr = string(e[:k])
+		sh.errOut.Next(k + len(sh.errMarker) + 1)
+	}
+	return stdout, stderr, status, true, true
+}
+
+// drain removes and returns everything in the buffers.
+func (sh *shell) drain() (string, string) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	stdout, stderr := sh.strip(sh.out.String()), sh.strip(sh.errOut.String())
+	sh.out.Reset()
+	sh.errOut.Reset()
+	return stdout, stderr
+}
+
+// strip removes stderr markers that were redirected by an earlier command.
+func (sh *shell) strip(output string) string {
+	return strings.ReplaceAll(output, sh.errMarker+"\n", "")
+}
+
+func (sh *shell) exited() bool {
+	select {
+	case <-sh.exit:
+		return true
+	default:
+		return false
+	}
+}
+
+// kill kills the process group of the shell and waits for it to exit.
+func (sh *shell) kill() {
+	_ = syscall.Kill(-sh.cmd.Process.Pid, syscall.SIGKILL)
+	sh.stdin.Close()
+	<-sh.exit
+}
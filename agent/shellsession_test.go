@@ -0,0 +1,222 @@
+package agent_test
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/acrmp/minimalprompt/agent"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("ShellSession", func() {
+	var (
+		dir       string
+		session   *agent.ShellSession
+		logger    *slog.Logger
+		logOutput *gbytes.Buffer
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "session")
+		Expect(err).ToNot(HaveOccurred())
+		dir, err = filepath.EvalSymlinks(dir)
+		Expect(err).ToNot(HaveOccurred())
+
+		logOutput = gbytes.NewBuffer()
+		logger = slog.New(slog.NewTextHandler(logOutput, nil))
+
+		session = agent.NewShellSession(logger, dir)
+	})
+
+	AfterEach(func() {
+		Expect(session.Close()).To(Succeed())
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	execute := func(command string) (agent.CommandResult, error) {
+		return session.Execute(context.Background(), command)
+	}
+
+	It("executes the provided command", func() {
+		r, err := execute("printf 'hello world'")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Stdout).To(Equal("hello world"))
+	})
+
+	It("logs that it is executing the command", func() {
+		_, err := execute("printf 'hello world'")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(logOutput).To(gbytes.Say(`executing command.*printf 'hello world'`))
+	})
+
+	It("captures stdout and stderr separately", func() {
+		r, err := execute("echo hello\necho world >&2\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Stdout).To(Equal("hello\n"))
+		Expect(r.Stderr).To(Equal("world\n"))
+	})
+
+	It("starts in the directory", func() {
+		r, err := execute("pwd")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Stdout).To(Equal(dir + "\n"))
+	})
+
+	It("keeps the working directory between commands", func() {
+		_, err := execute("mkdir sub && cd sub")
+		Expect(err).ToNot(HaveOccurred())
+
+		r, err := execute("pwd")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Stdout).To(Equal(filepath.Join(dir, "sub") + "\n"))
+	})
+
+	It("keeps environment variables and functions between commands", func() {
+		_, err := execute("export GREETING=hello; greet() { echo \"$GREETING $1\"; }")
+		Expect(err).ToNot(HaveOccurred())
+
+		r, err := execute("greet world")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Stdout).To(Equal("hello world\n"))
+	})
+
+	It("handles quotes and here documents", func() {
+		r, err := execute("cat <<'EOF'\nit's \"quoted\"\nEOF")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Stdout).To(Equal("it's \"quoted\"\n"))
+	})
+
+	It("does not let commands read the shell input", func() {
+		r, err := execute("cat; echo done")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Stdout).To(Equal("done\n"))
+	})
+
+	It("does not pass secrets to the shell", func() {
+		GinkgoT().Setenv("ANTHROPIC_API_KEY", "sk-ant-secret")
+		r, err := execute("env")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Stdout).To(ContainSubstring("PATH="))
+		Expect(r.Stdout).ToNot(ContainSubstring("ANTHROPIC_API_KEY"))
+	})
+
+	Context("when the output is streamed", func() {
+		var stream *gbytes.Buffer
+
+		BeforeEach(func() {
+			stream = gbytes.NewBuffer()
+			session = agent.NewShellSession(logger, dir, agent.WithOutputStream(stream))
+		})
+
+		It("writes the output of each command as it arrives without the marker", func() {
+			done := make(chan string)
+			go func() {
+				defer GinkgoRecover()
+				r, err := execute("echo compiling; sleep 1; echo ok")
+				Expect(err).ToNot(HaveOccurred())
+				done <- r.Stdout
+			}()
+
+			Eventually(stream).Should(gbytes.Say(`│ \x1b\[2mcompiling\x1b\[0m\n`))
+			Expect(done).ToNot(Receive())
+			Eventually(done, 5*time.Second).Should(Receive(Equal("compiling\nok\n")))
+
+			r, err := execute("printf partial; false")
+			Expect(err).To(MatchError("exit status 1"))
+			Expect(r.Stdout).To(Equal("partial"))
+			Expect(string(stream.Contents())).To(Equal("│ \x1b[2mcompiling\x1b[0m\n│ \x1b[2mok\x1b[0m\n│ \x1b[2mpartial\x1b[0m\n"))
+		})
+	})
+
+	Context("when the command redirects the stderr of the shell", func() {
+		It("keeps capturing the output of later commands", func() {
+			_, err := execute("exec 2>&1")
+			Expect(err).ToNot(HaveOccurred())
+			r, err := execute("echo out; echo err >&2")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Stdout).To(Equal("out\nerr\n"))
+
+			_, err = execute("exec 2>/dev/null")
+			Expect(err).ToNot(HaveOccurred())
+			r, err = execute("echo out; echo err >&2")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Stdout).To(Equal("out\n"))
+			Expect(r.Stderr).To(BeEmpty())
+		})
+	})
+
+	Context("when the command exits with a non-zero exit code", func() {
+		It("errors with the exit status", func() {
+			r, err := execute("printf 'still captured'; (exit 3)")
+			Expect(err).To(MatchError("exit status 3"))
+			Expect(r.Stdout).To(Equal("still captured"))
+			Expect(r.ExitCode).To(Equal(3))
+		})
+
+		It("keeps the session", func() {
+			_, err := execute("cd /; false")
+			Expect(err).To(HaveOccurred())
+
+			r, err := execute("pwd")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Stdout).To(Equal("/\n"))
+		})
+	})
+
+	Context("when the command has a syntax error", func() {
+		It("errors and keeps the session", func() {
+			_, err := execute("echo 'unterminated")
+			Expect(err).To(MatchError("exit status 2"))
+
+			r, err := execute("echo ok")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Stdout).To(Equal("ok\n"))
+		})
+	})
+
+	Context("when the command exits the shell", func() {
+		It("restarts the shell in the directory for the next command", func() {
+			_, err := execute("cd / && echo bye && exit 0")
+			Expect(err).To(MatchError("the shell exited"))
+
+			r, err := execute("pwd")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Stdout).To(Equal(dir + "\n"))
+			Expect(r.Stderr).To(Equal("[the shell exited and was restarted, the working directory and environment were reset]\n"))
+			Expect(logOutput).To(gbytes.Say(`restarting shell`))
+		})
+	})
+
+	Context("when the command does not finish before the deadline", func() {
+		It("kills the shell and returns the output so far", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			r, err := session.Execute(ctx, "echo started; sleep 30")
+			Expect(err).To(MatchError(agent.ErrCommandTimedOut))
+			Expect(r.Stdout).To(Equal("started\n"))
+
+			r, err = execute("echo recovered")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Stdout).To(Equal("recovered\n"))
+			Expect(r.Stderr).To(Equal("[the shell exited and was restarted, the working directory and environment were reset]\n"))
+		})
+	})
+
+	Context("when the context has no deadline", func() {
+		BeforeEach(func() {
+			session = agent.NewShellSession(logger, dir, agent.WithTimeout(200*time.Millisecond))
+		})
+
+		It("applies the default timeout", func() {
+			_, err := execute("sleep 30")
+			Expect(err).To(MatchError(agent.ErrCommandTimedOut))
+		})
+	})
+})
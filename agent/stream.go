@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// streamPrefix marks each line of streamed command output.
+const streamPrefix = "│ "
+
+// A streamWriter copies command output to a terminal as it arrives, with
+// each line prefixed and dimmed to set it apart from the rest of the
+// conversation.
+type streamWriter struct {
+	mu          sync.Mutex
+	w           io.Writer
+	midLine     bool
+	writeFailed bool
+}
+
+// newStreamWriter creates a streamWriter that writes to w. It returns nil if
+// w is nil so that streaming is skipped.
+func newStreamWriter(w io.Writer) *streamWriter {
+	if w == nil {
+		return nil
+	}
+	return &streamWriter{w: w}
+}
+
+// Write writes p to the terminal a line at a time. It never fails, so that
+// a broken terminal does not interrupt the command.
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if s == nil {
+		return len(p), nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b bytes.Buffer
+	for rest := p; len(rest) > 0; {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+		}
+		rest = rest[len(line):]
+		if !s.midLine {
+			b.WriteString(streamPrefix)
+		}
+		b.WriteString("\x1b[2m")
+		b.Write(bytes.TrimSuffix(line, []byte("\n")))
+		b.WriteString("\x1b[0m")
+		s.midLine = line[len(line)-1] != '\n'
+		if !s.midLine {
+			b.WriteByte('\n')
+		}
+	}
+	s.write(b.Bytes())
+	return len(p), nil
+}
+
+// finish ends a final line that has no newline.
+func (s *streamWriter) finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.midLine {
+		s.write([]byte("\n"))
+		s.midLine = false
+	}
+}
+
+func (s *streamWriter) write(b []byte) {
+	if s.writeFailed {
+		return
+	}
+	if _, err := s.w.Write(b); err != nil {
+		s.writeFailed = true
+	}
+}
+
+// IsTerminal reports whether f is a terminal, so that command output can be
+// streamed to it. Other character devices, such as /dev/null, are not.
+func IsTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), ioctlReadTermios)
+	return err == nil
+}
@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// A Tool is a capability that the model can invoke.
+//
+//counterfeiter:generate . Tool
+type Tool interface {
+	// Definition describes the tool and its parameters to the model.
+	Definition() llms.FunctionDefinition
+	// Call decodes the JSON arguments provided by the model and performs
+	// the tool call, returning the content to share with the model.
+	Call(ctx context.Context, arguments string) (string, error)
+}
+
+// An ArgumentError is returned by a Tool when the arguments provided by the
+// model cannot be decoded.
+type ArgumentError struct {
+	Err error
+}
+
+func (e *ArgumentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ArgumentError) Unwrap() error {
+	return e.Err
+}
+
+// A FunctionTool is a Tool that decodes the model arguments into a value of
+// type A before calling a function. The parameters advertised to the model
+// are derived from A with SchemaFor.
+type FunctionTool[A any] struct {
+	definition llms.FunctionDefinition
+	fn         func(ctx context.Context, args A) (string, error)
+}
+
+// NewFunctionTool creates a FunctionTool with the name and description
+// that calls fn with the decoded arguments.
+func NewFunctionTool[A any](name, description string, fn func(ctx context.Context, args A) (string, error)) *FunctionTool[A] {
+	return &FunctionTool[A]{
+		definition: llms.FunctionDefinition{
+			Name:        name,
+			Description: description,
+			Parameters:  SchemaFor[A](),
+		},
+		fn: fn,
+	}
+}
+
+// Definition returns the definition of the tool.
+func (t *FunctionTool[A]) Definition() llms.FunctionDefinition {
+	return t.definition
+}
+
+// Call decodes arguments and calls the function of the tool.
+// It returns an ArgumentError if the arguments cannot be decoded.
+func (t *FunctionTool[A]) Call(ctx context.Context, arguments string) (string, error) {
+	var args A
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return "", &ArgumentError{Err: err}
+	}
+	return t.fn(ctx, args)
+}
+
+// validateArguments checks the JSON arguments provided by the model against
+// the parameters in the tool definition.
+// It returns an ArgumentError if the arguments cannot be decoded.
+func validateArguments(def llms.FunctionDefinition, arguments string) error {
+	var v any
+	if err := json.Unmarshal([]byte(arguments), &v); err != nil {
+		return &ArgumentError{Err: err}
+	}
+	s, err := parametersSchema(def.Parameters)
+	if err != nil || s == nil {
+		return nil
+	}
+	return s.Validate(v)
+}
+
+// A ToolRegistry holds the tools that are made available to the model.
+type ToolRegistry struct {
+	tools map[string]Tool
+	names []string
+}
+
+// NewToolRegistry creates an empty ToolRegistry.
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{tools: map[string]Tool{}}
+}
+
+// Register adds the tools to the registry.
+// It errors if a tool has the same name as one already registered.
+func (r *ToolRegistry) Register(tools ...Tool) error {
+	for _, t := range tools {
+		name := t.Definition().Name
+		if _, ok := r.tools[name]; ok {
+			return fmt.Errorf("tool already registered: %q", name)
+		}
+		r.tools[name] = t
+		r.names = append(r.names, name)
+	}
+	return nil
+}
+
+// Lookup returns the tool registered with name.
+func (r *ToolRegistry) Lookup(name string) (Tool, bool) {
+	t, ok := r.tools[name]
+	return t, ok
+}
+
+// Definitions returns the definitions of the registered tools in the order
+// they were registered.
+func (r *ToolRegistry) Definitions() []llms.Tool {
+	defs := make([]llms.Tool, 0, len(r.names))
+	for _, name := range r.names {
+		d := r.tools[name].Definition()
+		defs = append(defs, llms.Tool{Type: "function", Function: &d})
+	}
+	return defs
+}
+
+// Close closes the registered tools that implement io.Closer, such as tools
+// that manage processes, in the reverse order they were registered.
+func (r *ToolRegistry) Close() error {
+	var errs []error
+	for i := len(r.names) - 1; i >= 0; i-- {
+		if c, ok := r.tools[r.names[i]].(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("closing tool %q: %w", r.names[i], err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
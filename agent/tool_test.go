@@ -0,0 +1,165 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/acrmp/minimalprompt/agent"
+	"github.com/acrmp/minimalprompt/agent/agentfakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/tmc/langchaingo/llms"
+)
+
+type closableTool struct {
+	*agentfakes.FakeTool
+	close func() error
+}
+
+func (t closableTool) Close() error {
+	return t.close()
+}
+
+var _ = Describe("ToolRegistry", func() {
+	var (
+		registry     *agent.ToolRegistry
+		ticketLookup *agentfakes.FakeTool
+		deploy       *agentfakes.FakeTool
+	)
+
+	BeforeEach(func() {
+		registry = agent.NewToolRegistry()
+
+		ticketLookup = &agentfakes.FakeTool{}
+		ticketLookup.DefinitionReturns(llms.FunctionDefinition{Name: "lookupTicket", Description: "Look up a ticket"})
+		deploy = &agentfakes.FakeTool{}
+		deploy.DefinitionReturns(llms.FunctionDefinition{Name: "deploy", Description: "Deploy the app"})
+	})
+
+	It("looks up registered tools by name", func() {
+		Expect(registry.Register(ticketLookup, deploy)).To(Succeed())
+
+		t, ok := registry.Lookup("deploy")
+		Expect(ok).To(BeTrue())
+		Expect(t).To(Equal(deploy))
+
+		_, ok = registry.Lookup("accelerate")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("provides the tool definitions in the order they were registered", func() {
+		Expect(registry.Register(ticketLookup)).To(Succeed())
+		Expect(registry.Register(deploy)).To(Succeed())
+
+		defs := registry.Definitions()
+		Expect(defs).To(HaveLen(2))
+		Expect(defs[0].Type).To(Equal("function"))
+		Expect(defs[0].Function.Name).To(Equal("lookupTicket"))
+		Expect(defs[1].Type).To(Equal("function"))
+		Expect(defs[1].Function.Name).To(Equal("deploy"))
+	})
+
+	Describe("closing", func() {
+		var closed []string
+
+		closable := func(t *agentfakes.FakeTool, err error) agent.Tool {
+			return closableTool{FakeTool: t, close: func() error {
+				closed = append(closed, t.Definition().Name)
+				return err
+			}}
+		}
+
+		BeforeEach(func() {
+			closed = nil
+		})
+
+		It("closes the tools that implement io.Closer in reverse order", func() {
+			unclosable := &agentfakes.FakeTool{}
+			unclosable.DefinitionReturns(llms.FunctionDefinition{Name: "search"})
+			Expect(registry.Register(closable(ticketLookup, nil), unclosable, closable(deploy, nil))).To(Succeed())
+
+			Expect(registry.Close()).To(Succeed())
+			Expect(closed).To(Equal([]string{"deploy", "lookupTicket"}))
+		})
+
+		Context("when a tool fails to close", func() {
+			It("closes the other tools and errors", func() {
+				Expect(registry.Register(closable(ticketLookup, nil), closable(deploy, errors.New("still running")))).To(Succeed())
+
+				Expect(registry.Close()).To(MatchError(`closing tool "deploy": still running`))
+				Expect(closed).To(Equal([]string{"deploy", "lookupTicket"}))
+			})
+		})
+	})
+
+	Context("when a tool with the same name is already registered", func() {
+		It("errors", func() {
+			Expect(registry.Register(ticketLookup)).To(Succeed())
+			Expect(registry.Register(ticketLookup)).To(MatchError(`tool already registered: "lookupTicket"`))
+		})
+	})
+})
+
+var _ = Describe("FunctionTool", func() {
+	type ticketArgs struct {
+		ID       string `json:"id" description:"The ticket ID"`
+		Comments bool   `json:"comments,omitempty"`
+	}
+
+	var (
+		tool   *agent.FunctionTool[ticketArgs]
+		called ticketArgs
+	)
+
+	BeforeEach(func() {
+		tool = agent.NewFunctionTool(
+			"lookupTicket",
+			"Look up a ticket",
+			func(ctx context.Context, args ticketArgs) (string, error) {
+				called = args
+				if args.ID == "missing" {
+					return "", errors.New("no such ticket")
+				}
+				return "Ticket " + args.ID + ": Make it yellow", nil
+			},
+		)
+	})
+
+	It("has the provided name and description", func() {
+		Expect(tool.Definition().Name).To(Equal("lookupTicket"))
+		Expect(tool.Definition().Description).To(Equal("Look up a ticket"))
+	})
+
+	It("derives the parameters from the argument type", func() {
+		Expect(tool.Definition().Parameters).To(Equal(&agent.Schema{
+			Type: "object",
+			Properties: map[string]*agent.Schema{
+				"id":       {Type: "string", Description: "The ticket ID"},
+				"comments": {Type: "boolean"},
+			},
+			Required: []string{"id"},
+		}))
+	})
+
+	It("calls the function with the decoded arguments", func() {
+		content, err := tool.Call(context.Background(), `{"id":"T-1"}`)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(called.ID).To(Equal("T-1"))
+		Expect(content).To(Equal("Ticket T-1: Make it yellow"))
+	})
+
+	Context("when the function errors", func() {
+		It("errors", func() {
+			_, err := tool.Call(context.Background(), `{"id":"missing"}`)
+			Expect(err).To(MatchError("no such ticket"))
+		})
+	})
+
+	Context("when the arguments cannot be decoded", func() {
+		It("returns an ArgumentError", func() {
+			_, err := tool.Call(context.Background(), `{"not json"`)
+			var argErr *agent.ArgumentError
+			Expect(errors.As(err, &argErr)).To(BeTrue())
+		})
+	})
+})
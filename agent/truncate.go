@@ -0,0 +1,201 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultHeadLines = 100
+	defaultTailLines = 100
+	defaultHeadBytes = 16 * 1024
+	defaultTailBytes = 16 * 1024
+)
+
+// A TruncatingExecutor limits the command output shared with the model.
+// When the output of a command is longer than the head and tail lines
+// combined, or than the head and tail bytes combined, the middle is replaced
+// with a marker and the full output is saved to a file in the artifacts
+// directory so that the model can page through it.
+type TruncatingExecutor struct {
+	logger    *slog.Logger
+	next      CommandExecutor
+	dir       string
+	head      int
+	tail      int
+	headBytes int
+	tailBytes int
+}
+
+// A TruncatingExecutorOption configures a TruncatingExecutor.
+type TruncatingExecutorOption func(*TruncatingExecutor)
+
+// WithHeadLines sets the number of lines kept from the start of the output.
+func WithHeadLines(n int) TruncatingExecutorOption {
+	return func(t *TruncatingExecutor) {
+		t.head = n
+	}
+}
+
+// WithTailLines sets the number of lines kept from the end of the output.
+func WithTailLines(n int) TruncatingExecutorOption {
+	return func(t *TruncatingExecutor) {
+		t.tail = n
+	}
+}
+
+// WithHeadBytes sets the number of bytes kept from the start of the output,
+// so that a few very long lines are also truncated.
+func WithHeadBytes(n int) TruncatingExecutorOption {
+	return func(t *TruncatingExecutor) {
+		t.headBytes = n
+	}
+}
+
+// WithTailBytes sets the number of bytes kept from the end of the output.
+func WithTailBytes(n int) TruncatingExecutorOption {
+	return func(t *TruncatingExecutor) {
+		t.tailBytes = n
+	}
+}
+
+// NewTruncatingExecutor creates a TruncatingExecutor that executes commands
+// with next. The full output of truncated commands is saved to the artifacts
+// directory specified with dir.
+func NewTruncatingExecutor(logger *slog.Logger, next CommandExecutor, dir string, opts ...TruncatingExecutorOption) *TruncatingExecutor {
+	t := &TruncatingExecutor{
+		logger:    logger,
+		next:      next,
+		dir:       dir,
+		head:      defaultHeadLines,
+		tail:      defaultTailLines,
+		headBytes: defaultHeadBytes,
+		tailBytes: defaultTailBytes,
+	}
+	for _, o := range opts {
+		o(t)
+	}
+	return t
+}
+
+// Execute executes the command and truncates its stdout and stderr.
+// The error from the command is returned unchanged. If the full output cannot
+// be saved it is truncated anyway and the marker says so.
+func (t *TruncatingExecutor) Execute(ctx context.Context, command string) (CommandResult, error) {
+	r, err := t.next.Execute(ctx, command)
+	return t.truncateResult(r), err
+}
+
+// A TruncatingProgramRunner limits the program output shared with the model
+// in the same way as a TruncatingExecutor.
+type TruncatingProgramRunner struct {
+	next ProgramRunner
+	te   *TruncatingExecutor
+}
+
+// NewTruncatingProgramRunner creates a TruncatingProgramRunner that runs
+// programs with next. The full output of truncated programs is saved to the
+// artifacts directory specified with dir.
+func NewTruncatingProgramRunner(logger *slog.Logger, next ProgramRunner, dir string, opts ...TruncatingExecutorOption) *TruncatingProgramRunner {
+	return &TruncatingProgramRunner{next: next, te: NewTruncatingExecutor(logger, nil, dir, opts...)}
+}
+
+// RunProgram runs the program and truncates its stdout and stderr.
+// The error from the program is returned unchanged.
+func (t *TruncatingProgramRunner) RunProgram(ctx context.Context, argv []string) (CommandResult, error) {
+	r, err := t.next.RunProgram(ctx, argv)
+	return t.te.truncateResult(r), err
+}
+
+func (t *TruncatingExecutor) truncateResult(r CommandResult) CommandResult {
+	r.Stdout = t.truncate(r.Stdout, "stdout")
+	r.Stderr = t.truncate(r.Stderr, "stderr")
+	return r
+}
+
+// truncate truncates the output from the named stream. The head and tail
+// are cut at line boundaries unless they are longer than the byte limits.
+func (t *TruncatingExecutor) truncate(output, stream string) string {
+	lines := strings.SplitAfter(output, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) <= t.head+t.tail && len(output) <= t.headBytes+t.tailBytes {
+		return output
+	}
+
+	headEnd := 0
+	for _, l := range lines[:min(t.head, len(lines))] {
+		headEnd += len(l)
+	}
+	tailStart := len(output)
+	for _, l := range lines[len(lines)-min(t.tail, len(lines)):] {
+		tailStart -= len(l)
+	}
+	if headEnd > t.headBytes {
+		headEnd = t.headBytes
+		for headEnd > 0 && !utf8.RuneStart(output[headEnd]) {
+			headEnd--
+		}
+	}
+	if len(output)-tailStart > t.tailBytes {
+		tailStart = len(output) - t.tailBytes
+		for tailStart < len(output) && !utf8.RuneStart(output[tailStart]) {
+			tailStart++
+		}
+	}
+	if headEnd >= tailStart {
+		return output
+	}
+
+	atLines := (headEnd == 0 || output[headEnd-1] == '\n') && output[tailStart-1] == '\n'
+	omitted := fmt.Sprintf("%d bytes", tailStart-headEnd)
+	if atLines {
+		omitted = fmt.Sprintf("%d lines", strings.Count(output[headEnd:tailStart], "\n"))
+	}
+	marker := fmt.Sprintf("[truncated: %s omitted, the full output could not be saved]\n", omitted)
+	if path, saveErr := t.save(output, stream); saveErr != nil {
+		t.logger.Warn("saving command output", "err", saveErr)
+	} else if atLines {
+		first := strings.Count(output[:headEnd], "\n") + 1
+		marker = fmt.Sprintf("[truncated: lines %d-%d of %d omitted, the full output is saved to %s, read it with readFile to see more]\n",
+			first, strings.Count(output[:tailStart], "\n"), len(lines), path)
+	} else {
+		marker = fmt.Sprintf("[truncated: bytes %d-%d of %d omitted, the full output is saved to %s, read it with readFile to see more]\n",
+			headEnd+1, tailStart, len(output), path)
+	}
+
+	var b strings.Builder
+	b.WriteString(output[:headEnd])
+	if headEnd > 0 && output[headEnd-1] != '\n' {
+		b.WriteString("\n")
+	}
+	b.WriteString(marker)
+	b.WriteString(output[tailStart:])
+	return b.String()
+}
+
+// save writes the output from the named stream to a new file in the
+// artifacts directory and returns its path.
+func (t *TruncatingExecutor) save(output, stream string) (string, error) {
+	if err := os.MkdirAll(t.dir, 0700); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(t.dir, "command-*."+stream+".txt")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(output); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	t.logger.Info("saved command output", "path", f.Name())
+	return f.Name(), nil
+}
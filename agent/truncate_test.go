@@ -0,0 +1,184 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/acrmp/minimalprompt/agent"
+	"github.com/acrmp/minimalprompt/agent/agentfakes"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("TruncatingExecutor", func() {
+	var (
+		dir       string
+		next      *agentfakes.FakeCommandExecutor
+		te        *agent.TruncatingExecutor
+		logger    *slog.Logger
+		logOutput *gbytes.Buffer
+	)
+
+	numberedLines := func(n int) string {
+		var b strings.Builder
+		for i := 1; i <= n; i++ {
+			fmt.Fprintf(&b, "line %d\n", i)
+		}
+		return b.String()
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "artifacts")
+		Expect(err).ToNot(HaveOccurred())
+		dir = filepath.Join(dir, "session")
+
+		logOutput = gbytes.NewBuffer()
+		logger = slog.New(slog.NewTextHandler(logOutput, nil))
+
+		next = &agentfakes.FakeCommandExecutor{}
+		te = agent.NewTruncatingExecutor(logger, next, dir, agent.WithHeadLines(2), agent.WithTailLines(3))
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(filepath.Dir(dir))).To(Succeed())
+	})
+
+	It("executes the command with the next executor", func() {
+		next.ExecuteReturns(agent.CommandResult{Stdout: "ok\n"}, nil)
+		ctx := context.WithValue(context.Background(), struct{}{}, "marker")
+
+		r, err := te.Execute(ctx, "whoami")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Stdout).To(Equal("ok\n"))
+
+		Expect(next.ExecuteCallCount()).To(Equal(1))
+		c, command := next.ExecuteArgsForCall(0)
+		Expect(c).To(Equal(ctx))
+		Expect(command).To(Equal("whoami"))
+	})
+
+	It("does not truncate output within the limits", func() {
+		next.ExecuteReturns(agent.CommandResult{Stdout: numberedLines(5)}, nil)
+		r, err := te.Execute(context.Background(), "seq")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Stdout).To(Equal(numberedLines(5)))
+		Expect(dir).ToNot(BeADirectory())
+	})
+
+	Context("when the output is longer than the limits", func() {
+		BeforeEach(func() {
+			next.ExecuteReturns(agent.CommandResult{Stdout: numberedLines(10)}, errors.New("exit status 1"))
+		})
+
+		It("keeps the head and tail of the output", func() {
+			r, err := te.Execute(context.Background(), "seq")
+			Expect(err).To(MatchError("exit status 1"))
+
+			lines := strings.Split(r.Stdout, "\n")
+			Expect(lines).To(HaveLen(7))
+			Expect(lines[:2]).To(Equal([]string{"line 1", "line 2"}))
+			Expect(lines[2]).To(MatchRegexp(`^\[truncated: lines 3-7 of 10 omitted, the full output is saved to .*, read it with readFile to see more\]$`))
+			Expect(lines[3:]).To(Equal([]string{"line 8", "line 9", "line 10", ""}))
+		})
+
+		It("saves the full output to the artifacts directory", func() {
+			r, _ := te.Execute(context.Background(), "seq")
+
+			path := regexp.MustCompile(`saved to (\S+),`).FindStringSubmatch(r.Stdout)[1]
+			Expect(filepath.Dir(path)).To(Equal(dir))
+			b, err := os.ReadFile(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(b)).To(Equal(numberedLines(10)))
+			Expect(logOutput).To(gbytes.Say(`saved command output.*` + regexp.QuoteMeta(path)))
+		})
+
+		It("truncates stderr separately", func() {
+			next.ExecuteReturns(agent.CommandResult{Stdout: "ok\n", Stderr: numberedLines(10), ExitCode: 1}, errors.New("exit status 1"))
+			r, _ := te.Execute(context.Background(), "seq >&2")
+			Expect(r.Stdout).To(Equal("ok\n"))
+			Expect(r.ExitCode).To(Equal(1))
+			Expect(r.Stderr).To(MatchRegexp(`^line 1\nline 2\n\[truncated: lines 3-7 of 10 omitted, the full output is saved to .*\.stderr\.txt, read it with readFile to see more\]\nline 8\n`))
+		})
+
+		It("saves each command output to a separate file", func() {
+			_, _ = te.Execute(context.Background(), "seq")
+			_, _ = te.Execute(context.Background(), "seq")
+
+			entries, err := os.ReadDir(dir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(HaveLen(2))
+		})
+
+		It("can be paged through with a file reader", func() {
+			r, _ := te.Execute(context.Background(), "seq")
+			path := regexp.MustCompile(`saved to (\S+),`).FindStringSubmatch(r.Stdout)[1]
+
+			fr := agent.NewSimpleFileReader(logger, GinkgoT().TempDir(), agent.WithReadableDir(dir))
+			content, err := fr.ReadFile(path, 3, 4)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(content).To(Equal("     3\tline 3\n     4\tline 4\n"))
+		})
+	})
+
+	Context("when a line is longer than the byte limits", func() {
+		BeforeEach(func() {
+			te = agent.NewTruncatingExecutor(logger, next, dir, agent.WithHeadBytes(4), agent.WithTailBytes(3))
+			next.ExecuteReturns(agent.CommandResult{Stdout: "0123456789abcdef"}, nil)
+		})
+
+		It("keeps the head and tail bytes of the output", func() {
+			r, err := te.Execute(context.Background(), "cat minified.json")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Stdout).To(MatchRegexp(`^0123\n\[truncated: bytes 5-13 of 16 omitted, the full output is saved to .*, read it with readFile to see more\]\ndef$`))
+
+			path := regexp.MustCompile(`saved to (\S+),`).FindStringSubmatch(r.Stdout)[1]
+			b, err := os.ReadFile(path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(b)).To(Equal("0123456789abcdef"))
+		})
+
+		It("does not split characters", func() {
+			next.ExecuteReturns(agent.CommandResult{Stdout: "ééééééé"}, nil)
+			r, _ := te.Execute(context.Background(), "cat accents.txt")
+			Expect(r.Stdout).To(MatchRegexp(`^éé\n\[truncated: bytes 5-12 of 14 omitted, .*\]\né$`))
+		})
+	})
+
+	Context("when the output cannot be saved", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(filepath.Dir(dir), "session"), nil, 0600)).To(Succeed())
+			next.ExecuteReturns(agent.CommandResult{Stdout: numberedLines(10)}, nil)
+		})
+
+		It("still truncates the output", func() {
+			r, err := te.Execute(context.Background(), "seq")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Stdout).To(Equal("line 1\nline 2\n[truncated: 5 lines omitted, the full output could not be saved]\nline 8\nline 9\nline 10\n"))
+			Expect(logOutput).To(gbytes.Say(`saving command output`))
+		})
+	})
+})
+
+var _ = Describe("TruncatingProgramRunner", func() {
+	It("truncates the output of programs", func() {
+		dir := filepath.Join(GinkgoT().TempDir(), "session")
+		next := &agentfakes.FakeProgramRunner{}
+		next.RunProgramReturns(agent.CommandResult{Stdout: "1\n2\n3\n4\n5\n", ExitCode: 1}, errors.New("exit status 1"))
+		tr := agent.NewTruncatingProgramRunner(slog.New(slog.NewTextHandler(GinkgoWriter, nil)), next, dir, agent.WithHeadLines(1), agent.WithTailLines(1))
+
+		r, err := tr.RunProgram(context.Background(), []string{"seq", "5"})
+		Expect(err).To(MatchError("exit status 1"))
+		Expect(r.ExitCode).To(Equal(1))
+		Expect(r.Stdout).To(MatchRegexp(`^1\n\[truncated: lines 2-4 of 5 omitted, the full output is saved to .*\.stdout\.txt, read it with readFile to see more\]\n5\n$`))
+		_, argv := next.RunProgramArgsForCall(0)
+		Expect(argv).To(Equal([]string{"seq", "5"}))
+	})
+})
@@ -7,17 +7,35 @@ WARNING: It dangerously provides the LLM access to write files and run
 commands. Any usage is at your own risk.
 
 It will prompt the user if the LLM will not proceed without a prompt. Send
-an EOF (CTRL-D) to end the prompt message.
+an EOF (CTRL-D) to end the prompt message. An interrupt (CTRL-C), even at
+the prompt, kills the running command and stops the LLM, cleaning up
+background processes.
+
+Optional flags, listed with -h, must precede the arguments.
+
+The changes made to files are recorded in a journal in the session directory.
+They can be undone with -undo or -undo-turn given the session directory as
+the only argument.
 */
 package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/lmittmann/tint"
+	"golang.org/x/sys/unix"
 
 	"github.com/acrmp/minimalprompt/agent"
 	"github.com/tmc/langchaingo/llms/anthropic"
@@ -25,46 +43,363 @@ import (
 
 const anthropicVersion = "claude-3-5-sonnet-20240620"
 
+// secretsFDVariable names the file descriptor that the re-executed program
+// reads the model provider API keys from.
+const secretsFDVariable = "MINIMALPROMPT_SECRETS_FD"
+
 func printUsageAndExit() {
 	fmt.Fprintf(os.Stderr, "minimalprompt [SYSTEM PROMPT] [INITIAL PROMPT] [OUTPUT DIR]\n")
+	fmt.Fprintf(os.Stderr, "minimalprompt -undo N | -undo-turn TURN [SESSION DIR]\n")
+	flag.PrintDefaults()
 	os.Exit(1)
 }
 
 func main() {
 	logger := slog.New(tint.NewHandler(os.Stderr, nil))
 
-	if len(os.Args) != 4 {
+	secrets, err := hideSecrets()
+	if err != nil {
+		logger.Error("hiding API keys", "err", err)
+		os.Exit(1)
+	}
+
+	plugins := flag.String("plugins", "", "path to a YAML manifest of external tool plugins")
+	approve := flag.Bool("approve", false, "ask before running each command or changing each file, showing changes as a diff")
+	environment := flag.String("environment", "", "path to a YAML file of environment variables to pass to or set for commands")
+	policy := flag.String("policy", "", "path to a YAML command policy of allowed and denied commands")
+	shell := flag.String("shell", agent.DefaultShell, "path or name of the shell that runs commands, looked up in PATH")
+	headLines := flag.Int("output-head", 100, "lines kept from the start of long command output")
+	tailLines := flag.Int("output-tail", 100, "lines kept from the end of long command output")
+	sandbox := flag.Bool("sandbox", false, "run each command in a Linux namespace sandbox where only the output directory is writable, disabling background processes")
+	network := flag.Bool("sandbox-network", false, "allow sandboxed commands to access the network")
+	cpuLimit := flag.Duration("limit-cpu", 0, "CPU time each command process may use, 0 for no limit")
+	memoryLimit := flag.Int64("limit-memory", 0, "MiB of memory commands may use, 0 for no limit")
+	processLimit := flag.Int("limit-processes", 0, "number of processes commands may run at once, 0 for no limit")
+	fileSizeLimit := flag.Int64("limit-file-size", 0, "MiB size of the largest file commands may write, 0 for no limit")
+	cgroup := flag.Bool("limit-cgroup", false, "apply the memory and process limits to all commands together in a delegated cgroup v2 group")
+	checkpoint := flag.Bool("git-checkpoint", false, "when the output directory is a git repository, commit the changes of each turn to a new branch for the session")
+	undo := flag.Int("undo", 0, "undo the last N file changes recorded in the session directory")
+	undoTurn := flag.Int("undo-turn", 0, "undo the file changes recorded in the session directory since the start of TURN")
+	flag.Usage = printUsageAndExit
+	flag.Parse()
+
+	if *undo > 0 || *undoTurn > 0 {
+		if flag.NArg() != 1 {
+			printUsageAndExit()
+		}
+		undoChanges(logger, filepath.Join(flag.Arg(0), "journal"), *undo, *undoTurn)
+		return
+	}
+
+	if flag.NArg() != 3 {
 		printUsageAndExit()
 	}
 
-	sp, err := os.ReadFile(os.Args[1])
+	sp, err := os.ReadFile(flag.Arg(0))
 	if err != nil {
 		printUsageAndExit()
 	}
-	p, err := os.ReadFile(os.Args[2])
+	p, err := os.ReadFile(flag.Arg(1))
 	if err != nil {
 		printUsageAndExit()
 	}
-	d := os.Args[3]
+	d := flag.Arg(2)
 
-	m, err := anthropic.New(anthropic.WithModel(anthropicVersion))
+	modelOpts := []anthropic.Option{anthropic.WithModel(anthropicVersion)}
+	if key, ok := secrets["ANTHROPIC_API_KEY"]; ok {
+		modelOpts = append(modelOpts, anthropic.WithToken(key))
+	}
+	m, err := anthropic.New(modelOpts...)
 	if err != nil {
 		logger.Error("initializing model", "err", err)
 		os.Exit(1)
 	}
 
+	session, err := newSessionDir()
+	if err != nil {
+		logger.Error("creating session directory", "err", err)
+		os.Exit(1)
+	}
+	logger.Info("session directory", "path", session)
+	artifacts := filepath.Join(session, "artifacts")
+	journal, err := agent.NewJournal(logger, filepath.Join(session, "journal"), d)
+	if err != nil {
+		logger.Error("creating journal", "err", err)
+		os.Exit(1)
+	}
+
+	var env *agent.Environment
+	if *environment != "" {
+		env, err = agent.LoadEnvironment(*environment)
+		if err != nil {
+			logger.Error("loading environment", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	wrapperOpts := []agent.LLMWrapperOption{agent.WithTurnObserver(journal)}
+	var checkpointer *agent.GitCheckpointer
+	if *checkpoint {
+		git := agent.NewGitDriver(logger, d, agent.WithGitEnvironment(env))
+		if git.IsRepo() {
+			checkpointer, err = agent.NewGitCheckpointer(logger, git, "minimalprompt/"+filepath.Base(session))
+			if err != nil {
+				logger.Error("starting git checkpoints", "err", err)
+				os.Exit(1)
+			}
+			wrapperOpts = append(wrapperOpts, agent.WithTurnObserver(checkpointer))
+		} else {
+			logger.Warn("not checkpointing as the output directory is not a git repository")
+		}
+	}
+
+	limits := agent.Limits{
+		CPUTime:   *cpuLimit,
+		Memory:    *memoryLimit * 1024 * 1024,
+		Processes: *processLimit,
+		FileSize:  *fileSizeLimit * 1024 * 1024,
+	}
+	var cg *agent.Cgroup
+	if *cgroup {
+		cg, err = agent.NewSessionCgroup(filepath.Base(session), limits)
+		if err != nil {
+			logger.Warn("limiting memory and processes with rlimits", "err", err)
+		} else {
+			limits.Cgroup = cg
+		}
+	}
+
+	shellPath, err := exec.LookPath(*shell)
+	if err != nil {
+		logger.Error("finding shell", "err", err)
+		os.Exit(1)
+	}
+
+	execOpts := []agent.ExecOption{agent.WithShell(shellPath), agent.WithLimits(limits), agent.WithEnvironment(env)}
+	if agent.IsTerminal(os.Stdout) {
+		execOpts = append(execOpts, agent.WithOutputStream(os.Stdout))
+	}
+
+	sh := agent.NewShellSession(logger, d, execOpts...)
+	var (
+		executor agent.CommandExecutor = sh
+		runner   agent.ProgramRunner   = agent.NewBashExecutor(logger, d, execOpts...)
+	)
+	if *sandbox {
+		if *network {
+			execOpts = append(execOpts, agent.WithNetwork())
+		}
+		sb := agent.NewSandboxExecutor(logger, d, execOpts...)
+		if err := sb.Check(); err != nil {
+			logger.Error("creating sandbox", "err", err)
+			os.Exit(1)
+		}
+		executor, runner = sb, sb
+	}
+
+	prompter := agent.NewTerminalPrompter(os.Stdin, os.Stdout)
+	fw := agent.NewSimpleFileWriter(logger, d)
+	jw := agent.NewJournalingFileWriter(journal, fw, fw)
+	var (
+		pm     agent.ProcessManager = agent.NewBashProcessManager(logger, d, execOpts...)
+		writer agent.FileWriter     = jw
+		editor agent.FileEditor     = jw
+	)
+	if *approve {
+		approver := agent.NewPrompterApprover(prompter)
+		executor = agent.NewApprovingExecutor(logger, executor, approver)
+		runner = agent.NewApprovingProgramRunner(logger, runner, approver)
+		pm = agent.NewApprovingProcessManager(logger, pm, approver)
+		aw := agent.NewApprovingFileWriter(logger, d, jw, jw, approver)
+		writer, editor = aw, aw
+	}
+	if *policy != "" {
+		pol, err := agent.LoadPolicy(*policy)
+		if err != nil {
+			logger.Error("loading command policy", "err", err)
+			os.Exit(1)
+		}
+		executor = agent.NewPolicyExecutor(logger, executor, pol)
+		runner = agent.NewPolicyProgramRunner(logger, runner, pol)
+		pm = agent.NewPolicyProcessManager(logger, pm, pol)
+	}
+
+	tools := agent.NewToolRegistry()
+	err = tools.Register(agent.BuiltinTools(
+		agent.NewTruncatingExecutor(
+			logger,
+			executor,
+			artifacts,
+			agent.WithHeadLines(*headLines),
+			agent.WithTailLines(*tailLines),
+		),
+		writer,
+		editor,
+		agent.NewSimpleFileReader(logger, d, agent.WithReadableDir(artifacts)),
+	)...)
+	if err == nil {
+		err = tools.Register(agent.NewRunProgramTool(agent.NewTruncatingProgramRunner(
+			logger,
+			runner,
+			artifacts,
+			agent.WithHeadLines(*headLines),
+			agent.WithTailLines(*tailLines),
+		)))
+	}
+	if err == nil && !*sandbox {
+		err = tools.Register(agent.ProcessTools(pm)...)
+	}
+	if err != nil {
+		logger.Error("registering tools", "err", err)
+		os.Exit(1)
+	}
+	if *plugins != "" {
+		pts, err := agent.LoadPlugins(logger, *plugins, d, agent.WithEnvironment(env))
+		if err != nil {
+			logger.Error("loading plugins", "err", err)
+			os.Exit(1)
+		}
+		if err := tools.Register(pts...); err != nil {
+			logger.Error("registering plugins", "err", err)
+			os.Exit(1)
+		}
+	}
+
 	a := agent.NewLLMWrapper(
 		logger,
 		string(sp),
 		string(p),
 		m,
-		agent.NewBashExecutor(logger, d),
-		agent.NewSimpleFileWriter(logger, d),
-		agent.NewTerminalPrompter(os.Stdin, os.Stdout),
+		tools,
+		prompter,
+		wrapperOpts...,
 	)
 
-	if err = a.Run(context.Background()); err != nil {
+	// The first signal cancels the run, which kills the running command and
+	// stops waiting at the prompt. Later signals are only logged, so that
+	// background processes are always killed and the cgroup removed.
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		logger.Warn("stopping")
+		cancel()
+		for range signals {
+			logger.Warn("still stopping, cleaning up processes")
+		}
+	}()
+	err = a.Run(ctx)
+	cancel()
+	sh.Close()
+	if cg != nil {
+		cg.Close()
+	}
+	if checkpointer != nil {
+		s := checkpointer.Summary()
+		logger.Info("git checkpoints", "branch", s.Branch, "from", s.From, "commits", s.Commits, "range", s.Range())
+	}
+	if err != nil {
 		logger.Error("running agent", "err", err)
 		os.Exit(1)
 	}
 }
+
+// hideSecrets re-executes the program without the model provider API keys
+// in its environment, passing them in an unlinked file instead, so that
+// commands cannot read them from the environment of their parent process in
+// /proc. Once re-executed it returns the keys by name.
+func hideSecrets() (map[string]string, error) {
+	secrets := map[string]string{}
+	if fd := os.Getenv(secretsFDVariable); fd != "" {
+		os.Unsetenv(secretsFDVariable)
+		n, err := strconv.Atoi(fd)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", secretsFDVariable, err)
+		}
+		f := os.NewFile(uintptr(n), "secrets")
+		b, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, kv := range strings.Split(string(b), "\x00") {
+			if name, value, ok := strings.Cut(kv, "="); ok {
+				secrets[name] = value
+			}
+		}
+		return secrets, nil
+	}
+
+	var env, hidden []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if agent.IsSecretVariable(name) {
+			hidden = append(hidden, kv)
+		} else {
+			env = append(env, kv)
+		}
+	}
+	if len(hidden) == 0 {
+		return secrets, nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp("", "minimalprompt-secrets")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return nil, err
+	}
+	if _, err := f.WriteString(strings.Join(hidden, "\x00")); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	// The file must stay open across exec.
+	if _, err := unix.FcntlInt(f.Fd(), unix.F_SETFD, 0); err != nil {
+		return nil, err
+	}
+	env = append(env, fmt.Sprintf("%s=%d", secretsFDVariable, f.Fd()))
+	return nil, syscall.Exec(exe, os.Args, env)
+}
+
+// newSessionDir creates a directory outside of the output directory to hold
+// the artifacts of this session.
+func newSessionDir() (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		base = os.TempDir()
+	}
+	base = filepath.Join(base, "minimalprompt", "sessions")
+	if err := os.MkdirAll(base, 0700); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(base, time.Now().Format("20060102-150405-"))
+}
+
+// undoChanges undoes the last n changes recorded in the journal, or those
+// since turn if n is 0, and exits if they cannot be undone.
+func undoChanges(logger *slog.Logger, dir string, n, turn int) {
+	journal, err := agent.LoadJournal(logger, dir)
+	if err != nil {
+		logger.Error("loading journal", "err", err)
+		os.Exit(1)
+	}
+	var undone []agent.JournalChange
+	if n > 0 {
+		undone, err = journal.Undo(n)
+	} else {
+		undone, err = journal.UndoSince(turn)
+	}
+	if err != nil {
+		logger.Error("undoing changes", "err", err)
+		os.Exit(1)
+	}
+	logger.Info("undid changes", "count", len(undone), "dir", journal.Root())
+}
@@ -1,6 +1,8 @@
 package main_test
 
 import (
+	"context"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -10,6 +12,8 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gbytes"
 	"github.com/onsi/gomega/gexec"
+
+	"github.com/acrmp/minimalprompt/agent"
 )
 
 var _ = Describe("main", func() {
@@ -81,4 +85,102 @@ var _ = Describe("main", func() {
 			Eventually(session.Err).Should(gbytes.Say("ANTHROPIC_API_KEY"))
 		})
 	})
+	Context("when the plugin manifest cannot be loaded", func() {
+		It("outputs an error about the plugins", func() {
+			command := exec.Command(promptCLI, "-plugins", filepath.Join(dir, "missing.yaml"), sysPath, initPath, outputPath)
+			command.Env = []string{"ANTHROPIC_API_KEY=some-key", "PATH=" + os.Getenv("PATH")}
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(1))
+			Eventually(session.Err).Should(gbytes.Say("loading plugins"))
+		})
+	})
+	Context("when the shell cannot be found", func() {
+		It("outputs an error about the shell", func() {
+			command := exec.Command(promptCLI, "-shell", "no-such-shell", sysPath, initPath, outputPath)
+			command.Env = []string{"ANTHROPIC_API_KEY=some-key", "PATH=" + os.Getenv("PATH")}
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(1))
+			Eventually(session.Err).Should(gbytes.Say(`finding shell.*no-such-shell`))
+		})
+	})
+	Context("when checkpointing to git with uncommitted changes", func() {
+		It("outputs an error about the checkpoints", func() {
+			if _, err := exec.LookPath("git"); err != nil {
+				Skip("git is not installed")
+			}
+			Expect(exec.Command("git", "init", "--quiet", outputPath).Run()).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(outputPath, "stock.txt"), nil, 0600)).To(Succeed())
+
+			command := exec.Command(promptCLI, "-git-checkpoint", sysPath, initPath, outputPath)
+			command.Env = []string{"ANTHROPIC_API_KEY=some-key", "PATH=" + os.Getenv("PATH")}
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(1))
+			Eventually(session.Err).Should(gbytes.Say(`starting git checkpoints`))
+		})
+	})
+	Context("when a command reads the environment of its parent", func() {
+		It("does not find the API key", func() {
+			bin := filepath.Join(dir, "bin")
+			Expect(os.Mkdir(bin, 0700)).To(Succeed())
+			environ := filepath.Join(dir, "environ")
+			Expect(os.WriteFile(filepath.Join(bin, "git"), []byte("#!/bin/sh\ntr '\\0' '\\n' </proc/$PPID/environ >"+environ+"\nexit 1\n"), 0700)).To(Succeed())
+
+			command := exec.Command(promptCLI, "-git-checkpoint", sysPath, initPath, outputPath)
+			command.Env = []string{"ANTHROPIC_API_KEY=sk-ant-secret", "PATH=" + bin + ":" + os.Getenv("PATH")}
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(session.Err).Should(gbytes.Say("not checkpointing"))
+			session.Kill()
+
+			b, err := os.ReadFile(environ)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(b)).To(ContainSubstring("PATH="))
+			Expect(string(b)).ToNot(ContainSubstring("sk-ant-secret"))
+		})
+	})
+	Context("when undoing changes", func() {
+		var session string
+
+		BeforeEach(func() {
+			session = filepath.Join(dir, "session")
+			journal, err := agent.NewJournal(slog.New(slog.NewTextHandler(GinkgoWriter, nil)), filepath.Join(session, "journal"), outputPath)
+			Expect(err).ToNot(HaveOccurred())
+			fw := agent.NewSimpleFileWriter(slog.New(slog.NewTextHandler(GinkgoWriter, nil)), outputPath)
+			jw := agent.NewJournalingFileWriter(journal, fw, fw)
+			journal.StartTurn(1)
+			Expect(jw.WriteFile(context.Background(), "stock.txt", "10 strawberries\n", false)).To(Succeed())
+			journal.StartTurn(2)
+			Expect(jw.WriteFile(context.Background(), "stock.txt", "9 strawberries\n", false)).To(Succeed())
+		})
+
+		It("undoes the last changes in the session directory", func() {
+			command := exec.Command(promptCLI, "-undo", "1", session)
+			s, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(s).Should(gexec.Exit(0))
+			Expect(s.Err).To(gbytes.Say(`undid changes`))
+			Expect(os.ReadFile(filepath.Join(outputPath, "stock.txt"))).To(Equal([]byte("10 strawberries\n")))
+		})
+
+		It("undoes the changes since a turn", func() {
+			command := exec.Command(promptCLI, "-undo-turn", "1", session)
+			s, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(s).Should(gexec.Exit(0))
+			Expect(filepath.Join(outputPath, "stock.txt")).ToNot(BeAnExistingFile())
+		})
+
+		Context("when the session directory has no journal", func() {
+			It("outputs an error about the journal", func() {
+				command := exec.Command(promptCLI, "-undo", "1", dir)
+				s, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).ToNot(HaveOccurred())
+				Eventually(s).Should(gexec.Exit(1))
+				Expect(s.Err).To(gbytes.Say("loading journal"))
+			})
+		})
+	})
 })